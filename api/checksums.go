@@ -0,0 +1,125 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/danesparza/package-assistant/internal/debian"
+	"github.com/danesparza/package-assistant/internal/upload"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// ChecksumSHA256Header is the SHA256 (hex encoded) the client expects an upload to have
+	ChecksumSHA256Header = "X-Checksum-Sha256"
+
+	// DigestHeader (RFC 3230) has the checksums the client expects an upload to have, like
+	// 'sha-256=<base64>'.  The checksums of the upload are sent back in it, too.
+	DigestHeader = "Digest"
+)
+
+// digestAlgorithms maps the RFC 3230 digest algorithms we know to checksum algorithms
+var digestAlgorithms = map[string]string{
+	"md5":     upload.AlgorithmMD5,
+	"sha":     upload.AlgorithmSHA1,
+	"sha-256": upload.AlgorithmSHA256,
+	"sha-512": upload.AlgorithmSHA512,
+}
+
+// uploadCheck is what the client expects an upload to be
+type uploadCheck struct {
+	Checksums    []upload.Checksum
+	Package      string
+	Version      string
+	Architecture string
+
+	// Overwrite is set if the client expects to replace a different package file published as the same version
+	Overwrite bool
+}
+
+// uploadCheckFromRequest reads what the client expects an upload to be: the checksums from the
+// Digest and X-Checksum-Sha256 headers, and the package, version, architecture and overwrite
+// params (read with 'param', as they come from the form or the query depending on the endpoint)
+func uploadCheckFromRequest(req *http.Request, param func(string) string) (uploadCheck, error) {
+	retval := uploadCheck{
+		Checksums:    []upload.Checksum{},
+		Package:      strings.TrimSpace(param("package")),
+		Version:      strings.TrimSpace(param("version")),
+		Architecture: strings.TrimSpace(param("architecture")),
+	}
+
+	if value := param("overwrite"); value != "" {
+		overwrite, err := strconv.ParseBool(value)
+		if err != nil {
+			return retval, fmt.Errorf("overwrite must be true or false (got '%s')", value)
+		}
+		retval.Overwrite = overwrite
+	}
+
+	if value := strings.TrimSpace(req.Header.Get(ChecksumSHA256Header)); value != "" {
+		if sum, err := hex.DecodeString(value); err != nil || len(sum) != 32 {
+			return retval, fmt.Errorf("%s must be a hex encoded SHA256 (got '%s')", ChecksumSHA256Header, value)
+		}
+		retval.Checksums = append(retval.Checksums, upload.Checksum{Algorithm: upload.AlgorithmSHA256, Value: value})
+	}
+
+	//	Digest is a list of algorithm=base64 pairs.  Algorithms we don't know are ignored.
+	for _, digest := range req.Header.Values(DigestHeader) {
+		for _, part := range strings.Split(digest, ",") {
+			name, value, found := strings.Cut(strings.TrimSpace(part), "=")
+			if !found {
+				return retval, fmt.Errorf("bad %s header '%s'", DigestHeader, part)
+			}
+
+			algorithm, ok := digestAlgorithms[strings.ToLower(name)]
+			if !ok {
+				continue
+			}
+
+			sum, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return retval, fmt.Errorf("%s %s must be base64 encoded (got '%s')", DigestHeader, name, value)
+			}
+			retval.Checksums = append(retval.Checksums, upload.Checksum{Algorithm: algorithm, Value: hex.EncodeToString(sum)})
+		}
+	}
+
+	return retval, nil
+}
+
+// verifyChecksums makes sure the upload has the checksums the client expects
+func (check uploadCheck) verifyChecksums(digests upload.Digests) error {
+	return digests.Verify(check.Checksums...)
+}
+
+// verifyPackage makes sure the upload is the package, version and architecture the client expects
+func (check uploadCheck) verifyPackage(info debian.PackageInfo) error {
+	if check.Package != "" && check.Package != info.Package {
+		return fmt.Errorf("expected package %s, got %s", check.Package, info.Package)
+	}
+	if check.Version != "" && debian.CompareVersions(check.Version, info.Version) != 0 {
+		return fmt.Errorf("expected version %s, got %s", check.Version, info.Version)
+	}
+	if check.Architecture != "" && check.Architecture != info.Architecture {
+		return fmt.Errorf("expected architecture %s, got %s", check.Architecture, info.Architecture)
+	}
+
+	return nil
+}
+
+// digestHeader formats the digests of an upload for the Digest header
+func digestHeader(digests upload.Digests) string {
+	parts := []string{}
+	for _, name := range []string{"md5", "sha", "sha-256", "sha-512"} {
+		value, _ := digests.Get(digestAlgorithms[name])
+		sum, err := hex.DecodeString(value)
+		if err != nil {
+			continue
+		}
+		parts = append(parts, name+"="+base64.StdEncoding.EncodeToString(sum))
+	}
+
+	return strings.Join(parts, ",")
+}
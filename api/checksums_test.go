@@ -0,0 +1,258 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/danesparza/package-assistant/internal/debian"
+	"github.com/danesparza/package-assistant/internal/files"
+	"github.com/danesparza/package-assistant/internal/publish"
+	"github.com/danesparza/package-assistant/internal/upload"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testPackage is a .deb (foo 1.0 amd64) from the debian package's testdata
+var testPackage = filepath.Join("..", "internal", "debian", "testdata", "foo_1.0_amd64.deb")
+
+// testDigests works out the digests of the file
+func testDigests(t *testing.T, name string) upload.Digests {
+	t.Helper()
+
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	h := upload.NewHasher()
+	if _, err := io.Copy(h, f); err != nil {
+		t.Fatal(err)
+	}
+
+	return h.Digests()
+}
+
+func TestUploadCheckFromRequest(t *testing.T) {
+	sha256Hex := strings.Repeat("ab", 32)
+	sha256Base64 := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("\xab", 32)))
+	md5Base64 := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("\x01", 16)))
+	md5Hex := strings.Repeat("01", 16)
+
+	tests := []struct {
+		name    string
+		headers map[string][]string
+		params  map[string]string
+		want    uploadCheck
+		wantErr string
+	}{
+		{
+			name: "nothing to check",
+			want: uploadCheck{Checksums: []upload.Checksum{}},
+		},
+		{
+			name:    "hex sha256",
+			headers: map[string][]string{ChecksumSHA256Header: {" " + sha256Hex + " "}},
+			want:    uploadCheck{Checksums: []upload.Checksum{{Algorithm: upload.AlgorithmSHA256, Value: sha256Hex}}},
+		},
+		{
+			name:    "hex sha256 that's too short",
+			headers: map[string][]string{ChecksumSHA256Header: {"abab"}},
+			wantErr: "must be a hex encoded SHA256",
+		},
+		{
+			name:    "hex sha256 that isn't hex",
+			headers: map[string][]string{ChecksumSHA256Header: {strings.Repeat("zz", 32)}},
+			wantErr: "must be a hex encoded SHA256",
+		},
+		{
+			name:    "digest",
+			headers: map[string][]string{DigestHeader: {"sha-256=" + sha256Base64}},
+			want:    uploadCheck{Checksums: []upload.Checksum{{Algorithm: upload.AlgorithmSHA256, Value: sha256Hex}}},
+		},
+		{
+			name:    "digest with several algorithms",
+			headers: map[string][]string{DigestHeader: {"MD5=" + md5Base64 + ", SHA-256=" + sha256Base64}},
+			want: uploadCheck{Checksums: []upload.Checksum{
+				{Algorithm: upload.AlgorithmMD5, Value: md5Hex},
+				{Algorithm: upload.AlgorithmSHA256, Value: sha256Hex},
+			}},
+		},
+		{
+			name: "digest headers and a hex sha256",
+			headers: map[string][]string{
+				ChecksumSHA256Header: {sha256Hex},
+				DigestHeader:         {"md5=" + md5Base64, "sha-256=" + sha256Base64},
+			},
+			want: uploadCheck{Checksums: []upload.Checksum{
+				{Algorithm: upload.AlgorithmSHA256, Value: sha256Hex},
+				{Algorithm: upload.AlgorithmMD5, Value: md5Hex},
+				{Algorithm: upload.AlgorithmSHA256, Value: sha256Hex},
+			}},
+		},
+		{
+			name:    "unknown digest algorithms are ignored",
+			headers: map[string][]string{DigestHeader: {"unixsum=30637,sha-256=" + sha256Base64}},
+			want:    uploadCheck{Checksums: []upload.Checksum{{Algorithm: upload.AlgorithmSHA256, Value: sha256Hex}}},
+		},
+		{
+			name:    "digest without a value",
+			headers: map[string][]string{DigestHeader: {"sha-256"}},
+			wantErr: "bad Digest header 'sha-256'",
+		},
+		{
+			name:    "digest that isn't base64",
+			headers: map[string][]string{DigestHeader: {"sha-256=" + sha256Hex + "!"}},
+			wantErr: "must be base64 encoded",
+		},
+		{
+			name:   "package, version and architecture",
+			params: map[string]string{"package": " foo ", "version": "1:1.0", "architecture": "amd64", "overwrite": "true"},
+			want:   uploadCheck{Checksums: []upload.Checksum{}, Package: "foo", Version: "1:1.0", Architecture: "amd64", Overwrite: true},
+		},
+		{
+			name:    "overwrite that isn't a bool",
+			params:  map[string]string{"overwrite": "please"},
+			wantErr: "overwrite must be true or false",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/package", nil)
+			for name, values := range tt.headers {
+				for _, value := range values {
+					req.Header.Add(name, value)
+				}
+			}
+
+			got, err := uploadCheckFromRequest(req, func(name string) string { return tt.params[name] })
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("uploadCheckFromRequest() error = %v, want '%s'", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("uploadCheckFromRequest() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("uploadCheckFromRequest() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyChecksums(t *testing.T) {
+	digests := testDigests(t, testPackage)
+
+	tests := []struct {
+		name      string
+		checksums []upload.Checksum
+		wantErr   bool
+	}{
+		{name: "nothing to check"},
+		{name: "matching sha256", checksums: []upload.Checksum{{Algorithm: upload.AlgorithmSHA256, Value: digests.SHA256}}},
+		{name: "matching sha256 in upper case", checksums: []upload.Checksum{{Algorithm: upload.AlgorithmSHA256, Value: strings.ToUpper(digests.SHA256)}}},
+		{name: "matching md5 and sha512", checksums: []upload.Checksum{{Algorithm: upload.AlgorithmMD5, Value: digests.MD5}, {Algorithm: upload.AlgorithmSHA512, Value: digests.SHA512}}},
+		{name: "mismatched sha256", checksums: []upload.Checksum{{Algorithm: upload.AlgorithmSHA256, Value: strings.Repeat("0", 64)}}, wantErr: true},
+		{name: "one of several mismatched", checksums: []upload.Checksum{{Algorithm: upload.AlgorithmSHA256, Value: digests.SHA256}, {Algorithm: upload.AlgorithmSHA1, Value: digests.MD5}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := uploadCheck{Checksums: tt.checksums}.verifyChecksums(digests)
+			if tt.wantErr != errors.Is(err, upload.ErrChecksumMismatch) {
+				t.Errorf("verifyChecksums() error = %v, want a mismatch: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDigestHeader(t *testing.T) {
+	digests := testDigests(t, testPackage)
+
+	//	What we send back can be checked by reading it as a Digest header
+	req := httptest.NewRequest(http.MethodPost, "/v1/package", nil)
+	req.Header.Set(DigestHeader, digestHeader(digests))
+	check, err := uploadCheckFromRequest(req, func(string) string { return "" })
+	if err != nil {
+		t.Fatalf("uploadCheckFromRequest() error = %v", err)
+	}
+	if len(check.Checksums) != 4 {
+		t.Errorf("digestHeader() = %s, want all 4 digests", req.Header.Get(DigestHeader))
+	}
+	if err := check.verifyChecksums(digests); err != nil {
+		t.Errorf("verifyChecksums() error = %v", err)
+	}
+
+	sum, _ := hex.DecodeString(digests.SHA256)
+	if want := "sha-256=" + base64.StdEncoding.EncodeToString(sum); !strings.Contains(digestHeader(digests), want) {
+		t.Errorf("digestHeader() = %s, want it to have %s", digestHeader(digests), want)
+	}
+}
+
+func TestQueueUploadMismatch(t *testing.T) {
+	digests := testDigests(t, testPackage)
+
+	tests := []struct {
+		name    string
+		check   uploadCheck
+		wantErr string
+	}{
+		{
+			name:    "checksum",
+			check:   uploadCheck{Checksums: []upload.Checksum{{Algorithm: upload.AlgorithmSHA256, Value: strings.Repeat("0", 64)}}},
+			wantErr: "checksum mismatch",
+		},
+		{
+			name:    "package",
+			check:   uploadCheck{Package: "bar"},
+			wantErr: "expected package bar, got foo",
+		},
+		{
+			name:    "version",
+			check:   uploadCheck{Version: "1.1"},
+			wantErr: "expected version 1.1, got 1.0",
+		},
+		{
+			name:    "architecture",
+			check:   uploadCheck{Architecture: "arm64"},
+			wantErr: "expected architecture arm64, got amd64",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uploadFile := filepath.Join(t.TempDir(), "upload.deb")
+			if err := files.Copy(testPackage, uploadFile, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			//	It's turned away before the job is queued, so there's no cache to talk to
+			job := publish.NewUploadJob(uploadFile, "foo_1.0_amd64.deb", debian.Target{})
+			req := httptest.NewRequest(http.MethodPost, "/v1/package", nil)
+			rw := httptest.NewRecorder()
+			Service{}.queueUpload(rw, req, debian.Archive{}, job, "foo_1.0_amd64.deb", digests, tt.check)
+
+			if rw.Code != http.StatusUnprocessableEntity {
+				t.Errorf("queueUpload() status = %d, want %d", rw.Code, http.StatusUnprocessableEntity)
+			}
+			if !strings.Contains(rw.Body.String(), tt.wantErr) {
+				t.Errorf("queueUpload() response = %s, want '%s'", rw.Body.String(), tt.wantErr)
+			}
+			if rw.Header().Get(DigestHeader) != digestHeader(digests) {
+				t.Errorf("queueUpload() Digest = %s, want %s", rw.Header().Get(DigestHeader), digestHeader(digests))
+			}
+			if _, err := os.Stat(uploadFile); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("the upload is still there after it was turned away")
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/danesparza/package-assistant/internal/cache"
+	"github.com/danesparza/package-assistant/internal/publish"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"time"
+)
+
+// jobPollInterval is how often we check on a job we're waiting for
+const jobPollInterval = 500 * time.Millisecond
+
+// GetJob godoc
+// @Summary Get the status of a job
+// @Description Get the status of a background job (like publishing an uploaded package), including the progress of each stage
+// @Tags jobs
+// @Produce  json
+// @Param id path string true "The job id"
+// @Success 200 {object} api.SystemResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /jobs/{id} [get]
+func (service Service) GetJob(rw http.ResponseWriter, req *http.Request) {
+	id := chi.URLParam(req, "id")
+
+	job, err := service.Cache.GetJob(req.Context(), id)
+	if errors.Is(err, cache.ErrJobNotFound) {
+		sendErrorResponse(rw, fmt.Errorf("job '%s' not found", id), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusInternalServerError)
+		return
+	}
+
+	response := SystemResponse{
+		Message: fmt.Sprintf("Job %s", job.State),
+		Data:    job,
+	}
+
+	//	Serialize to JSON & return the response:
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(rw).Encode(response)
+}
+
+// waitForJob polls the job until it's done or the context is cancelled
+func (service Service) waitForJob(ctx context.Context, id string) (*cache.Job, error) {
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+
+	for {
+		job, err := service.Cache.GetJob(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		if job.Done() {
+			return job, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("stopped waiting for job %s: %w", id, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// jobErrorStatus is the http status code to use for a failed job
+func jobErrorStatus(job *cache.Job) int {
+	switch job.ErrorCode {
+	case publish.ErrorCodeLockTimeout:
+		return http.StatusConflict
+	case publish.ErrorCodeLockUnavailable:
+		return http.StatusServiceUnavailable
+	case publish.ErrorCodeVersionExists:
+		return http.StatusConflict
+	}
+
+	return http.StatusInternalServerError
+}
+
+// sendJobErrorResponse is used to send back the error from a failed job, including the stage it failed in
+func sendJobErrorResponse(rw http.ResponseWriter, job *cache.Job) {
+	//	Our return value
+	response := ErrorResponse{
+		Message: "Error: " + job.Error,
+		Stage:   job.FailedStage,
+	}
+
+	//	Serialize to JSON & return the response:
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	rw.Header().Set("Location", jobLocation(job.ID))
+	rw.WriteHeader(jobErrorStatus(job))
+	json.NewEncoder(rw).Encode(response)
+}
+
+// jobLocation is the url to check on the job with the given id
+func jobLocation(id string) string {
+	return "/v1/jobs/" + id
+}
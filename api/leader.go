@@ -0,0 +1,40 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// GetLeader godoc
+// @Summary Get the leader
+// @Description Shows which instance is the leader (the one that runs scheduled maintenance tasks), and whether it's the instance answering the request
+// @Tags tasks
+// @Produce  json
+// @Success 200 {object} api.SystemResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /leader [get]
+func (service Service) GetLeader(rw http.ResponseWriter, req *http.Request) {
+	status, err := service.Election.Status(req.Context())
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusInternalServerError)
+		return
+	}
+
+	message := "There is no leader right now"
+	switch {
+	case status.IsLeader:
+		message = fmt.Sprintf("This instance (%s) is the leader", status.Instance)
+	case status.Leader != "":
+		message = fmt.Sprintf("%s is the leader", status.Leader)
+	}
+
+	response := SystemResponse{
+		Message: message,
+		Data:    status,
+	}
+
+	//	Serialize to JSON & return the response:
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(rw).Encode(response)
+}
@@ -1,48 +1,83 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/danesparza/package-assistant/internal/cache"
+	"github.com/danesparza/package-assistant/internal/catalog"
 	"github.com/danesparza/package-assistant/internal/debian"
+	"github.com/danesparza/package-assistant/internal/publish"
+	"github.com/danesparza/package-assistant/internal/upload"
+	"github.com/go-chi/chi/v5"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/viper"
 	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
+	"strconv"
 	"strings"
 )
 
+const (
+	// IdempotencyKeyHeader lets a client retry an upload safely: a request with the same key as an
+	// earlier one gets the earlier one's job instead of publishing the package again
+	IdempotencyKeyHeader = "Idempotency-Key"
+
+	// IdempotentReplayedHeader is set on responses with the job of an earlier request with the same idempotency key
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	// maxIdempotencyKeyLength is the longest idempotency key we accept
+	maxIdempotencyKeyLength = 255
+)
+
 // UploadPackage godoc
 // @Summary Upload a package
-// @Description Upload package
+// @Description Upload a package.  The package is validated and then published in the background (as <Package>_<Version>_<Architecture>.deb, whatever the file was called) -- use the job in the response to follow along, or pass wait=true to wait for publishing to finish.
 // @Tags package
 // @Accept  mpfd
 // @Produce  json
 // @Param file formData file true "The file to upload"
-// @Success 200 {object} api.SystemResponse
+// @Param suite formData string false "The suite to publish to (pool layout only, defaults to repo.defaultsuite)"
+// @Param component formData string false "The component to publish to (pool layout only, defaults to repo.defaultcomponent)"
+// @Param wait query bool false "Wait for the package to be published before responding"
+// @Param package formData string false "The package name the upload should have"
+// @Param version formData string false "The version the upload should have"
+// @Param architecture formData string false "The architecture the upload should have"
+// @Param X-Checksum-Sha256 header string false "The SHA256 the upload should have (hex encoded)"
+// @Param Digest header string false "Checksums the upload should have (RFC 3230, like 'sha-256=<base64>')"
+// @Param overwrite formData bool false "Replace a package file already published as the same version with different content"
+// @Param Idempotency-Key header string false "A key for the upload -- retrying the same upload with the same key gets the same job instead of publishing again (unless the job failed for a reason that may go away)"
+// @Success 200 {object} api.SystemResponse "The same package file is already published, so nothing changed (wait=true only)"
+// @Success 201 {object} api.SystemResponse
+// @Success 202 {object} api.SystemResponse
 // @Failure 400 {object} api.ErrorResponse
+// @Failure 409 {object} api.ErrorResponse "A package file with different content is already published as the same version, or the repo lock was held by another operation for too long (wait=true only)"
 // @Failure 413 {object} api.ErrorResponse
+// @Failure 422 {object} api.ErrorResponse "The upload doesn't match the checksums, package, version or architecture it should have, or the Idempotency-Key was already used for a different upload"
 // @Failure 500 {object} api.ErrorResponse
+// @Failure 503 {object} api.ErrorResponse "The repo lock is unavailable (wait=true only)"
 // @Router /package [post]
 func (service Service) UploadPackage(rw http.ResponseWriter, req *http.Request) {
 
 	//	Get configs
 	MAX_UPLOAD_SIZE := viper.GetInt64("upload.bytelimit")
 	UploadPath := viper.GetString("upload.path")
-	RepoPath := viper.GetString("github.projectfolder")
-	gpgPassword := viper.GetString("gpg.password")
-	gitName := viper.GetString("git.name")
-	gitEmail := viper.GetString("git.email")
-	githubUser := viper.GetString("github.user")
-	githubPassword := viper.GetString("github.password")
+	archive := debian.ArchiveFromConfig()
 
 	//	First check the auth token and make sure it exists on the header:
-	log.Debug().Msg("Validating X-PackAuth header")
-	authToken := req.Header.Get("X-PackAuth")
-	if strings.TrimSpace(authToken) != strings.TrimSpace(viper.GetString("auth.token")) {
-		err := fmt.Errorf("X-PackAuth token invalid")
-		sendErrorResponse(rw, err, http.StatusUnauthorized)
+	if !checkAuthToken(rw, req) {
+		return
+	}
+
+	//	Make sure any idempotency key is one we can use before reading the upload
+	if _, err := idempotencyKeyHeader(req); err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
 		return
 	}
 
@@ -55,6 +90,20 @@ func (service Service) UploadPackage(rw http.ResponseWriter, req *http.Request)
 		return
 	}
 
+	//	Figure out where the package is going (form fields or query params)
+	target, err := archive.ResolveTarget(req.FormValue("suite"), req.FormValue("component"))
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
+	//	See what the client expects the package to be, so we can check it
+	check, err := uploadCheckFromRequest(req, req.FormValue)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
 	// FormFile returns the first file for the given key `file`
 	// it also returns the FileHeader so we can get the Filename,
 	// the Header and the size of the file
@@ -67,6 +116,13 @@ func (service Service) UploadPackage(rw http.ResponseWriter, req *http.Request)
 	}
 	defer file.Close()
 
+	//	Go drops any folders from the file name -- but a name with folders is a bad upload, so check what was sent
+	filename, err := multipartFilename(fileHeader)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
 	// Create the uploads folder if it doesn't
 	// already exist
 	log.Debug().Str("UploadPath", UploadPath).Msg("Creating upload path if it doesn't exist")
@@ -77,8 +133,13 @@ func (service Service) UploadPackage(rw http.ResponseWriter, req *http.Request)
 		return
 	}
 
-	// Create a new file in the uploads directory
-	destinationFile := path.Join(UploadPath, fileHeader.Filename)
+	// Create a new file in the uploads directory.  It's named after the job
+	// so uploads of the same file don't trip over each other while queued
+	// (and so nothing the client sent ends up in the path).
+	job := publish.NewUploadJob("", filename, target)
+	destinationFile := uploadDestination(UploadPath, job)
+	job.Params[publish.ParamFile] = destinationFile
+
 	log.Debug().Str("destination file", destinationFile).Msg("Creating file in uploads directory")
 	dst, err := os.Create(destinationFile)
 	if err != nil {
@@ -91,68 +152,392 @@ func (service Service) UploadPackage(rw http.ResponseWriter, req *http.Request)
 	// Copy the uploaded file to the filesystem
 	// at the specified destination
 	log.Debug().Msg("Copying file data to the destination file")
-	_, err = io.Copy(dst, file)
+	hasher := upload.NewHasher()
+	size, err := io.Copy(io.MultiWriter(dst, hasher), file)
 	if err != nil {
+		os.Remove(destinationFile)
 		err = fmt.Errorf("error saving file: %w", err)
 		sendErrorResponse(rw, err, http.StatusInternalServerError)
 		return
 	}
+	dst.Close()
+
+	job.Params[publish.ParamSize] = strconv.FormatInt(size, 10)
+	service.queueUpload(rw, req, archive, job, filename, hasher.Digests(), check)
+}
+
+// StreamPackage godoc
+// @Summary Upload a package as the request body
+// @Description Upload a package as the raw request body (like 'curl -T').  The body is streamed straight to disk, so it can be bigger than a multipart upload (see upload.streambytelimit).  The package is validated and then published in the background (as <Package>_<Version>_<Architecture>.deb, whatever the file was called) -- use the job in the response to follow along, or pass wait=true to wait for publishing to finish.
+// @Tags package
+// @Accept  application/vnd.debian.binary-package
+// @Produce  json
+// @Param filename path string true "The package file name"
+// @Param file body string true "The package file"
+// @Param suite query string false "The suite to publish to (pool layout only, defaults to repo.defaultsuite)"
+// @Param component query string false "The component to publish to (pool layout only, defaults to repo.defaultcomponent)"
+// @Param wait query bool false "Wait for the package to be published before responding"
+// @Param package query string false "The package name the upload should have"
+// @Param version query string false "The version the upload should have"
+// @Param architecture query string false "The architecture the upload should have"
+// @Param X-Checksum-Sha256 header string false "The SHA256 the upload should have (hex encoded)"
+// @Param Digest header string false "Checksums the upload should have (RFC 3230, like 'sha-256=<base64>')"
+// @Param overwrite query bool false "Replace a package file already published as the same version with different content"
+// @Param Idempotency-Key header string false "A key for the upload -- retrying the same upload with the same key gets the same job instead of publishing again (unless the job failed for a reason that may go away)"
+// @Success 200 {object} api.SystemResponse "The same package file is already published, so nothing changed (wait=true only)"
+// @Success 201 {object} api.SystemResponse
+// @Success 202 {object} api.SystemResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 409 {object} api.ErrorResponse "A package file with different content is already published as the same version, or the repo lock was held by another operation for too long (wait=true only)"
+// @Failure 413 {object} api.ErrorResponse
+// @Failure 422 {object} api.ErrorResponse "The upload doesn't match the checksums, package, version or architecture it should have, or the Idempotency-Key was already used for a different upload"
+// @Failure 500 {object} api.ErrorResponse
+// @Failure 503 {object} api.ErrorResponse "The repo lock is unavailable (wait=true only)"
+// @Router /package/{filename} [put]
+func (service Service) StreamPackage(rw http.ResponseWriter, req *http.Request) {
+
+	//	Get configs
+	MAX_STREAM_SIZE := viper.GetInt64("upload.streambytelimit")
+	UploadPath := viper.GetString("upload.path")
+	archive := debian.ArchiveFromConfig()
+
+	//	First check the auth token and make sure it exists on the header:
+	if !checkAuthToken(rw, req) {
+		return
+	}
 
-	//	Process the file
+	//	Make sure any idempotency key is one we can use before reading the upload
+	if _, err := idempotencyKeyHeader(req); err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
 
-	//	ci-pre.sh (switch to repo folder and git pull)
-	log.Debug().Msg("Performing a repo pull")
-	err = service.RepoSvc.Pull()
+	filename, err := uploadFilenameParam(req)
 	if err != nil {
-		err = fmt.Errorf("error refreshing repo: %w", err)
-		sendErrorResponse(rw, err, http.StatusInternalServerError)
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
+	//	If we already know it's too big, don't read any of it
+	log.Debug().Int64("MAX_STREAM_SIZE", MAX_STREAM_SIZE).Int64("ContentLength", req.ContentLength).Msg("Checking size vs max stream size")
+	if req.ContentLength > MAX_STREAM_SIZE {
+		err = fmt.Errorf("uploaded file is too big: %d bytes is more than the limit of %d", req.ContentLength, MAX_STREAM_SIZE)
+		sendErrorResponse(rw, err, http.StatusRequestEntityTooLarge)
+		return
+	}
+	req.Body = http.MaxBytesReader(rw, req.Body, MAX_STREAM_SIZE)
+
+	//	Figure out where the package is going (the body is the package, so these can only be query params)
+	target, err := archive.ResolveTarget(req.URL.Query().Get("suite"), req.URL.Query().Get("component"))
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
+	//	See what the client expects the package to be, so we can check it
+	check, err := uploadCheckFromRequest(req, req.URL.Query().Get)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
 		return
 	}
 
-	//	Move file to repo folder
-	repoFile := path.Join(RepoPath, fileHeader.Filename)
-	log.Debug().Str("repoFile", repoFile).Msg("Moving file to the repo path")
-	err = os.Rename(destinationFile, repoFile)
+	log.Debug().Str("UploadPath", UploadPath).Msg("Creating upload path if it doesn't exist")
+	err = os.MkdirAll(UploadPath, os.ModePerm)
 	if err != nil {
-		err = fmt.Errorf("error moving file to repo: %w", err)
+		err = fmt.Errorf("error creating uploads path: %w", err)
 		sendErrorResponse(rw, err, http.StatusInternalServerError)
 		return
 	}
 
-	//  ci-refresh.sh / refresh-packages.sh (Perform dpkg-scanpackages, gzip and sign using gpg)
-	log.Debug().Msg("Refreshing packages")
-	err = debian.RefreshPackages(req.Context(), gpgPassword, gitEmail, RepoPath)
+	job := publish.NewUploadJob("", filename, target)
+	destinationFile := uploadDestination(UploadPath, job)
+	job.Params[publish.ParamFile] = destinationFile
+
+	log.Debug().Str("destination file", destinationFile).Msg("Creating file in uploads directory")
+	dst, err := os.Create(destinationFile)
 	if err != nil {
-		err = fmt.Errorf("error refreshing packages: %w", err)
+		err = fmt.Errorf("error creating file: %w", err)
 		sendErrorResponse(rw, err, http.StatusInternalServerError)
 		return
 	}
+	defer dst.Close()
 
-	//	ci-post.sh (git add / git commit / git push)
-	log.Debug().Msg("Adding all changes and preparing to commit")
-	err = service.RepoSvc.AddAll()
+	//	Stream the body to the file, hashing it as we go
+	log.Debug().Msg("Streaming the request body to the destination file")
+	hasher := upload.NewHasher()
+	size, err := io.Copy(io.MultiWriter(dst, hasher), req.Body)
+	if err == nil {
+		err = dst.Close()
+	}
 	if err != nil {
-		err = fmt.Errorf("error adding changes in repo: %w", err)
+		os.Remove(destinationFile)
+
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			err = fmt.Errorf("uploaded file is too big: %w", err)
+			sendErrorResponse(rw, err, http.StatusRequestEntityTooLarge)
+		} else {
+			err = fmt.Errorf("error saving file: %w", err)
+			sendErrorResponse(rw, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	digests := hasher.Digests()
+	job.Params[publish.ParamSize] = strconv.FormatInt(size, 10)
+	log.Debug().
+		Int64("size", size).
+		Str("sha256", digests.SHA256).
+		Msg("Saved streamed upload")
+
+	service.queueUpload(rw, req, archive, job, filename, digests, check)
+}
+
+// uploadFilenameParam gets the name of an uploaded file from the path
+func uploadFilenameParam(req *http.Request) (string, error) {
+	filename, err := url.PathUnescape(chi.URLParam(req, "filename"))
+	if err != nil || !validUploadFilename(filename) {
+		return "", fmt.Errorf("bad file name '%s'", chi.URLParam(req, "filename"))
+	}
+
+	return filename, nil
+}
+
+// multipartFilename gets the name of a file uploaded in a multipart form, as the client sent it
+func multipartFilename(fileHeader *multipart.FileHeader) (string, error) {
+	filename := fileHeader.Filename
+	if _, params, err := mime.ParseMediaType(fileHeader.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		filename = params["filename"]
+	}
+
+	if !validUploadFilename(filename) {
+		return "", fmt.Errorf("bad file name '%s'", filename)
+	}
+
+	return filename, nil
+}
+
+// uploadDestination is where the job's package is saved until it's published
+func uploadDestination(uploadPath string, job *cache.Job) string {
+	return path.Join(uploadPath, job.ID+".deb")
+}
+
+// validUploadFilename returns true if the name of an uploaded file is just a name (with no folders)
+func validUploadFilename(filename string) bool {
+	return filename != "" && filename != "." && filename != ".." && !strings.ContainsAny(filename, "/\\")
+}
+
+// queueUpload makes sure the uploaded file (the job's file param) is what the client expects and
+// is a package we can publish, then queues the job and responds -- right away, or once the package
+// is published if the caller asked to wait.  The uploaded file is removed if it isn't queued.
+func (service Service) queueUpload(rw http.ResponseWriter, req *http.Request, archive debian.Archive, job *cache.Job, filename string, digests upload.Digests, check uploadCheck) {
+	destinationFile := job.Params[publish.ParamFile]
+
+	//	Send back what we got, so the client can check it too
+	job.Params[publish.ParamMD5] = digests.MD5
+	job.Params[publish.ParamSHA1] = digests.SHA1
+	job.Params[publish.ParamSHA256] = digests.SHA256
+	job.Params[publish.ParamSHA512] = digests.SHA512
+	rw.Header().Set(DigestHeader, digestHeader(digests))
+
+	//	Make sure we got the bytes the client sent
+	if err := check.verifyChecksums(digests); err != nil {
+		os.Remove(destinationFile)
+		sendErrorResponse(rw, err, http.StatusUnprocessableEntity)
+		return
+	}
+
+	//	Make sure what we got is actually a debian binary package
+	log.Debug().Str("destination file", destinationFile).Msg("Reading package control metadata")
+	packageInfo, err := debian.ReadPackageFile(destinationFile)
+	if err != nil {
+		os.Remove(destinationFile)
+		if errors.Is(err, debian.ErrInvalidPackage) {
+			sendErrorResponse(rw, err, http.StatusBadRequest)
+		} else {
+			err = fmt.Errorf("error reading package: %w", err)
+			sendErrorResponse(rw, err, http.StatusInternalServerError)
+		}
+		return
+	}
+	//	Make sure it's the package the client meant to send
+	err = check.verifyPackage(packageInfo)
+	if err != nil {
+		os.Remove(destinationFile)
+		err = fmt.Errorf("uploaded package doesn't match: %w", err)
+		sendErrorResponse(rw, err, http.StatusUnprocessableEntity)
+		return
+	}
+
+	//	Make sure we serve the package's architecture
+	err = archive.ValidateArchitecture(packageInfo.Architecture)
+	if err != nil {
+		os.Remove(destinationFile)
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
+	//	Fail fast if a different package file is already published as this version (the
+	//	publish job checks again, holding the repo lock)
+	target := debian.Target{Suite: job.Params[publish.ParamSuite], Component: job.Params[publish.ParamComponent]}
+	if !check.Overwrite {
+		if published, ok := service.publishedVersion(req.Context(), packageInfo, target); ok && published.SHA256 != digests.SHA256 {
+			os.Remove(destinationFile)
+			err = fmt.Errorf("%w: %s %s (%s) is already published as %s with different content -- upload it with overwrite to replace it",
+				publish.ErrVersionExists, packageInfo.Package, packageInfo.Version, packageInfo.Architecture, published.Filename)
+			sendErrorResponse(rw, err, http.StatusConflict)
+			return
+		}
+	}
+	job.Params[publish.ParamOverwrite] = strconv.FormatBool(check.Overwrite)
+	job.Params[publish.ParamCanonicalFilename] = packageInfo.CanonicalFilename()
+	job.Package = packageInfo
+
+	log.Debug().
+		Str("package", packageInfo.Package).
+		Str("version", packageInfo.Version).
+		Str("architecture", packageInfo.Architecture).
+		Str("job", job.ID).
+		Msg("Package metadata")
+
+	//	Only one request with the same idempotency key gets to start a job -- any others get its job
+	//	(as long as they're uploading the same thing)
+	key, _ := idempotencyKeyHeader(req)
+	if key != "" {
+		claim := cache.IdempotencyClaim{JobID: job.ID, SHA256: digests.SHA256, Suite: target.Suite, Component: target.Component}
+		existing, claimed, err := service.Cache.ClaimIdempotencyKey(req.Context(), key, claim)
+		if err != nil {
+			os.Remove(destinationFile)
+			sendErrorResponse(rw, err, http.StatusInternalServerError)
+			return
+		}
+
+		if !claimed {
+			os.Remove(destinationFile)
+			if !existing.Matches(claim) {
+				sendErrorResponse(rw, fmt.Errorf("%s '%s' was already used for a different upload", IdempotencyKeyHeader, key), http.StatusUnprocessableEntity)
+				return
+			}
+			if !service.replayJob(rw, req, existing.JobID) {
+				sendErrorResponse(rw, fmt.Errorf("the upload with idempotency key '%s' has expired", key), http.StatusConflict)
+			}
+			return
+		}
+		job.Params[publish.ParamIdempotencyKey] = key
+	}
+
+	//	Queue the package to be published
+	err = service.Cache.EnqueueJob(req.Context(), job)
+	if err != nil {
+		os.Remove(destinationFile)
+		if key != "" {
+			service.Cache.ReleaseIdempotencyKey(req.Context(), key, job.ID)
+		}
+		err = fmt.Errorf("error queueing upload: %w", err)
 		sendErrorResponse(rw, err, http.StatusInternalServerError)
 		return
 	}
 
-	log.Debug().Msg("Committing and pushing changes")
-	err = service.RepoSvc.CommitAndPush(githubUser, githubPassword, gitName, gitEmail)
+	service.respondWithJob(rw, req, job)
+}
+
+// respondWithJob sends back an upload job -- right away, or once it's finished if the caller asked to wait
+func (service Service) respondWithJob(rw http.ResponseWriter, req *http.Request, job *cache.Job) {
+	filename := job.Params[publish.ParamFilename]
+	if canonical := job.Params[publish.ParamCanonicalFilename]; canonical != "" && canonical != filename {
+		filename = fmt.Sprintf("%s (as %s)", filename, canonical)
+	}
+
+	//	If the caller doesn't want to wait, let them know where to check on the job
+	if wait, _ := strconv.ParseBool(req.URL.Query().Get("wait")); !wait {
+		response := SystemResponse{
+			Message: fmt.Sprintf("File uploaded: %v", filename),
+			Data:    job,
+		}
+
+		rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+		rw.Header().Set("Location", jobLocation(job.ID))
+		rw.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(rw).Encode(response)
+		return
+	}
+
+	//	Otherwise, wait for the job to finish
+	job, err := service.waitForJob(req.Context(), job.ID)
 	if err != nil {
-		err = fmt.Errorf("error committing and pushing: %w", err)
 		sendErrorResponse(rw, err, http.StatusInternalServerError)
 		return
 	}
 
+	if job.State == cache.JobStateFailed {
+		sendJobErrorResponse(rw, job)
+		return
+	}
+
 	//	If we've gotten this far, indicate a successful upload
 	response := SystemResponse{
-		Message: fmt.Sprintf("File uploaded: %v", fileHeader.Filename),
+		Message: fmt.Sprintf("File uploaded: %v", filename),
+		Data:    job,
+	}
+	status := http.StatusCreated
+
+	//	Uploading the same package file again doesn't change anything
+	if job.CommitSHA == "" {
+		response.Message = fmt.Sprintf("File uploaded: %v -- the same package file is already published, so nothing changed", filename)
+		status = http.StatusOK
 	}
 
 	//	Serialize to JSON & return the response:
-	rw.WriteHeader(http.StatusCreated)
 	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	rw.Header().Set("Location", jobLocation(job.ID))
+	rw.WriteHeader(status)
 	json.NewEncoder(rw).Encode(response)
 	log.Debug().Msg("Complete!")
 }
+
+// idempotencyKeyHeader gets the request's idempotency key (an empty string if it doesn't have one)
+func idempotencyKeyHeader(req *http.Request) (string, error) {
+	key := strings.TrimSpace(req.Header.Get(IdempotencyKeyHeader))
+	if len(key) > maxIdempotencyKeyLength {
+		return "", fmt.Errorf("%s can't be more than %d characters", IdempotencyKeyHeader, maxIdempotencyKeyLength)
+	}
+
+	return key, nil
+}
+
+// replayJob responds with the job started by an earlier request with the same idempotency key.
+// It returns false (without responding) if the job has expired.
+func (service Service) replayJob(rw http.ResponseWriter, req *http.Request, jobID string) bool {
+	job, err := service.Cache.GetJob(req.Context(), jobID)
+	if errors.Is(err, cache.ErrJobNotFound) {
+		return false
+	}
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusInternalServerError)
+		return true
+	}
+
+	log.Debug().Str("job", job.ID).Msg("Replaying the upload with the same idempotency key")
+	rw.Header().Set(IdempotentReplayedHeader, "true")
+	service.respondWithJob(rw, req, job)
+	return true
+}
+
+// publishedVersion returns the package file published to the target as the same package,
+// version and architecture (if there is one), going by the package index
+func (service Service) publishedVersion(ctx context.Context, info debian.PackageInfo, target debian.Target) (catalog.Package, bool) {
+	index, err := service.PublishSvc.PackageIndex(ctx)
+	if err != nil {
+		log.Err(err).Msg("problem getting the package index -- the publish job will check for an existing version")
+		return catalog.Package{}, false
+	}
+
+	for _, pkg := range index.Versions(info.Package) {
+		if pkg.Architecture == info.Architecture && pkg.Suite == target.Suite && pkg.Component == target.Component &&
+			debian.CompareVersions(pkg.Version, info.Version) == 0 {
+			return pkg, true
+		}
+	}
+
+	return catalog.Package{}, false
+}
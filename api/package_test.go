@@ -0,0 +1,186 @@
+package api
+
+import (
+	"context"
+	"github.com/danesparza/package-assistant/internal/debian"
+	"github.com/danesparza/package-assistant/internal/publish"
+	"github.com/go-chi/chi/v5"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestValidUploadFilename(t *testing.T) {
+	tests := []struct {
+		filename string
+		want     bool
+	}{
+		{filename: "foo_1.0_amd64.deb", want: true},
+		{filename: "foo 1.0 amd64.deb", want: true},
+		{filename: ".foo_1.0_amd64.deb", want: true},
+		{filename: "...", want: true},
+		{filename: "", want: false},
+		{filename: ".", want: false},
+		{filename: "..", want: false},
+		{filename: "../foo_1.0_amd64.deb", want: false},
+		{filename: "../../etc/passwd", want: false},
+		{filename: "pool/main/foo_1.0_amd64.deb", want: false},
+		{filename: "/foo_1.0_amd64.deb", want: false},
+		{filename: "foo_1.0_amd64.deb/", want: false},
+		{filename: `..\foo_1.0_amd64.deb`, want: false},
+		{filename: `C:\temp\foo_1.0_amd64.deb`, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			if got := validUploadFilename(tt.filename); got != tt.want {
+				t.Errorf("validUploadFilename(%q) = %v, want %v", tt.filename, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMultipartFilename(t *testing.T) {
+	tests := []struct {
+		name               string
+		filename           string
+		contentDisposition string
+		want               string
+		wantErr            bool
+	}{
+		{name: "plain name", filename: "foo_1.0_amd64.deb", want: "foo_1.0_amd64.deb"},
+		{name: "name with spaces", filename: "foo 1.0.deb", want: "foo 1.0.deb"},
+		{name: "leading dot", filename: ".foo.deb", want: ".foo.deb"},
+		{
+			name:               "content disposition wins",
+			filename:           "foo_1.0_amd64.deb",
+			contentDisposition: `form-data; name="file"; filename="bar_1.0_amd64.deb"`,
+			want:               "bar_1.0_amd64.deb",
+		},
+		{
+			//	The multipart reader strips folders from the name, but the header still has them
+			name:               "parent folder in the content disposition",
+			filename:           "foo_1.0_amd64.deb",
+			contentDisposition: `form-data; name="file"; filename="../foo_1.0_amd64.deb"`,
+			wantErr:            true,
+		},
+		{
+			name:               "backslashes in the content disposition",
+			filename:           "foo_1.0_amd64.deb",
+			contentDisposition: `form-data; name="file"; filename="..\\..\\foo_1.0_amd64.deb"`,
+			wantErr:            true,
+		},
+		{
+			name:               "dot dot in the content disposition",
+			filename:           "foo_1.0_amd64.deb",
+			contentDisposition: `form-data; name="file"; filename=".."`,
+			wantErr:            true,
+		},
+		{name: "slash in the name", filename: "pool/foo_1.0_amd64.deb", wantErr: true},
+		{name: "no name", filename: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileHeader := &multipart.FileHeader{Filename: tt.filename, Header: textproto.MIMEHeader{}}
+			if tt.contentDisposition != "" {
+				fileHeader.Header.Set("Content-Disposition", tt.contentDisposition)
+			}
+
+			got, err := multipartFilename(fileHeader)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("multipartFilename() = %s, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("multipartFilename() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("multipartFilename() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUploadFilenameParam(t *testing.T) {
+	tests := []struct {
+		param   string
+		want    string
+		wantErr bool
+	}{
+		{param: "foo_1.0_amd64.deb", want: "foo_1.0_amd64.deb"},
+		{param: "foo%201.0.deb", want: "foo 1.0.deb"},
+		{param: "foo_2%3a1.0_amd64.deb", want: "foo_2:1.0_amd64.deb"},
+		{param: "..%2Ffoo_1.0_amd64.deb", wantErr: true},
+		{param: "..%5Cfoo_1.0_amd64.deb", wantErr: true},
+		{param: "%2E%2E", wantErr: true},
+		{param: "foo%zz.deb", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.param, func(t *testing.T) {
+			routeContext := chi.NewRouteContext()
+			routeContext.URLParams.Add("filename", tt.param)
+			req := httptest.NewRequest(http.MethodPut, "/v1/package/file", nil)
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, routeContext))
+
+			got, err := uploadFilenameParam(req)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("uploadFilenameParam() = %s, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("uploadFilenameParam() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("uploadFilenameParam() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRespondWithJobFilenames(t *testing.T) {
+	tests := []struct {
+		name      string
+		filename  string
+		canonical string
+		want      string
+	}{
+		{name: "canonical name", filename: "foo_1.0_amd64.deb", canonical: "foo_1.0_amd64.deb", want: "File uploaded: foo_1.0_amd64.deb"},
+		{name: "misleading version", filename: "foo_2.0_amd64.deb", canonical: "foo_1.0_amd64.deb", want: "File uploaded: foo_2.0_amd64.deb (as foo_1.0_amd64.deb)"},
+		{name: "epoch", filename: "foo_1:1.0_amd64.deb", canonical: "foo_1.0_amd64.deb", want: "File uploaded: foo_1:1.0_amd64.deb (as foo_1.0_amd64.deb)"},
+		{name: "spaces", filename: "foo latest.deb", canonical: "foo_1.0_amd64.deb", want: "File uploaded: foo latest.deb (as foo_1.0_amd64.deb)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := publish.NewUploadJob("/tmp/upload.deb", tt.filename, debian.Target{})
+			job.Params[publish.ParamCanonicalFilename] = tt.canonical
+
+			//	Without waiting, the job is sent straight back (so there's no cache to talk to)
+			req := httptest.NewRequest(http.MethodPost, "/v1/package", nil)
+			rw := httptest.NewRecorder()
+			Service{}.respondWithJob(rw, req, job)
+
+			if rw.Code != http.StatusAccepted {
+				t.Errorf("respondWithJob() status = %d, want %d", rw.Code, http.StatusAccepted)
+			}
+			if !strings.Contains(rw.Body.String(), `"message":"`+tt.want+`"`) {
+				t.Errorf("respondWithJob() response = %s, want '%s'", rw.Body.String(), tt.want)
+			}
+
+			//	Whatever the client called it, the upload is saved under the job's ID
+			if got := uploadDestination("/uploads", job); got != path.Join("/uploads", job.ID+".deb") {
+				t.Errorf("uploadDestination() = %s, want it named after the job", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,306 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/danesparza/package-assistant/internal/catalog"
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// defaultPageSize is how many packages are listed when the request doesn't say
+	defaultPageSize = 100
+
+	// maxPageSize is the most packages that can be listed at once
+	maxPageSize = 1000
+)
+
+// ListPackages godoc
+// @Summary List published packages
+// @Description Lists the package files published by the repo (with their suite and component, size, SHA256, upload time and whether they're pinned).  Packages are sorted by name, then version using debian version ordering.  Use the nextCursor from a response to get the next page.
+// @Tags package
+// @Produce  json
+// @Param name query string false "Only packages with names starting with this"
+// @Param arch query string false "Only packages for this architecture"
+// @Param suite query string false "Only packages published to this suite"
+// @Param order query string false "List versions oldest first (asc) or newest first (desc, the default)"
+// @Param cursor query string false "The nextCursor from the previous page"
+// @Param limit query int false "The most packages to return (default 100, at most 1000)"
+// @Success 200 {object} api.SystemResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /packages [get]
+func (service Service) ListPackages(rw http.ResponseWriter, req *http.Request) {
+	params := req.URL.Query()
+
+	query := catalog.Query{
+		NamePrefix:   params.Get("name"),
+		Architecture: params.Get("arch"),
+		Suite:        params.Get("suite"),
+		Order:        params.Get("order"),
+		Cursor:       params.Get("cursor"),
+		Limit:        defaultPageSize,
+	}
+
+	if limit := params.Get("limit"); limit != "" {
+		var err error
+		query.Limit, err = strconv.Atoi(limit)
+		if err != nil || query.Limit < 1 || query.Limit > maxPageSize {
+			sendErrorResponse(rw, fmt.Errorf("limit must be a number from 1 to %d", maxPageSize), http.StatusBadRequest)
+			return
+		}
+	}
+
+	index, err := service.PublishSvc.PackageIndex(req.Context())
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusInternalServerError)
+		return
+	}
+
+	page, err := index.Query(query)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
+	//	The control fields are only included in the package details
+	for i := range page.Packages {
+		page.Packages[i].Control = nil
+	}
+
+	response := SystemResponse{
+		Message: fmt.Sprintf("%d of %d packages", len(page.Packages), page.Total),
+		Data:    page,
+	}
+
+	//	Serialize to JSON & return the response:
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(rw).Encode(response)
+}
+
+// GetPackage godoc
+// @Summary Get a package
+// @Description Gets every published version of a package (for every architecture, suite and component), newest first, with all of the fields from each package's control file and whether it's pinned
+// @Tags package
+// @Produce  json
+// @Param name path string true "The package name"
+// @Success 200 {object} api.SystemResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /packages/{name} [get]
+func (service Service) GetPackage(rw http.ResponseWriter, req *http.Request) {
+	name, err := packageNameParam(req)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
+	index, err := service.PublishSvc.PackageIndex(req.Context())
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusInternalServerError)
+		return
+	}
+
+	versions := index.Versions(name)
+	if len(versions) == 0 {
+		sendErrorResponse(rw, fmt.Errorf("package '%s' not found", name), http.StatusNotFound)
+		return
+	}
+
+	response := SystemResponse{
+		Message: fmt.Sprintf("%d versions of %s", len(versions), name),
+		Data:    versions,
+	}
+
+	//	Serialize to JSON & return the response:
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(rw).Encode(response)
+}
+
+// GetLatestPackage godoc
+// @Summary Get the latest version of a package
+// @Description Gets the newest published version of a package (using debian version ordering) that installs on the architecture and is published to the suite
+// @Tags package
+// @Produce  json
+// @Param name path string true "The package name"
+// @Param arch query string false "Only versions that install on this architecture (including 'all' packages)"
+// @Param suite query string false "Only versions published to this suite"
+// @Success 200 {object} api.SystemResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /packages/{name}/latest [get]
+func (service Service) GetLatestPackage(rw http.ResponseWriter, req *http.Request) {
+	pkg, ok := service.latestPackage(rw, req)
+	if !ok {
+		return
+	}
+
+	response := SystemResponse{
+		Message: fmt.Sprintf("%s %s is the latest version", pkg.Package, pkg.Version),
+		Data:    pkg,
+	}
+
+	//	Serialize to JSON & return the response:
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(rw).Encode(response)
+}
+
+// DownloadLatestPackage godoc
+// @Summary Download the latest version of a package
+// @Description Downloads the newest published version of a package that installs on the architecture and is published to the suite.  If repo.url is set, this redirects to the package file there.  Otherwise the package file is sent from the repo's working copy.
+// @Tags package
+// @Produce  application/vnd.debian.binary-package
+// @Param name path string true "The package name"
+// @Param arch query string false "Only versions that install on this architecture (including 'all' packages)"
+// @Param suite query string false "Only versions published to this suite"
+// @Success 200 {file} file
+// @Success 302
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /packages/{name}/latest/download [get]
+func (service Service) DownloadLatestPackage(rw http.ResponseWriter, req *http.Request) {
+	pkg, ok := service.latestPackage(rw, req)
+	if !ok {
+		return
+	}
+
+	//	Send them to where the repo is served from, if we know
+	if baseURL := strings.TrimSpace(viper.GetString("repo.url")); baseURL != "" {
+		target, err := url.JoinPath(baseURL, strings.Split(pkg.Filename, "/")...)
+		if err != nil {
+			sendErrorResponse(rw, fmt.Errorf("problem building download url: %w", err), http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(rw, req, target, http.StatusFound)
+		return
+	}
+
+	//	Otherwise send it ourselves
+	f, err := os.Open(filepath.Join(viper.GetString("github.projectfolder"), filepath.FromSlash(pkg.Filename)))
+	if err != nil {
+		log.Err(err).Str("filename", pkg.Filename).Msg("problem opening package file")
+		sendErrorResponse(rw, fmt.Errorf("package file %s is missing", pkg.Filename), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		sendErrorResponse(rw, fmt.Errorf("problem getting package file info: %w", err), http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/vnd.debian.binary-package")
+	rw.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(pkg.Filename)))
+	http.ServeContent(rw, req, path.Base(pkg.Filename), stat.ModTime(), f)
+}
+
+// DeletePackage godoc
+// @Summary Delete a package version
+// @Description Removes a version of a package (for every architecture, or just the one given), then refreshes and signs the indexes and commits and pushes the change, using the same repo lock and rollback as uploads.  Pinned versions are only deleted when forced.
+// @Tags package
+// @Produce  json
+// @Param name path string true "The package name"
+// @Param version path string true "The package version"
+// @Param arch query string false "Only delete the package file for this architecture"
+// @Param force query bool false "Delete the version even if it's pinned (the pin is removed too)"
+// @Success 200 {object} api.SystemResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Failure 409 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Failure 503 {object} api.ErrorResponse
+// @Router /packages/{name}/{version} [delete]
+func (service Service) DeletePackage(rw http.ResponseWriter, req *http.Request) {
+	//	First check the auth token and make sure it exists on the header:
+	if !checkAuthToken(rw, req) {
+		return
+	}
+
+	name, version, err := packageVersionParams(req)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
+	force := false
+	if value := req.URL.Query().Get("force"); value != "" {
+		force, err = strconv.ParseBool(value)
+		if err != nil {
+			sendErrorResponse(rw, fmt.Errorf("force must be true or false (got '%s')", value), http.StatusBadRequest)
+			return
+		}
+	}
+
+	result, err := service.PublishSvc.Delete(req.Context(), name, version, req.URL.Query().Get("arch"), force)
+	if err != nil {
+		sendErrorResponse(rw, err, transactionErrorStatus(err))
+		return
+	}
+
+	response := SystemResponse{
+		Message: fmt.Sprintf("Deleted %d package files for %s %s", len(result.Removed), name, version),
+		Data:    result,
+	}
+
+	//	Serialize to JSON & return the response:
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(rw).Encode(response)
+}
+
+// latestPackage finds the latest version of the package asked for.  If there isn't one
+// (or something goes wrong), an error response is sent and false is returned.
+func (service Service) latestPackage(rw http.ResponseWriter, req *http.Request) (catalog.Package, bool) {
+	name, err := packageNameParam(req)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return catalog.Package{}, false
+	}
+
+	index, err := service.PublishSvc.PackageIndex(req.Context())
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusInternalServerError)
+		return catalog.Package{}, false
+	}
+
+	arch := req.URL.Query().Get("arch")
+	suite := req.URL.Query().Get("suite")
+
+	pkg, found := index.Latest(name, arch, suite)
+	if !found {
+		message := fmt.Sprintf("no published version of '%s' found", name)
+		if arch != "" {
+			message += fmt.Sprintf(" for architecture '%s'", arch)
+		}
+		if suite != "" {
+			message += fmt.Sprintf(" in suite '%s'", suite)
+		}
+		sendErrorResponse(rw, fmt.Errorf("%s", message), http.StatusNotFound)
+		return catalog.Package{}, false
+	}
+
+	return pkg, true
+}
+
+// packageNameParam gets the package name from the url
+func packageNameParam(req *http.Request) (string, error) {
+	name, err := url.PathUnescape(chi.URLParam(req, "name"))
+	if err != nil || name == "" {
+		return "", fmt.Errorf("bad package name '%s'", chi.URLParam(req, "name"))
+	}
+
+	return name, nil
+}
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/danesparza/package-assistant/internal/debian"
+	"github.com/danesparza/package-assistant/internal/publish"
+	"github.com/danesparza/package-assistant/internal/retention"
+	"github.com/go-chi/chi/v5"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// PinRequest is the (optional) body of a pin request
+type PinRequest struct {
+	Reason string `json:"reason"`
+}
+
+// PinResult describes a pin that was added or removed
+type PinResult struct {
+	retention.Pin
+	CommitSHA string `json:"commitSha,omitempty"`
+}
+
+// PinPackage godoc
+// @Summary Pin a package version
+// @Description Pins a version of a package (for every architecture) so the retention policy never removes it.  Pinned versions don't count towards the number of versions to keep.  Pins are committed to the package repo.
+// @Tags package
+// @Accept  json
+// @Produce  json
+// @Param name path string true "The package name"
+// @Param version path string true "The package version"
+// @Param pin body api.PinRequest false "Why the version is pinned"
+// @Success 200 {object} api.SystemResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Failure 409 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Failure 503 {object} api.ErrorResponse
+// @Router /packages/{name}/{version}/pin [put]
+func (service Service) PinPackage(rw http.ResponseWriter, req *http.Request) {
+	//	First check the auth token and make sure it exists on the header:
+	if !checkAuthToken(rw, req) {
+		return
+	}
+
+	name, version, err := packageVersionParams(req)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
+	//	The body is optional
+	request := PinRequest{}
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+		err = fmt.Errorf("problem decoding pin request: %w", err)
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
+	pin, sha, err := service.PublishSvc.Pin(req.Context(), name, version, request.Reason)
+	if err != nil {
+		sendErrorResponse(rw, err, transactionErrorStatus(err))
+		return
+	}
+
+	message := fmt.Sprintf("Pinned %s %s", name, version)
+	if sha == "" {
+		message = fmt.Sprintf("%s %s is already pinned", name, version)
+	}
+
+	response := SystemResponse{
+		Message: message,
+		Data:    PinResult{Pin: pin, CommitSHA: sha},
+	}
+
+	//	Serialize to JSON & return the response:
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(rw).Encode(response)
+}
+
+// UnpinPackage godoc
+// @Summary Unpin a package version
+// @Description Removes the pin for a version of a package, so the retention policy can remove it again
+// @Tags package
+// @Produce  json
+// @Param name path string true "The package name"
+// @Param version path string true "The package version"
+// @Success 200 {object} api.SystemResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Failure 409 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Failure 503 {object} api.ErrorResponse
+// @Router /packages/{name}/{version}/pin [delete]
+func (service Service) UnpinPackage(rw http.ResponseWriter, req *http.Request) {
+	//	First check the auth token and make sure it exists on the header:
+	if !checkAuthToken(rw, req) {
+		return
+	}
+
+	name, version, err := packageVersionParams(req)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
+	sha, err := service.PublishSvc.Unpin(req.Context(), name, version)
+	if err != nil {
+		sendErrorResponse(rw, err, transactionErrorStatus(err))
+		return
+	}
+
+	response := SystemResponse{
+		Message: fmt.Sprintf("Unpinned %s %s", name, version),
+		Data:    PinResult{Pin: retention.Pin{Package: name, Version: version}, CommitSHA: sha},
+	}
+
+	//	Serialize to JSON & return the response:
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(rw).Encode(response)
+}
+
+// packageVersionParams gets the package name and version from the url
+func packageVersionParams(req *http.Request) (string, string, error) {
+	name, err := packageNameParam(req)
+	if err != nil {
+		return "", "", err
+	}
+
+	version, err := url.PathUnescape(chi.URLParam(req, "version"))
+	if err != nil {
+		return "", "", fmt.Errorf("bad package version '%s'", chi.URLParam(req, "version"))
+	}
+
+	if _, err := debian.ParseVersion(version); err != nil {
+		return "", "", err
+	}
+
+	return name, version, nil
+}
+
+// transactionErrorStatus is the http status code to use for an error from a repo transaction
+func transactionErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, publish.ErrLockTimeout), errors.Is(err, publish.ErrVersionPinned):
+		return http.StatusConflict
+	case errors.Is(err, publish.ErrLockUnavailable):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, debian.ErrPackageNotFound), errors.Is(err, retention.ErrPinNotFound):
+		return http.StatusNotFound
+	}
+
+	return http.StatusInternalServerError
+}
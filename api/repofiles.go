@@ -0,0 +1,189 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/danesparza/package-assistant/internal/publish"
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// repoContentTypes are the content types of the files apt asks for, by file extension
+var repoContentTypes = map[string]string{
+	".deb": "application/vnd.debian.binary-package",
+	".gz":  "application/gzip",
+	".xz":  "application/x-xz",
+	".bz2": "application/x-bzip2",
+	".zst": "application/zstd",
+	".asc": "application/pgp-keys",
+	".gpg": "application/pgp-keys",
+	".key": "application/pgp-keys",
+}
+
+// ServeRepoFile sends a file (or a listing of a folder) from the repo's working copy, so the
+// service can act as an apt endpoint itself.  It's read only, and nothing hidden (like the .git
+// folder) is ever served.  Range requests and conditional requests (If-None-Match,
+// If-Modified-Since) are supported.  Indexes, Release files and signatures are read while no
+// transaction is running, so apt never gets them half written (or sees changes that are rolled
+// back).  Package files are only ever added or replaced whole, so they're served right away.
+func (service Service) ServeRepoFile(rw http.ResponseWriter, req *http.Request) {
+	//	Resolve the repo folder the same way as the requested path, so a repo folder
+	//	that is (or is under) a symlink still contains its files
+	root, err := filepath.Abs(viper.GetString("github.projectfolder"))
+	if err == nil {
+		root, err = filepath.EvalSymlinks(root)
+	}
+	if err != nil {
+		sendErrorResponse(rw, fmt.Errorf("problem finding the repo folder: %w", err), http.StatusInternalServerError)
+		return
+	}
+
+	//	Clean up the path, and make sure it doesn't go anywhere it shouldn't
+	name := path.Clean("/" + chi.URLParam(req, "*"))
+	for _, segment := range strings.Split(name, "/") {
+		if strings.HasPrefix(segment, ".") {
+			http.NotFound(rw, req)
+			return
+		}
+	}
+
+	packageFile := strings.HasSuffix(name, ".deb")
+	unlock := func() {}
+	if !packageFile {
+		unlock = publish.ReadWorkingCopy()
+	}
+	defer func() { unlock() }()
+
+	fullPath, err := filepath.EvalSymlinks(filepath.Join(root, filepath.FromSlash(name)))
+	if err != nil {
+		http.NotFound(rw, req)
+		return
+	}
+	if rel, err := filepath.Rel(root, fullPath); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		log.Warn().Str("path", name).Msg("Refusing to serve a file outside of the repo folder")
+		http.NotFound(rw, req)
+		return
+	}
+
+	f, err := os.Open(fullPath)
+	if err != nil {
+		http.NotFound(rw, req)
+		return
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		sendErrorResponse(rw, fmt.Errorf("problem getting file info: %w", err), http.StatusInternalServerError)
+		return
+	}
+
+	if stat.IsDir() {
+		//	Folders are only listed with a trailing slash, so relative links work
+		if !strings.HasSuffix(req.URL.Path, "/") {
+			http.Redirect(rw, req, path.Base(req.URL.Path)+"/", http.StatusMovedPermanently)
+			return
+		}
+
+		//	Read the folder while transactions are held off, but let them go again before
+		//	the listing is sent
+		entries, err := f.ReadDir(-1)
+		if err != nil {
+			sendErrorResponse(rw, fmt.Errorf("problem reading folder: %w", err), http.StatusInternalServerError)
+			return
+		}
+		unlock()
+		unlock = func() {}
+
+		serveRepoFolder(rw, req, entries, name)
+		return
+	}
+
+	if !stat.Mode().IsRegular() {
+		http.NotFound(rw, req)
+		return
+	}
+
+	//	Read anything but a package file while transactions are held off, then let them go
+	//	again, so a slow client doesn't hold up publishing
+	var content io.ReadSeeker = f
+	if !packageFile {
+		data, err := io.ReadAll(f)
+		if err != nil {
+			sendErrorResponse(rw, fmt.Errorf("problem reading file: %w", err), http.StatusInternalServerError)
+			return
+		}
+		unlock()
+		unlock = func() {}
+		content = bytes.NewReader(data)
+	}
+
+	//	The working copy only changes when files are rewritten, so the size and
+	//	modification time identify a version of a file
+	rw.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, stat.ModTime().UnixNano(), stat.Size()))
+	rw.Header().Set("Content-Type", repoContentType(stat.Name()))
+	http.ServeContent(rw, req, stat.Name(), stat.ModTime(), content)
+}
+
+// serveRepoFolder sends a simple listing of the entries of a folder in the repo (leaving out anything hidden)
+func serveRepoFolder(rw http.ResponseWriter, req *http.Request, entries []os.DirEntry, name string) {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if req.Method == http.MethodHead {
+		return
+	}
+
+	fmt.Fprintf(rw, "<!doctype html>\n<title>Index of %s</title>\n<h1>Index of %s</h1>\n<pre>\n", html.EscapeString(name), html.EscapeString(name))
+	if name != "/" {
+		fmt.Fprintf(rw, "<a href=\"../\">../</a>\n")
+	}
+	for _, entry := range entries {
+		entryName := entry.Name()
+		if strings.HasPrefix(entryName, ".") {
+			continue
+		}
+		if entry.IsDir() {
+			entryName += "/"
+		}
+
+		link := url.URL{Path: entryName}
+		fmt.Fprintf(rw, "<a href=\"%s\">%s</a>\n", html.EscapeString(link.String()), html.EscapeString(entryName))
+	}
+	fmt.Fprintf(rw, "</pre>\n")
+}
+
+// repoContentType returns the content type to send a repo file with
+func repoContentType(name string) string {
+	//	Release.gpg is a signature, not a key
+	if name == "Release.gpg" {
+		return "application/pgp-signature"
+	}
+
+	ext := strings.ToLower(path.Ext(name))
+	if contentType, ok := repoContentTypes[ext]; ok {
+		return contentType
+	}
+	if ext == "" {
+		//	Release, InRelease, Packages and friends
+		return "text/plain; charset=utf-8"
+	}
+	if contentType := mime.TypeByExtension(ext); contentType != "" {
+		return contentType
+	}
+
+	return "application/octet-stream"
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/danesparza/package-assistant/internal/retention"
+	"net/http"
+)
+
+// RetentionPreview is what the retention policy would do if it ran now
+type RetentionPreview struct {
+	// Packages are the versions of each package that would be kept and removed, and why
+	Packages []retention.PackageSummary `json:"packages"`
+
+	// Remove are the package files that would be removed
+	Remove []string `json:"remove"`
+}
+
+// GetRetentionPreview godoc
+// @Summary Preview the retention policy
+// @Description Shows which versions of each package the retention policy would keep and remove if it ran now (and the rule responsible), without changing anything
+// @Tags retention
+// @Produce  json
+// @Success 200 {object} api.SystemResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /retention/preview [get]
+func (service Service) GetRetentionPreview(rw http.ResponseWriter, req *http.Request) {
+	plan, err := service.PublishSvc.PreviewPrune(req.Context())
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusInternalServerError)
+		return
+	}
+
+	response := SystemResponse{
+		Message: fmt.Sprintf("%d package files would be removed", len(plan.Remove)),
+		Data: RetentionPreview{
+			Packages: plan.Summary(),
+			Remove:   plan.Remove,
+		},
+	}
+
+	//	Serialize to JSON & return the response:
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(rw).Encode(response)
+}
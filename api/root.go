@@ -2,16 +2,29 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
+	"github.com/danesparza/package-assistant/internal/cache"
+	"github.com/danesparza/package-assistant/internal/debian"
+	"github.com/danesparza/package-assistant/internal/monitor"
+	"github.com/danesparza/package-assistant/internal/publish"
 	"github.com/danesparza/package-assistant/internal/repo"
 	"github.com/danesparza/package-assistant/version"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
 	"net/http"
+	"strings"
 	"time"
 )
 
 // Service encapsulates API service operations
 type Service struct {
-	StartTime time.Time
-	RepoSvc   repo.GitRepoService
+	StartTime  time.Time
+	RepoSvc    repo.GitRepoService
+	Signer     debian.Signer
+	Cache      *cache.Manager
+	PublishSvc publish.Service
+	Scheduler  *monitor.Scheduler
+	Election   *cache.Election
 }
 
 // SystemResponse is a response for a system request
@@ -23,6 +36,7 @@ type SystemResponse struct {
 // ErrorResponse represents an API response
 type ErrorResponse struct {
 	Message string `json:"message"`
+	Stage   string `json:"stage,omitempty"`
 }
 
 // sendErrorResponse is used to send back an error:
@@ -36,6 +50,20 @@ func sendErrorResponse(rw http.ResponseWriter, err error, code int) {
 	json.NewEncoder(rw).Encode(response)
 }
 
+// checkAuthToken makes sure the X-PackAuth header matches the configured auth token.  If it
+// doesn't, an error response is sent and false is returned.
+func checkAuthToken(rw http.ResponseWriter, req *http.Request) bool {
+	log.Debug().Msg("Validating X-PackAuth header")
+	authToken := req.Header.Get("X-PackAuth")
+	if strings.TrimSpace(authToken) != strings.TrimSpace(viper.GetString("auth.token")) {
+		err := fmt.Errorf("X-PackAuth token invalid")
+		sendErrorResponse(rw, err, http.StatusUnauthorized)
+		return false
+	}
+
+	return true
+}
+
 // ApiVersionMiddleware adds the API version informaiton to the response header
 func ApiVersionMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/danesparza/package-assistant/internal/monitor"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+)
+
+// GetTasks godoc
+// @Summary List the maintenance tasks
+// @Description Lists the background maintenance tasks (like pruning old package versions), their schedules, whether they're running and how their last run went
+// @Tags tasks
+// @Produce  json
+// @Success 200 {object} api.SystemResponse
+// @Router /tasks [get]
+func (service Service) GetTasks(rw http.ResponseWriter, req *http.Request) {
+	tasks := service.Scheduler.Status()
+
+	response := SystemResponse{
+		Message: fmt.Sprintf("%d tasks", len(tasks)),
+		Data:    tasks,
+	}
+
+	//	Serialize to JSON & return the response:
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(rw).Encode(response)
+}
+
+// RunTask godoc
+// @Summary Run a maintenance task now
+// @Description Starts a maintenance task in the background, without waiting for its schedule.  Use the task list to see how the run went.
+// @Tags tasks
+// @Produce  json
+// @Param name path string true "The task name"
+// @Success 202 {object} api.SystemResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Failure 409 {object} api.ErrorResponse
+// @Failure 503 {object} api.ErrorResponse
+// @Router /tasks/{name}/run [post]
+func (service Service) RunTask(rw http.ResponseWriter, req *http.Request) {
+	//	First check the auth token and make sure it exists on the header:
+	if !checkAuthToken(rw, req) {
+		return
+	}
+
+	name := chi.URLParam(req, "name")
+
+	status, err := service.Scheduler.Run(name, monitor.TriggerManual)
+	switch {
+	case errors.Is(err, monitor.ErrTaskNotFound):
+		sendErrorResponse(rw, err, http.StatusNotFound)
+		return
+	case errors.Is(err, monitor.ErrTaskRunning):
+		sendErrorResponse(rw, err, http.StatusConflict)
+		return
+	case err != nil:
+		sendErrorResponse(rw, err, http.StatusServiceUnavailable)
+		return
+	}
+
+	response := SystemResponse{
+		Message: fmt.Sprintf("Started task %s", name),
+		Data:    status,
+	}
+
+	//	Serialize to JSON & return the response:
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	rw.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(rw).Encode(response)
+}
@@ -0,0 +1,352 @@
+package api
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/danesparza/package-assistant/internal/debian"
+	"github.com/danesparza/package-assistant/internal/publish"
+	"github.com/danesparza/package-assistant/internal/upload"
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// UploadOffsetHeader is the offset a chunk starts at (in a request) or the offset an upload
+	// is at (in a response)
+	UploadOffsetHeader = "Upload-Offset"
+
+	// UploadLengthHeader is the size of the whole upload, if it's known
+	UploadLengthHeader = "Upload-Length"
+)
+
+// CreateUpload godoc
+// @Summary Start a resumable upload
+// @Description Starts a session to upload a package in chunks.  Send the chunks in order with PATCH (a failed chunk can be resumed from the upload's offset), then finish the upload with PUT and the package's SHA256.  Sessions are removed once they've gone upload.sessionttl without any data arriving.  Sessions are kept on the instance that created them: the session id starts with that instance's name (also in the response), so when running more than one instance, route /uploads/{id} requests on it (or use sticky sessions, or share upload.path between instances).  Requests for a session that reach another instance get a 421 naming the instance that has it.
+// @Tags upload
+// @Produce  json
+// @Param filename query string true "The package file name"
+// @Param suite query string false "The suite to publish to (pool layout only, defaults to repo.defaultsuite)"
+// @Param component query string false "The component to publish to (pool layout only, defaults to repo.defaultcomponent)"
+// @Param Upload-Length header int false "The size of the package, if it's known"
+// @Success 201 {object} api.SystemResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 413 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /uploads [post]
+func (service Service) CreateUpload(rw http.ResponseWriter, req *http.Request) {
+	//	First check the auth token and make sure it exists on the header:
+	if !checkAuthToken(rw, req) {
+		return
+	}
+
+	filename := req.URL.Query().Get("filename")
+	if !validUploadFilename(filename) {
+		sendErrorResponse(rw, fmt.Errorf("bad file name '%s'", filename), http.StatusBadRequest)
+		return
+	}
+
+	length := int64(0)
+	if value := req.Header.Get(UploadLengthHeader); value != "" {
+		var err error
+		length, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || length < 0 {
+			sendErrorResponse(rw, fmt.Errorf("%s must be a number of bytes (got '%s')", UploadLengthHeader, value), http.StatusBadRequest)
+			return
+		}
+	}
+
+	target, err := debian.ArchiveFromConfig().ResolveTarget(req.URL.Query().Get("suite"), req.URL.Query().Get("component"))
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
+	session, err := upload.StoreFromConfig().Create(filename, target, length)
+	if err != nil {
+		sendErrorResponse(rw, err, uploadErrorStatus(err))
+		return
+	}
+
+	response := SystemResponse{
+		Message: fmt.Sprintf("Upload session created for %s", filename),
+		Data:    session,
+	}
+
+	//	Serialize to JSON & return the response:
+	setUploadHeaders(rw, session)
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	rw.Header().Set("Location", uploadLocation(session.ID))
+	rw.WriteHeader(http.StatusCreated)
+	json.NewEncoder(rw).Encode(response)
+}
+
+// GetUpload godoc
+// @Summary Get a resumable upload
+// @Description Gets a resumable upload session, including the offset the next chunk should start at (also in the Upload-Offset header)
+// @Tags upload
+// @Produce  json
+// @Param id path string true "The upload session id"
+// @Success 200 {object} api.SystemResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Failure 421 {object} api.ErrorResponse "The upload session is kept on another instance"
+// @Failure 500 {object} api.ErrorResponse
+// @Router /uploads/{id} [get]
+func (service Service) GetUpload(rw http.ResponseWriter, req *http.Request) {
+	session, err := upload.StoreFromConfig().Get(chi.URLParam(req, "id"))
+	if err != nil {
+		sendErrorResponse(rw, err, uploadErrorStatus(err))
+		return
+	}
+
+	response := SystemResponse{
+		Message: fmt.Sprintf("Upload of %s is at offset %d", session.Filename, session.Offset),
+		Data:    session,
+	}
+
+	//	Serialize to JSON & return the response:
+	setUploadHeaders(rw, session)
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(rw).Encode(response)
+}
+
+// PatchUpload godoc
+// @Summary Upload a chunk
+// @Description Adds the request body to a resumable upload.  The chunk has to start where the upload left off (the Upload-Offset header).  If the chunk fails part way, what arrived is kept -- get the upload to find out where to resume from.
+// @Tags upload
+// @Accept  application/offset+octet-stream
+// @Produce  json
+// @Param id path string true "The upload session id"
+// @Param Upload-Offset header int true "The offset the chunk starts at"
+// @Param chunk body string true "The chunk"
+// @Success 200 {object} api.SystemResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Failure 409 {object} api.ErrorResponse "The chunk doesn't start at the upload's offset"
+// @Failure 413 {object} api.ErrorResponse
+// @Failure 421 {object} api.ErrorResponse "The upload session is kept on another instance"
+// @Failure 500 {object} api.ErrorResponse
+// @Router /uploads/{id} [patch]
+func (service Service) PatchUpload(rw http.ResponseWriter, req *http.Request) {
+	//	First check the auth token and make sure it exists on the header:
+	if !checkAuthToken(rw, req) {
+		return
+	}
+
+	value := req.Header.Get(UploadOffsetHeader)
+	offset, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || offset < 0 {
+		sendErrorResponse(rw, fmt.Errorf("%s must be the offset the chunk starts at (got '%s')", UploadOffsetHeader, value), http.StatusBadRequest)
+		return
+	}
+
+	session, err := upload.StoreFromConfig().Append(chi.URLParam(req, "id"), offset, req.Body)
+	if session.ID != "" {
+		setUploadHeaders(rw, session)
+	}
+	if err != nil {
+		sendErrorResponse(rw, err, uploadErrorStatus(err))
+		return
+	}
+
+	response := SystemResponse{
+		Message: fmt.Sprintf("Upload of %s is at offset %d", session.Filename, session.Offset),
+		Data:    session,
+	}
+
+	//	Serialize to JSON & return the response:
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(rw).Encode(response)
+}
+
+// FinishUpload godoc
+// @Summary Finish a resumable upload
+// @Description Puts the chunks of a resumable upload back together and checks them against the package's SHA256.  The package is then validated and published in the background, just like any other upload -- use the job in the response to follow along, or pass wait=true to wait for publishing to finish.
+// @Tags upload
+// @Produce  json
+// @Param id path string true "The upload session id"
+// @Param sha256 query string true "The SHA256 of the whole package (hex encoded)"
+// @Param wait query bool false "Wait for the package to be published before responding"
+// @Param package query string false "The package name the upload should have"
+// @Param version query string false "The version the upload should have"
+// @Param architecture query string false "The architecture the upload should have"
+// @Param X-Checksum-Sha256 header string false "The SHA256 the upload should have (hex encoded)"
+// @Param Digest header string false "Checksums the upload should have (RFC 3230, like 'sha-256=<base64>')"
+// @Param overwrite query bool false "Replace a package file already published as the same version with different content"
+// @Param Idempotency-Key header string false "A key for the upload -- retrying the same upload with the same key gets the same job instead of publishing again (unless the job failed for a reason that may go away)"
+// @Success 200 {object} api.SystemResponse "The same package file is already published, so nothing changed (wait=true only)"
+// @Success 201 {object} api.SystemResponse
+// @Success 202 {object} api.SystemResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Failure 409 {object} api.ErrorResponse "The upload is incomplete, a package file with different content is already published as the same version, or the repo lock was held by another operation for too long (wait=true only)"
+// @Failure 422 {object} api.ErrorResponse "The upload doesn't match the checksums, package, version or architecture it should have, or the Idempotency-Key was already used for a different upload"
+// @Failure 421 {object} api.ErrorResponse "The upload session is kept on another instance"
+// @Failure 500 {object} api.ErrorResponse
+// @Failure 503 {object} api.ErrorResponse "The repo lock is unavailable (wait=true only)"
+// @Router /uploads/{id} [put]
+func (service Service) FinishUpload(rw http.ResponseWriter, req *http.Request) {
+
+	//	Get configs
+	UploadPath := viper.GetString("upload.path")
+	archive := debian.ArchiveFromConfig()
+	store := upload.StoreFromConfig()
+
+	//	First check the auth token and make sure it exists on the header:
+	if !checkAuthToken(rw, req) {
+		return
+	}
+
+	key, err := idempotencyKeyHeader(req)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+
+	expectedSHA256 := req.URL.Query().Get("sha256")
+	if sum, err := hex.DecodeString(expectedSHA256); err != nil || len(sum) != 32 {
+		sendErrorResponse(rw, fmt.Errorf("sha256 must be the hex encoded SHA256 of the package (got '%s')", expectedSHA256), http.StatusBadRequest)
+		return
+	}
+
+	//	See what else the client expects the package to be, so we can check it
+	check, err := uploadCheckFromRequest(req, req.URL.Query().Get)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusBadRequest)
+		return
+	}
+	check.Checksums = append(check.Checksums, upload.Checksum{Algorithm: upload.AlgorithmSHA256, Value: expectedSHA256})
+
+	session, err := store.Get(chi.URLParam(req, "id"))
+	//	A retry once the session's finished can be answered by any instance
+	if (errors.Is(err, upload.ErrSessionNotFound) || errors.Is(err, upload.ErrWrongInstance)) && service.replayFinishedUpload(rw, req, key, expectedSHA256) {
+		return
+	}
+	if err != nil {
+		sendErrorResponse(rw, err, uploadErrorStatus(err))
+		return
+	}
+
+	log.Debug().Str("UploadPath", UploadPath).Msg("Creating upload path if it doesn't exist")
+	err = os.MkdirAll(UploadPath, os.ModePerm)
+	if err != nil {
+		err = fmt.Errorf("error creating uploads path: %w", err)
+		sendErrorResponse(rw, err, http.StatusInternalServerError)
+		return
+	}
+
+	//	Put the package together where any other upload would be
+	target := debian.Target{Suite: session.Suite, Component: session.Component}
+	job := publish.NewUploadJob("", session.Filename, target)
+	destinationFile := uploadDestination(UploadPath, job)
+	job.Params[publish.ParamFile] = destinationFile
+
+	session, digests, err := store.Finalize(session.ID, destinationFile, check.Checksums...)
+	if err != nil {
+		sendErrorResponse(rw, err, uploadErrorStatus(err))
+		return
+	}
+
+	job.Params[publish.ParamSize] = strconv.FormatInt(session.Offset, 10)
+	log.Debug().
+		Str("session", session.ID).
+		Int64("size", session.Offset).
+		Msg("Finished resumable upload")
+
+	service.queueUpload(rw, req, archive, job, session.Filename, digests, check)
+}
+
+// replayFinishedUpload responds with the job started by finishing a resumable upload, for a retry
+// with the same idempotency key once the session is gone.  It returns true if it responded.
+func (service Service) replayFinishedUpload(rw http.ResponseWriter, req *http.Request, key, sha256 string) bool {
+	if key == "" {
+		return false
+	}
+
+	claim, found, err := service.Cache.IdempotencyKeyClaim(req.Context(), key)
+	if err != nil {
+		sendErrorResponse(rw, err, http.StatusInternalServerError)
+		return true
+	}
+	if !found {
+		return false
+	}
+
+	//	The session (and the target it was for) is gone, so the package is all there is to check
+	if !strings.EqualFold(claim.SHA256, sha256) {
+		sendErrorResponse(rw, fmt.Errorf("%s '%s' was already used for a different upload", IdempotencyKeyHeader, key), http.StatusUnprocessableEntity)
+		return true
+	}
+
+	return service.replayJob(rw, req, claim.JobID)
+}
+
+// DeleteUpload godoc
+// @Summary Cancel a resumable upload
+// @Description Removes a resumable upload session and whatever was uploaded to it
+// @Tags upload
+// @Produce  json
+// @Param id path string true "The upload session id"
+// @Success 200 {object} api.SystemResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Failure 421 {object} api.ErrorResponse "The upload session is kept on another instance"
+// @Failure 500 {object} api.ErrorResponse
+// @Router /uploads/{id} [delete]
+func (service Service) DeleteUpload(rw http.ResponseWriter, req *http.Request) {
+	//	First check the auth token and make sure it exists on the header:
+	if !checkAuthToken(rw, req) {
+		return
+	}
+
+	id := chi.URLParam(req, "id")
+	if err := upload.StoreFromConfig().Delete(id); err != nil {
+		sendErrorResponse(rw, err, uploadErrorStatus(err))
+		return
+	}
+
+	response := SystemResponse{
+		Message: fmt.Sprintf("Upload session %s deleted", id),
+	}
+
+	//	Serialize to JSON & return the response:
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(rw).Encode(response)
+}
+
+// setUploadHeaders tells the client where the upload is at
+func setUploadHeaders(rw http.ResponseWriter, session upload.Session) {
+	rw.Header().Set(UploadOffsetHeader, strconv.FormatInt(session.Offset, 10))
+	if session.Length > 0 {
+		rw.Header().Set(UploadLengthHeader, strconv.FormatInt(session.Length, 10))
+	}
+	rw.Header().Set("Cache-Control", "no-store")
+}
+
+// uploadErrorStatus picks the http status code for an upload session error
+func uploadErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, upload.ErrSessionNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, upload.ErrWrongInstance):
+		return http.StatusMisdirectedRequest
+	case errors.Is(err, upload.ErrOffsetMismatch), errors.Is(err, upload.ErrIncomplete):
+		return http.StatusConflict
+	case errors.Is(err, upload.ErrTooBig):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, upload.ErrChecksumMismatch):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// uploadLocation returns the path of the upload session with the given id
+func uploadLocation(id string) string {
+	return "/v1/uploads/" + id
+}
@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/danesparza/package-assistant/internal/cache"
+	"github.com/danesparza/package-assistant/internal/debian"
+	"github.com/danesparza/package-assistant/internal/publish"
+	"github.com/danesparza/package-assistant/internal/repo"
+	"github.com/danesparza/package-assistant/internal/retention"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+var (
+	pruneDryRun bool
+	pruneApply  bool
+)
+
+// pruneCmd represents the prune command
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove old package versions using the retention policy",
+	Long: `The prune command applies the retention policy to the package repo.
+
+With --dry-run, it shows which versions of each package would be kept and removed
+(and the rule responsible) without changing anything.  With --apply, it removes
+them using the same locked pipeline as uploads: the repo is pulled, the files are
+removed, the indexes are refreshed and signed, and the change is committed and pushed.`,
+	RunE: prune,
+}
+
+func prune(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	if pruneDryRun == pruneApply {
+		return fmt.Errorf("use exactly one of --dry-run or --apply")
+	}
+
+	gitRepo, err := repo.InitPackageRepo(ctx,
+		viper.GetString("github.projecturl"),
+		viper.GetString("github.projectfolder"),
+		viper.GetString("github.user"),
+		viper.GetString("github.password"),
+	)
+	if err != nil {
+		return err
+	}
+
+	publishService := publish.Service{
+		RepoSvc: repo.NewGitRepoService(
+			viper.GetString("github.projecturl"),
+			viper.GetString("github.projectfolder"),
+			gitRepo),
+	}
+
+	//	Just show what would happen
+	if pruneDryRun {
+		plan, err := publishService.PreviewPrune(ctx)
+		if err != nil {
+			return err
+		}
+
+		printPlan(plan)
+		fmt.Printf("\n%d package files would be removed\n", len(plan.Remove))
+		return nil
+	}
+
+	//	Otherwise we need everything a real publish needs
+	publishService.Signer, err = debian.NewSignerFromConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("problem initializing signer: %w", err)
+	}
+
+	publishService.Cache, err = cache.NewManager()
+	if err != nil {
+		return fmt.Errorf("problem connecting to cache: %w", err)
+	}
+
+	plan, sha, err := publishService.Prune(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	printPlan(plan)
+	if sha == "" {
+		fmt.Println("\nNothing to remove")
+		return nil
+	}
+
+	fmt.Printf("\nRemoved %d package files in commit %s\n", len(plan.Remove), sha)
+	return nil
+}
+
+// printPlan shows what the plan decided for each package
+func printPlan(plan retention.Plan) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+
+	for _, pkg := range plan.Summary() {
+		name := fmt.Sprintf("%s (%s)", pkg.Package, pkg.Architecture)
+		if target := strings.Trim(pkg.Suite+"/"+pkg.Component, "/"); target != "" {
+			name += " in " + target
+		}
+
+		fmt.Fprintf(w, "%s, using %s\n", name, pkg.Rule)
+		for _, version := range pkg.Keep {
+			fmt.Fprintf(w, "  keep\t%s\t%s\t%s\n", version.Version, version.Filename, version.Reason)
+		}
+		for _, version := range pkg.Remove {
+			fmt.Fprintf(w, "  remove\t%s\t%s\t%s\n", version.Version, version.Filename, version.Reason)
+		}
+	}
+}
+
+func init() {
+	pruneCmd.Flags().BoolVar(&pruneDryRun, "dry-run", false, "show what would be removed without changing anything")
+	pruneCmd.Flags().BoolVar(&pruneApply, "apply", false, "remove the old package versions, then commit and push")
+	rootCmd.AddCommand(pruneCmd)
+}
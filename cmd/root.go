@@ -64,13 +64,19 @@ func initConfig() {
 	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	viper.AutomaticEnv() // read in environment variables that match
 
-	//	Set our defaults
+	//	Set our defaults.  When running more than one instance, note that upload.path and
+	//	github.projectfolder are per instance: an upload is queued and published by the instance
+	//	that received it, and the chunks of a resumable upload have to go to the instance that
+	//	created the session (session ids start with the instance's name, so route on that, use
+	//	sticky sessions, or share upload.path between instances).
 	viper.SetDefault("server.port", "8080")
 	viper.SetDefault("server.allowed-origins", "*")
 	viper.SetDefault("logger.level", "info")
 	viper.SetDefault("logger.format", "json")
 	viper.SetDefault("upload.path", path.Join(home, "package-assistant", "uploads"))
-	viper.SetDefault("upload.bytelimit", 30*1024*1024) // 30MB
+	viper.SetDefault("upload.bytelimit", 30*1024*1024)         // 30MB
+	viper.SetDefault("upload.streambytelimit", 1024*1024*1024) // 1GB (for uploads streamed as the request body or in chunks)
+	viper.SetDefault("upload.sessionttl", "24h")               // Resumable upload sessions are removed this long after data last arrived
 	viper.SetDefault("github.projecturl", "https://github.com/some/package-repo")
 	viper.SetDefault("github.projectfolder", "/data/package-repo")
 	viper.SetDefault("github.user", "someuser")
@@ -79,12 +85,49 @@ func initConfig() {
 	viper.SetDefault("git.email", "some@changethis.com")
 	viper.SetDefault("gpg.key", "some key")
 	viper.SetDefault("gpg.password", "some password")
+	viper.SetDefault("signing.backend", "native") // native, gpg or external
+	viper.SetDefault("signing.external.command", "")
+	viper.SetDefault("signing.external.socket", "")
+	viper.SetDefault("signing.external.timeout", "30s")
+	viper.SetDefault("repo.layout", "flat") // flat or pool (dists/ and pool/)
+	viper.SetDefault("repo.suites", []string{"stable"})
+	viper.SetDefault("repo.components", []string{"main"})
+	viper.SetDefault("repo.architectures", []string{}) // Empty means packages for any architecture are accepted
+	viper.SetDefault("repo.defaultsuite", "")          // Empty means the first suite in repo.suites
+	viper.SetDefault("repo.defaultcomponent", "")      // Empty means the first component in repo.components
+	viper.SetDefault("repo.url", "")                   // Where the repo is served from (downloads redirect there if it's set)
+	viper.SetDefault("repo.serve", false)              // Serve the repo files at /repo (so the service can be the apt endpoint)
+	viper.SetDefault("release.origin", "")
+	viper.SetDefault("release.label", "")
+	viper.SetDefault("release.suite", "")
+	viper.SetDefault("release.version", "")
+	viper.SetDefault("release.codename", "")
+	viper.SetDefault("release.description", "")
+	viper.SetDefault("release.architectures", []string{})
+	viper.SetDefault("release.components", []string{})
+	viper.SetDefault("release.validfor", "0s") // 0 means no Valid-Until field
 	viper.SetDefault("auth.token", "some_token")
 	viper.SetDefault("redis.host", "localhost")
 	viper.SetDefault("redis.port", "6379")
 	viper.SetDefault("redis.password", "")
 	viper.SetDefault("redis.tls", false)
-	viper.SetDefault("redis.TTL", "1h") // Default TTL of 1 hour
+	viper.SetDefault("redis.TTL", "1h")                                    // Default TTL of 1 hour
+	viper.SetDefault("retention.keep", 5)                                  // Keep the 5 newest versions of each package (see retention.PolicyFromConfig)
+	viper.SetDefault("retention.newerthan", "0s")                          // Also keep anything added more recently than this (0 turns this off)
+	viper.SetDefault("retention.keeplatest", "")                           // Also keep the latest of each major or minor version series
+	viper.SetDefault("retention.pinsfile", ".package-assistant/pins.json") // Pinned versions (relative to the repo folder)
+	viper.SetDefault("lock.timeout", "30s")                                // How long to wait for the repo lock before giving up
+	viper.SetDefault("lock.expiry", "1m")                                  // The repo lock expires this long after it was last extended
+	viper.SetDefault("jobs.ttl", "24h")                                    // How long job status is kept after the job was last updated
+	viper.SetDefault("jobs.draintimeout", "5m")                            // How long the job being worked on gets to finish when shutting down
+	viper.SetDefault("tasks.prune.schedule", "@every 10m")                 // Cron expression, descriptor or interval ("" or "off" only runs when asked)
+	viper.SetDefault("tasks.resign.schedule", "")                          // Set this (e.g. "@daily") when using release.validfor
+	viper.SetDefault("tasks.check.schedule", "@hourly")                    // Make sure indexes, signatures and packages match
+	viper.SetDefault("tasks.gc.schedule", "@weekly")                       // Tidy up the git object store
+	viper.SetDefault("tasks.gc.grace", "336h")                             // Unreachable git objects are kept this long (like git's gc.pruneExpire)
+	viper.SetDefault("tasks.uploads.schedule", "@every 15m")               // Remove expired upload sessions
+	viper.SetDefault("tasks.draintimeout", "5m")                           // How long running tasks get to finish when shutting down
+	viper.SetDefault("leader.ttl", "30s")                                  // How long the leader's lease lasts (it's renewed every third of this)
 
 	// If a config file is found, read it in
 	_ = viper.ReadInConfig()
@@ -2,12 +2,14 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/danesparza/package-assistant/api"
 	_ "github.com/danesparza/package-assistant/docs" // swagger docs location
 	"github.com/danesparza/package-assistant/internal/cache"
 	"github.com/danesparza/package-assistant/internal/debian"
 	"github.com/danesparza/package-assistant/internal/monitor"
+	"github.com/danesparza/package-assistant/internal/publish"
 	"github.com/danesparza/package-assistant/internal/repo"
 	"github.com/danesparza/package-assistant/internal/telemetry"
 	"github.com/go-chi/chi/v5"
@@ -21,10 +23,14 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 )
 
+// serverShutdownTimeout is how long requests in progress get to finish when shutting down
+const serverShutdownTimeout = 30 * time.Second
+
 // serveCmd represents the serve command
 var serveCmd = &cobra.Command{
 	Use:   "start",
@@ -58,12 +64,34 @@ func start(cmd *cobra.Command, args []string) {
 		Str("loglevel", loglevel).
 		Str("upload.path", viper.GetString("upload.path")).
 		Str("upload.bytelimit", viper.GetString("upload.bytelimit")).
+		Str("upload.streambytelimit", viper.GetString("upload.streambytelimit")).
+		Str("upload.sessionttl", viper.GetString("upload.sessionttl")).
 		Str("github.projecturl", viper.GetString("github.projecturl")).
 		Str("github.projectfolder", viper.GetString("github.projectfolder")).
 		Str("github.user", viper.GetString("github.user")).
 		Str("github.password", "********").
 		Str("git.name", viper.GetString("git.name")).
 		Str("git.email", viper.GetString("git.email")).
+		Str("signing.backend", viper.GetString("signing.backend")).
+		Str("repo.layout", viper.GetString("repo.layout")).
+		Bool("repo.serve", viper.GetBool("repo.serve")).
+		Strs("repo.suites", viper.GetStringSlice("repo.suites")).
+		Strs("repo.components", viper.GetStringSlice("repo.components")).
+		Strs("repo.architectures", viper.GetStringSlice("repo.architectures")).
+		Str("release.origin", viper.GetString("release.origin")).
+		Str("release.label", viper.GetString("release.label")).
+		Str("release.suite", viper.GetString("release.suite")).
+		Str("release.codename", viper.GetString("release.codename")).
+		Str("jobs.ttl", viper.GetString("jobs.ttl")).
+		Str("jobs.draintimeout", viper.GetString("jobs.draintimeout")).
+		Str("lock.timeout", viper.GetString("lock.timeout")).
+		Str("lock.expiry", viper.GetString("lock.expiry")).
+		Str("tasks.prune.schedule", viper.GetString("tasks.prune.schedule")).
+		Str("tasks.resign.schedule", viper.GetString("tasks.resign.schedule")).
+		Str("tasks.check.schedule", viper.GetString("tasks.check.schedule")).
+		Str("tasks.gc.schedule", viper.GetString("tasks.gc.schedule")).
+		Str("tasks.uploads.schedule", viper.GetString("tasks.uploads.schedule")).
+		Str("leader.ttl", viper.GetString("leader.ttl")).
 		Msg("Starting up")
 
 	// Service initialization
@@ -78,12 +106,9 @@ func start(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	err = debian.InitGPGKey(ctx,
-		viper.GetString("gpg.key"),
-		viper.GetString("gpg.password"),
-	)
+	signer, err := debian.NewSignerFromConfig(ctx)
 	if err != nil {
-		log.Err(err).Msg("problem initializing gpg key")
+		log.Err(err).Str("signing.backend", viper.GetString("signing.backend")).Msg("problem initializing signer")
 		return
 	}
 
@@ -99,18 +124,47 @@ func start(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	//	Create an api service object
-	apiService := api.Service{
-		StartTime: time.Now(),
-		RepoSvc:   repoSvc,
+	//	Create the publishing service and start working through queued jobs
+	publishService := publish.Service{
+		RepoSvc: repoSvc,
+		Signer:  signer,
+		Cache:   rdb,
 	}
+	var processing sync.WaitGroup
+	processing.Add(1)
+	go func() {
+		defer processing.Done()
+		publishService.ProcessJobs(ctx)
+	}()
+
+	//	Only the leader runs scheduled repo maintenance, so replicas don't all do the same work
+	//	(tasks that look after an instance's own disk and working copy run on every instance)
+	election := rdb.NewElection(cache.InstanceID(), viper.GetDuration("leader.ttl"))
+	go election.Run(ctx)
 
-	//	Create the background monitor service and start it
+	//	Create the background monitor service and start scheduling maintenance tasks
 	monitorService := monitor.Service{
-		StartTime: time.Now(),
-		RepoSvc:   repoSvc,
+		StartTime:  time.Now(),
+		PublishSvc: publishService,
+	}
+	scheduler, err := monitorService.NewScheduler()
+	if err != nil {
+		log.Err(err).Msg("problem creating maintenance task scheduler")
+		return
+	}
+	scheduler.RunScheduledWhen(election.IsLeader)
+	go scheduler.Start(ctx)
+
+	//	Create an api service object
+	apiService := api.Service{
+		StartTime:  time.Now(),
+		RepoSvc:    repoSvc,
+		Signer:     signer,
+		Cache:      rdb,
+		PublishSvc: publishService,
+		Scheduler:  scheduler,
+		Election:   election,
 	}
-	go monitorService.DiscardOldFileVersions(ctx, rdb)
 
 	//	Create a router and set up our REST endpoints...
 	r := chi.NewRouter()
@@ -119,38 +173,110 @@ func start(cmd *cobra.Command, args []string) {
 	r.Use(middleware.RequestID)
 	r.Use(middleware.RealIP)
 	r.Use(middleware.Recoverer)
-	r.Use(middleware.Compress(5))
 	r.Use(telemetry.Middleware(telemetry.NRApp))
-	r.Use(httprate.LimitByIP(100, 1*time.Minute)) // Rate limit
-	r.Use(api.ApiVersionMiddleware)
-	r.Use(cors.Handler(cors.Options{
-		// AllowedOrigins:   []string{"https://foo.com"}, // Use this to allow specific origin hosts
-		AllowedOrigins:   []string{"https://*", "http://*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
-		AllowCredentials: false,
-		MaxAge:           300, // Maximum value not ignored by any of major browsers
-	}))
-
-	//	Routes
-	r.Route("/v1", func(r chi.Router) {
-		r.Post("/package", apiService.UploadPackage)
-	})
 
-	//	SWAGGER
-	r.Mount("/swagger", httpSwagger.WrapHandler)
+	//	The repo files are served as they are: compressing them would break range requests
+	//	(and apt fetches a lot of files, so it isn't rate limited like the API)
+	if viper.GetBool("repo.serve") {
+		r.Get("/repo", apiService.ServeRepoFile)
+		r.Get("/repo/*", apiService.ServeRepoFile)
+		r.Head("/repo", apiService.ServeRepoFile)
+		r.Head("/repo/*", apiService.ServeRepoFile)
+	}
+
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.Compress(5))
+		r.Use(httprate.LimitByIP(100, 1*time.Minute)) // Rate limit
+		r.Use(api.ApiVersionMiddleware)
+		r.Use(cors.Handler(cors.Options{
+			// AllowedOrigins:   []string{"https://foo.com"}, // Use this to allow specific origin hosts
+			AllowedOrigins:   []string{"https://*", "http://*"},
+			AllowedMethods:   []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+			AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", api.UploadOffsetHeader, api.UploadLengthHeader, api.DigestHeader, api.ChecksumSHA256Header, api.IdempotencyKeyHeader},
+			ExposedHeaders:   []string{"Link", "Location", api.UploadOffsetHeader, api.UploadLengthHeader, api.DigestHeader, api.IdempotentReplayedHeader},
+			AllowCredentials: false,
+			MaxAge:           300, // Maximum value not ignored by any of major browsers
+		}))
+
+		//	Routes
+		r.Route("/v1", func(r chi.Router) {
+			r.Post("/package", apiService.UploadPackage)
+			r.Put("/package/{filename}", apiService.StreamPackage)
+			r.Post("/uploads", apiService.CreateUpload)
+			r.Get("/uploads/{id}", apiService.GetUpload)
+			r.Head("/uploads/{id}", apiService.GetUpload)
+			r.Patch("/uploads/{id}", apiService.PatchUpload)
+			r.Put("/uploads/{id}", apiService.FinishUpload)
+			r.Delete("/uploads/{id}", apiService.DeleteUpload)
+			r.Get("/packages", apiService.ListPackages)
+			r.Get("/packages/{name}", apiService.GetPackage)
+			r.Get("/packages/{name}/latest", apiService.GetLatestPackage)
+			r.Get("/packages/{name}/latest/download", apiService.DownloadLatestPackage)
+			r.Delete("/packages/{name}/{version}", apiService.DeletePackage)
+			r.Get("/jobs/{id}", apiService.GetJob)
+			r.Get("/retention/preview", apiService.GetRetentionPreview)
+			r.Put("/packages/{name}/{version}/pin", apiService.PinPackage)
+			r.Delete("/packages/{name}/{version}/pin", apiService.UnpinPackage)
+			r.Get("/tasks", apiService.GetTasks)
+			r.Post("/tasks/{name}/run", apiService.RunTask)
+			r.Get("/leader", apiService.GetLeader)
+		})
+
+		//	SWAGGER
+		r.Mount("/swagger", httpSwagger.WrapHandler)
+	})
 
 	formattedServerPort := fmt.Sprintf(":%v", viper.GetString("server.port"))
 
 	//	HTTP server
+	server := &http.Server{Addr: formattedServerPort, Handler: r}
 	go func() {
 		log.Info().Str("server", formattedServerPort).Msg("Started REST service")
-		log.Err(http.ListenAndServe(formattedServerPort, r)).Msg("HTTP API service error")
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			log.Err(err).Msg("HTTP API service error")
+		}
 	}()
 
 	//	Wait for our signal and shutdown gracefully
 	<-ctx.Done()
+
+	//	Stop taking requests, then let any maintenance tasks that are running finish
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), serverShutdownTimeout)
+	defer cancelShutdown()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Err(err).Msg("problem shutting down HTTP API service")
+	}
+
+	scheduler.Shutdown(viper.GetDuration("tasks.draintimeout"))
+
+	//	Let the job we're working on finish too (if it doesn't, it's run again when we restart)
+	drainTimeout := viper.GetDuration("jobs.draintimeout")
+	if !waitWithTimeout(&processing, drainTimeout) {
+		log.Warn().Dur("timeout", drainTimeout).Msg("Job still running after the drain timeout -- it will be run again on restart")
+	}
+
+	//	Now our work is done, let another instance take over
+	resignCtx, cancelResign := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelResign()
+	if err := election.Resign(resignCtx); err != nil {
+		log.Err(err).Msg("problem resigning as leader")
+	}
+}
+
+// waitWithTimeout waits for the WaitGroup for up to 'timeout'.  It returns false if it timed out.
+func waitWithTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
 }
 
 func handleSignals(ctx context.Context, sigs <-chan os.Signal, cancel context.CancelFunc) {
@@ -165,7 +291,6 @@ func handleSignals(ctx context.Context, sigs <-chan os.Signal, cancel context.Ca
 		}
 
 		cancel()
-		os.Exit(0)
 	}
 }
 
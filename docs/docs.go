@@ -19,26 +19,1239 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/jobs/{id}": {
+            "get": {
+                "description": "Get the status of a background job (like publishing an uploaded package), including the progress of each stage",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "jobs"
+                ],
+                "summary": "Get the status of a job",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The job id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.SystemResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/leader": {
+            "get": {
+                "description": "Shows which instance is the leader (the one that runs scheduled maintenance tasks), and whether it's the instance answering the request",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tasks"
+                ],
+                "summary": "Get the leader",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.SystemResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
         "/package": {
             "post": {
-                "description": "Upload package",
+                "description": "Upload a package.  The package is validated and then published in the background (as \u003cPackage\u003e_\u003cVersion\u003e_\u003cArchitecture\u003e.deb, whatever the file was called) -- use the job in the response to follow along, or pass wait=true to wait for publishing to finish.",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "package"
+                ],
+                "summary": "Upload a package",
+                "parameters": [
+                    {
+                        "type": "file",
+                        "description": "The file to upload",
+                        "name": "file",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "The suite to publish to (pool layout only, defaults to repo.defaultsuite)",
+                        "name": "suite",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "The component to publish to (pool layout only, defaults to repo.defaultcomponent)",
+                        "name": "component",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Wait for the package to be published before responding",
+                        "name": "wait",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "The package name the upload should have",
+                        "name": "package",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "The version the upload should have",
+                        "name": "version",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "The architecture the upload should have",
+                        "name": "architecture",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "The SHA256 the upload should have (hex encoded)",
+                        "name": "X-Checksum-Sha256",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Checksums the upload should have (RFC 3230, like 'sha-256=\u003cbase64\u003e')",
+                        "name": "Digest",
+                        "in": "header"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Replace a package file already published as the same version with different content",
+                        "name": "overwrite",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "A key for the upload -- retrying the same upload with the same key gets the same job instead of publishing again (unless the job failed for a reason that may go away)",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The same package file is already published, so nothing changed (wait=true only)",
+                        "schema": {
+                            "$ref": "#/definitions/api.SystemResponse"
+                        }
+                    },
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/api.SystemResponse"
+                        }
+                    },
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/api.SystemResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "A package file with different content is already published as the same version, or the repo lock was held by another operation for too long (wait=true only)",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "413": {
+                        "description": "Request Entity Too Large",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "The upload doesn't match the checksums, package, version or architecture it should have, or the Idempotency-Key was already used for a different upload",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "The repo lock is unavailable (wait=true only)",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/package/{filename}": {
+            "put": {
+                "description": "Upload a package as the raw request body (like 'curl -T').  The body is streamed straight to disk, so it can be bigger than a multipart upload (see upload.streambytelimit).  The package is validated and then published in the background (as \u003cPackage\u003e_\u003cVersion\u003e_\u003cArchitecture\u003e.deb, whatever the file was called) -- use the job in the response to follow along, or pass wait=true to wait for publishing to finish.",
+                "consumes": [
+                    "application/vnd.debian.binary-package"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "package"
+                ],
+                "summary": "Upload a package as the request body",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The package file name",
+                        "name": "filename",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "The package file",
+                        "name": "file",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "The suite to publish to (pool layout only, defaults to repo.defaultsuite)",
+                        "name": "suite",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "The component to publish to (pool layout only, defaults to repo.defaultcomponent)",
+                        "name": "component",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Wait for the package to be published before responding",
+                        "name": "wait",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "The package name the upload should have",
+                        "name": "package",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "The version the upload should have",
+                        "name": "version",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "The architecture the upload should have",
+                        "name": "architecture",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "The SHA256 the upload should have (hex encoded)",
+                        "name": "X-Checksum-Sha256",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Checksums the upload should have (RFC 3230, like 'sha-256=\u003cbase64\u003e')",
+                        "name": "Digest",
+                        "in": "header"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Replace a package file already published as the same version with different content",
+                        "name": "overwrite",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "A key for the upload -- retrying the same upload with the same key gets the same job instead of publishing again (unless the job failed for a reason that may go away)",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The same package file is already published, so nothing changed (wait=true only)",
+                        "schema": {
+                            "$ref": "#/definitions/api.SystemResponse"
+                        }
+                    },
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/api.SystemResponse"
+                        }
+                    },
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/api.SystemResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "A package file with different content is already published as the same version, or the repo lock was held by another operation for too long (wait=true only)",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "413": {
+                        "description": "Request Entity Too Large",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "The upload doesn't match the checksums, package, version or architecture it should have, or the Idempotency-Key was already used for a different upload",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "The repo lock is unavailable (wait=true only)",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/packages": {
+            "get": {
+                "description": "Lists the package files published by the repo (with their suite and component, size, SHA256, upload time and whether they're pinned).  Packages are sorted by name, then version using debian version ordering.  Use the nextCursor from a response to get the next page.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "package"
+                ],
+                "summary": "List published packages",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Only packages with names starting with this",
+                        "name": "name",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only packages for this architecture",
+                        "name": "arch",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only packages published to this suite",
+                        "name": "suite",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "List versions oldest first (asc) or newest first (desc, the default)",
+                        "name": "order",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "The nextCursor from the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "The most packages to return (default 100, at most 1000)",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.SystemResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/packages/{name}": {
+            "get": {
+                "description": "Gets every published version of a package (for every architecture, suite and component), newest first, with all of the fields from each package's control file and whether it's pinned",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "package"
+                ],
+                "summary": "Get a package",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The package name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.SystemResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/packages/{name}/latest": {
+            "get": {
+                "description": "Gets the newest published version of a package (using debian version ordering) that installs on the architecture and is published to the suite",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "package"
+                ],
+                "summary": "Get the latest version of a package",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The package name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only versions that install on this architecture (including 'all' packages)",
+                        "name": "arch",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only versions published to this suite",
+                        "name": "suite",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.SystemResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/packages/{name}/latest/download": {
+            "get": {
+                "description": "Downloads the newest published version of a package that installs on the architecture and is published to the suite.  If repo.url is set, this redirects to the package file there.  Otherwise the package file is sent from the repo's working copy.",
+                "produces": [
+                    "application/vnd.debian.binary-package"
+                ],
+                "tags": [
+                    "package"
+                ],
+                "summary": "Download the latest version of a package",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The package name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only versions that install on this architecture (including 'all' packages)",
+                        "name": "arch",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only versions published to this suite",
+                        "name": "suite",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "302": {
+                        "description": "Found"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/packages/{name}/{version}": {
+            "delete": {
+                "description": "Removes a version of a package (for every architecture, or just the one given), then refreshes and signs the indexes and commits and pushes the change, using the same repo lock and rollback as uploads.  Pinned versions are only deleted when forced.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "package"
+                ],
+                "summary": "Delete a package version",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The package name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "The package version",
+                        "name": "version",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only delete the package file for this architecture",
+                        "name": "arch",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Delete the version even if it's pinned (the pin is removed too)",
+                        "name": "force",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.SystemResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/packages/{name}/{version}/pin": {
+            "put": {
+                "description": "Pins a version of a package (for every architecture) so the retention policy never removes it.  Pinned versions don't count towards the number of versions to keep.  Pins are committed to the package repo.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "package"
+                ],
+                "summary": "Pin a package version",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The package name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "The package version",
+                        "name": "version",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Why the version is pinned",
+                        "name": "pin",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/api.PinRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.SystemResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Removes the pin for a version of a package, so the retention policy can remove it again",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "package"
+                ],
+                "summary": "Unpin a package version",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The package name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "The package version",
+                        "name": "version",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.SystemResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/retention/preview": {
+            "get": {
+                "description": "Shows which versions of each package the retention policy would keep and remove if it ran now (and the rule responsible), without changing anything",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "retention"
+                ],
+                "summary": "Preview the retention policy",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.SystemResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/tasks": {
+            "get": {
+                "description": "Lists the background maintenance tasks (like pruning old package versions), their schedules, whether they're running and how their last run went",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tasks"
+                ],
+                "summary": "List the maintenance tasks",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.SystemResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/tasks/{name}/run": {
+            "post": {
+                "description": "Starts a maintenance task in the background, without waiting for its schedule.  Use the task list to see how the run went.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tasks"
+                ],
+                "summary": "Run a maintenance task now",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The task name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/api.SystemResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/uploads": {
+            "post": {
+                "description": "Starts a session to upload a package in chunks.  Send the chunks in order with PATCH (a failed chunk can be resumed from the upload's offset), then finish the upload with PUT and the package's SHA256.  Sessions are removed once they've gone upload.sessionttl without any data arriving.  Sessions are kept on the instance that created them: the session id starts with that instance's name (also in the response), so when running more than one instance, route /uploads/{id} requests on it (or use sticky sessions, or share upload.path between instances).  Requests for a session that reach another instance get a 421 naming the instance that has it.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "upload"
+                ],
+                "summary": "Start a resumable upload",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The package file name",
+                        "name": "filename",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "The suite to publish to (pool layout only, defaults to repo.defaultsuite)",
+                        "name": "suite",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "The component to publish to (pool layout only, defaults to repo.defaultcomponent)",
+                        "name": "component",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "The size of the package, if it's known",
+                        "name": "Upload-Length",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/api.SystemResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "413": {
+                        "description": "Request Entity Too Large",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/uploads/{id}": {
+            "get": {
+                "description": "Gets a resumable upload session, including the offset the next chunk should start at (also in the Upload-Offset header)",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "upload"
+                ],
+                "summary": "Get a resumable upload",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The upload session id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.SystemResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "421": {
+                        "description": "The upload session is kept on another instance",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "Puts the chunks of a resumable upload back together and checks them against the package's SHA256.  The package is then validated and published in the background, just like any other upload -- use the job in the response to follow along, or pass wait=true to wait for publishing to finish.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "upload"
+                ],
+                "summary": "Finish a resumable upload",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The upload session id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "The SHA256 of the whole package (hex encoded)",
+                        "name": "sha256",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Wait for the package to be published before responding",
+                        "name": "wait",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "The package name the upload should have",
+                        "name": "package",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "The version the upload should have",
+                        "name": "version",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "The architecture the upload should have",
+                        "name": "architecture",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "The SHA256 the upload should have (hex encoded)",
+                        "name": "X-Checksum-Sha256",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Checksums the upload should have (RFC 3230, like 'sha-256=\u003cbase64\u003e')",
+                        "name": "Digest",
+                        "in": "header"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Replace a package file already published as the same version with different content",
+                        "name": "overwrite",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "A key for the upload -- retrying the same upload with the same key gets the same job instead of publishing again (unless the job failed for a reason that may go away)",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The same package file is already published, so nothing changed (wait=true only)",
+                        "schema": {
+                            "$ref": "#/definitions/api.SystemResponse"
+                        }
+                    },
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/api.SystemResponse"
+                        }
+                    },
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/api.SystemResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "The upload is incomplete, a package file with different content is already published as the same version, or the repo lock was held by another operation for too long (wait=true only)",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "421": {
+                        "description": "The upload session is kept on another instance",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "The upload doesn't match the checksums, package, version or architecture it should have, or the Idempotency-Key was already used for a different upload",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "The repo lock is unavailable (wait=true only)",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Removes a resumable upload session and whatever was uploaded to it",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "upload"
+                ],
+                "summary": "Cancel a resumable upload",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The upload session id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.SystemResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "421": {
+                        "description": "The upload session is kept on another instance",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "description": "Adds the request body to a resumable upload.  The chunk has to start where the upload left off (the Upload-Offset header).  If the chunk fails part way, what arrived is kept -- get the upload to find out where to resume from.",
                 "consumes": [
-                    "multipart/form-data"
+                    "application/offset+octet-stream"
                 ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "package"
+                    "upload"
                 ],
-                "summary": "Upload a package",
+                "summary": "Upload a chunk",
                 "parameters": [
                     {
-                        "type": "file",
-                        "description": "The file to upload",
-                        "name": "file",
-                        "in": "formData",
+                        "type": "string",
+                        "description": "The upload session id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "The offset the chunk starts at",
+                        "name": "Upload-Offset",
+                        "in": "header",
                         "required": true
+                    },
+                    {
+                        "description": "The chunk",
+                        "name": "chunk",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "string"
+                        }
                     }
                 ],
                 "responses": {
@@ -54,12 +1267,30 @@ const docTemplate = `{
                             "$ref": "#/definitions/api.ErrorResponse"
                         }
                     },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "The chunk doesn't start at the upload's offset",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
                     "413": {
                         "description": "Request Entity Too Large",
                         "schema": {
                             "$ref": "#/definitions/api.ErrorResponse"
                         }
                     },
+                    "421": {
+                        "description": "The upload session is kept on another instance",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -76,6 +1307,17 @@ const docTemplate = `{
             "properties": {
                 "message": {
                     "type": "string"
+                },
+                "stage": {
+                    "type": "string"
+                }
+            }
+        },
+        "api.PinRequest": {
+            "type": "object",
+            "properties": {
+                "reason": {
+                    "type": "string"
                 }
             }
         },
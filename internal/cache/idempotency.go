@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/spf13/viper"
+)
+
+// IdempotencyClaim records the job started by the request with an idempotency key, along with
+// what was uploaded (the fingerprint), so a request reusing the key for something else is caught
+type IdempotencyClaim struct {
+	JobID     string `json:"jobId"`
+	SHA256    string `json:"sha256"`
+	Suite     string `json:"suite,omitempty"`
+	Component string `json:"component,omitempty"`
+}
+
+// Matches returns true if the claims are for the same upload (whatever job they started)
+func (c IdempotencyClaim) Matches(other IdempotencyClaim) bool {
+	return c.SHA256 == other.SHA256 && c.Suite == other.Suite && c.Component == other.Component
+}
+
+// idempotencyKey is the key holding the claim on the idempotency key
+func idempotencyKey(key string) string {
+	return GetKey("idempotency", key)
+}
+
+// ClaimIdempotencyKey records that the request with the idempotency key started the claim's job.
+// The claim lasts as long as the job is kept (jobs.ttl).  If another request already claimed the
+// key, its claim is returned instead, and claimed is false.
+func (m *Manager) ClaimIdempotencyKey(ctx context.Context, key string, claim IdempotencyClaim) (IdempotencyClaim, bool, error) {
+	data, err := json.Marshal(claim)
+	if err != nil {
+		return IdempotencyClaim{}, false, fmt.Errorf("problem serializing idempotency claim: %w", err)
+	}
+
+	claimed, err := m.rdb.SetNX(ctx, idempotencyKey(key), data, viper.GetDuration("jobs.ttl")).Result()
+	if err != nil {
+		return IdempotencyClaim{}, false, fmt.Errorf("problem claiming idempotency key: %w", err)
+	}
+	if claimed {
+		return claim, true, nil
+	}
+
+	existing, found, err := m.IdempotencyKeyClaim(ctx, key)
+	if err == nil && !found {
+		//	It was released (or expired) in the meantime -- try again
+		return m.ClaimIdempotencyKey(ctx, key, claim)
+	}
+	return existing, false, err
+}
+
+// IdempotencyKeyClaim returns the claim on the idempotency key.  found is false if nobody has claimed it.
+func (m *Manager) IdempotencyKeyClaim(ctx context.Context, key string) (IdempotencyClaim, bool, error) {
+	return readIdempotencyClaim(ctx, m.rdb, key)
+}
+
+// readIdempotencyClaim reads the claim on the idempotency key with the client (or transaction)
+func readIdempotencyClaim(ctx context.Context, rdb redis.Cmdable, key string) (IdempotencyClaim, bool, error) {
+	retval := IdempotencyClaim{}
+
+	data, err := rdb.Get(ctx, idempotencyKey(key)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return retval, false, nil
+	}
+	if err != nil {
+		return retval, false, fmt.Errorf("problem getting idempotency key: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &retval); err != nil {
+		return retval, false, fmt.Errorf("problem reading idempotency claim: %w", err)
+	}
+
+	return retval, true, nil
+}
+
+// ReleaseIdempotencyKey removes the claim on the idempotency key, if it's still the claim for the
+// job -- so a request that retries with the key (after the job never started, or failed for a
+// reason that may go away) starts a new job
+func (m *Manager) ReleaseIdempotencyKey(ctx context.Context, key, jobID string) error {
+	err := m.rdb.Watch(ctx, func(tx *redis.Tx) error {
+		claim, found, err := readIdempotencyClaim(ctx, tx, key)
+		if err != nil || !found || claim.JobID != jobID {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, idempotencyKey(key))
+			return nil
+		})
+		return err
+	}, idempotencyKey(key))
+	if err != nil {
+		return fmt.Errorf("problem releasing idempotency key: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,259 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"os"
+	"time"
+)
+
+const (
+	JobStateQueued    = "queued"
+	JobStateRunning   = "running"
+	JobStateSucceeded = "succeeded"
+	JobStateFailed    = "failed"
+	JobStateSkipped   = "skipped"
+)
+
+// ErrJobNotFound is returned when a job doesn't exist (or has expired)
+var ErrJobNotFound = errors.New("job not found")
+
+// JobStage tracks the progress of a single step in a job
+type JobStage struct {
+	Name     string     `json:"name"`
+	State    string     `json:"state"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// Job is a unit of background work (like publishing an uploaded package).  Package is the
+// metadata of the package the job is about (if there is one), so it's known before the job runs.
+type Job struct {
+	ID          string            `json:"id"`
+	Type        string            `json:"type"`
+	State       string            `json:"state"`
+	Stages      []JobStage        `json:"stages"`
+	Params      map[string]string `json:"params,omitempty"`
+	Package     interface{}       `json:"package,omitempty"`
+	Result      interface{}       `json:"result,omitempty"`
+	CommitSHA   string            `json:"commitSha,omitempty"`
+	Error       string            `json:"error,omitempty"`
+	ErrorCode   string            `json:"errorCode,omitempty"`
+	FailedStage string            `json:"failedStage,omitempty"`
+	Created     time.Time         `json:"created"`
+	Updated     time.Time         `json:"updated"`
+}
+
+// NewJob creates a queued job of the given type with the named stages
+func NewJob(jobType string, params map[string]string, stages ...string) *Job {
+	now := time.Now()
+	retval := &Job{
+		ID:      newJobID(),
+		Type:    jobType,
+		State:   JobStateQueued,
+		Params:  params,
+		Created: now,
+		Updated: now,
+	}
+
+	for _, stage := range stages {
+		retval.Stages = append(retval.Stages, JobStage{Name: stage, State: JobStateQueued})
+	}
+
+	return retval
+}
+
+// newJobID returns a random job id
+func newJobID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// Done returns true if the job has finished (successfully or not)
+func (j *Job) Done() bool {
+	return j.State == JobStateSucceeded || j.State == JobStateFailed
+}
+
+// Restart resets a job that was interrupted part way through so it can run again from the beginning
+func (j *Job) Restart() {
+	j.State = JobStateQueued
+	j.Error = ""
+	j.ErrorCode = ""
+	j.FailedStage = ""
+	j.Updated = time.Now()
+	for i := range j.Stages {
+		j.Stages[i] = JobStage{Name: j.Stages[i].Name, State: JobStateQueued}
+	}
+}
+
+// StartStage marks the named stage as running
+func (j *Job) StartStage(name string) {
+	now := time.Now()
+	j.State = JobStateRunning
+	j.Updated = now
+	for i := range j.Stages {
+		if j.Stages[i].Name == name {
+			j.Stages[i].State = JobStateRunning
+			j.Stages[i].Started = &now
+		}
+	}
+}
+
+// FinishStage marks the named stage as succeeded, or failed if err isn't nil.
+// The job keeps track of which stage failed.
+func (j *Job) FinishStage(name string, err error) {
+	now := time.Now()
+	j.Updated = now
+	for i := range j.Stages {
+		if j.Stages[i].Name == name {
+			j.Stages[i].Finished = &now
+			j.Stages[i].State = JobStateSucceeded
+			if err != nil {
+				j.Stages[i].State = JobStateFailed
+				j.Stages[i].Error = err.Error()
+				j.FailedStage = name
+			}
+		}
+	}
+}
+
+// Finish marks the job as succeeded, or failed if err isn't nil.  Stages
+// that never ran are marked as skipped.
+func (j *Job) Finish(err error) {
+	j.Updated = time.Now()
+	j.State = JobStateSucceeded
+	if err != nil {
+		j.State = JobStateFailed
+		j.Error = err.Error()
+	}
+
+	for i := range j.Stages {
+		if j.Stages[i].State == JobStateQueued {
+			j.Stages[i].State = JobStateSkipped
+		}
+	}
+}
+
+// jobHost names the instance the job lists belong to.  It's the hostname (not the InstanceID), so
+// a restarted instance picks up where it left off.
+func jobHost() string {
+	hostname, _ := os.Hostname()
+	return hostname
+}
+
+// jobQueueKey is the list of job ids waiting to be processed by this instance.  Each instance
+// queues its own jobs: uploads are saved to the instance's upload.path and published from its
+// working copy, so another instance couldn't run them.
+func jobQueueKey() string {
+	return GetKey("jobs", "queue", jobHost())
+}
+
+// jobProcessingKey is the list of job ids this instance is working on.  Jobs move
+// here atomically when they're dequeued so a crash doesn't lose them.
+func jobProcessingKey() string {
+	return GetKey("jobs", "processing", jobHost())
+}
+
+// SaveJob stores the current state of the job
+func (m *Manager) SaveJob(ctx context.Context, job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("problem serializing job: %w", err)
+	}
+
+	err = m.rdb.Set(ctx, GetKey("job", job.ID), data, viper.GetDuration("jobs.ttl")).Err()
+	if err != nil {
+		return fmt.Errorf("problem saving job: %w", err)
+	}
+
+	return nil
+}
+
+// GetJob gets the current state of the job with the given id
+func (m *Manager) GetJob(ctx context.Context, id string) (*Job, error) {
+	data, err := m.rdb.Get(ctx, GetKey("job", id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("problem getting job: %w", err)
+	}
+
+	retval := &Job{}
+	if err := json.Unmarshal(data, retval); err != nil {
+		return nil, fmt.Errorf("problem reading job: %w", err)
+	}
+
+	return retval, nil
+}
+
+// EnqueueJob saves the job and adds it to this instance's work queue
+func (m *Manager) EnqueueJob(ctx context.Context, job *Job) error {
+	if err := m.SaveJob(ctx, job); err != nil {
+		return err
+	}
+
+	if err := m.rdb.LPush(ctx, jobQueueKey(), job.ID).Err(); err != nil {
+		return fmt.Errorf("problem queueing job: %w", err)
+	}
+
+	return nil
+}
+
+// DequeueJob waits up to 'timeout' for a job to work on.  It returns nil (and no error)
+// if nothing showed up in time.  Call CompleteJob once the job is finished.
+func (m *Manager) DequeueJob(ctx context.Context, timeout time.Duration) (*Job, error) {
+	id, err := m.rdb.BRPopLPush(ctx, jobQueueKey(), jobProcessingKey(), timeout).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("problem dequeuing job: %w", err)
+	}
+
+	job, err := m.GetJob(ctx, id)
+	if errors.Is(err, ErrJobNotFound) {
+		//	The job expired before we got to it -- nothing to do
+		log.Warn().Str("id", id).Msg("Dropping queued job that no longer exists")
+		m.rdb.LRem(ctx, jobProcessingKey(), 1, id)
+		return nil, nil
+	}
+
+	return job, err
+}
+
+// CompleteJob removes the job from this instance's processing list
+func (m *Manager) CompleteJob(ctx context.Context, job *Job) error {
+	if err := m.rdb.LRem(ctx, jobProcessingKey(), 1, job.ID).Err(); err != nil {
+		return fmt.Errorf("problem completing job: %w", err)
+	}
+
+	return nil
+}
+
+// RequeueInterruptedJobs puts any jobs this instance was working on when it
+// stopped back on the work queue.  It returns how many jobs were requeued.
+func (m *Manager) RequeueInterruptedJobs(ctx context.Context) (int, error) {
+	count := 0
+	for {
+		_, err := m.rdb.RPopLPush(ctx, jobProcessingKey(), jobQueueKey()).Result()
+		if errors.Is(err, redis.Nil) {
+			return count, nil
+		}
+		if err != nil {
+			return count, fmt.Errorf("problem requeueing jobs: %w", err)
+		}
+		count++
+	}
+}
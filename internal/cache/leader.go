@@ -0,0 +1,215 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/rs/zerolog/log"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	// renewLeaseScript extends the lease, but only if we still hold it
+	renewLeaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+	// releaseLeaseScript gives up the lease, but only if we still hold it
+	releaseLeaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+)
+
+// DefaultLeaderTTL is how long the leader's lease lasts if no (valid) ttl is given
+const DefaultLeaderTTL = 30 * time.Second
+
+// LeaderStatus describes which instance is the leader
+type LeaderStatus struct {
+	// Leader is the id of the instance holding the lease (empty if there's no leader right now)
+	Leader string `json:"leader"`
+
+	// Instance is the id of this instance
+	Instance string `json:"instance"`
+
+	// IsLeader is true if this instance is the leader
+	IsLeader bool `json:"isLeader"`
+
+	// Expires is when the leader's lease runs out if it isn't renewed
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// Election picks one instance (the leader) to do the work only one instance should do, like
+// running scheduled maintenance.  The leader holds a lease in redis and renews it well before
+// it expires.  If the leader dies, its lease runs out and another instance takes over.
+type Election struct {
+	manager *Manager
+	id      string
+	ttl     time.Duration
+
+	mu         sync.Mutex
+	leaseUntil time.Time
+}
+
+// NewElection creates an election this instance (with the given id) can take part in.
+// Leases last for 'ttl' (or DefaultLeaderTTL, if 'ttl' is less than a second).
+func (m *Manager) NewElection(id string, ttl time.Duration) *Election {
+	if ttl < time.Second {
+		log.Warn().Dur("ttl", ttl).Dur("default", DefaultLeaderTTL).Msg("Leader lease ttl is less than a second -- using the default")
+		ttl = DefaultLeaderTTL
+	}
+
+	return &Election{
+		manager: m,
+		id:      id,
+		ttl:     ttl,
+	}
+}
+
+// InstanceID returns an id for this instance: the hostname with a random suffix, so a
+// restarted instance (or one in a container that reuses the hostname) is told apart
+func InstanceID() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "unknown"
+	}
+
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return hostname
+	}
+
+	return fmt.Sprintf("%s-%s", hostname, hex.EncodeToString(b))
+}
+
+// leaderKey is the key holding the id of the leader
+func leaderKey() string {
+	return GetKey("leader")
+}
+
+// ID returns the id this instance uses in the election
+func (e *Election) ID() string {
+	return e.id
+}
+
+// Run campaigns to be the leader (and renews the lease while we are) until the context is done.
+// It doesn't give up the lease when it stops -- call Resign once the leader's work is finished.
+func (e *Election) Run(ctx context.Context) {
+	log.Info().Str("instance", e.id).Dur("ttl", e.ttl).Msg("Starting leader election...")
+
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		e.campaign(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			log.Info().Msg("Leader election stopping")
+			return
+		}
+	}
+}
+
+// campaign renews our lease if we hold it, or takes it if nobody does
+func (e *Election) campaign(ctx context.Context) {
+	//	Measure the lease from before we ask, so we never think we hold it for longer than redis does
+	started := time.Now()
+	ttl := e.ttl.Milliseconds()
+
+	held, err := renewLeaseScript.Run(ctx, e.manager.rdb, []string{leaderKey()}, e.id, ttl).Int()
+	if err == nil && held == 0 {
+		var acquired bool
+		acquired, err = e.manager.rdb.SetNX(ctx, leaderKey(), e.id, e.ttl).Result()
+		if acquired {
+			held = 1
+		}
+	}
+
+	if err != nil {
+		if ctx.Err() == nil {
+			//	Keep whatever lease we have until it runs out -- redis may be back by then
+			log.Err(err).Msg("problem renewing leader lease")
+		}
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	wasLeader := time.Now().Before(e.leaseUntil)
+	if held == 0 {
+		e.leaseUntil = time.Time{}
+		if wasLeader {
+			log.Warn().Str("instance", e.id).Msg("Lost the leader lease")
+		}
+		return
+	}
+
+	e.leaseUntil = started.Add(e.ttl)
+	if !wasLeader {
+		log.Info().Str("instance", e.id).Msg("Became the leader")
+	}
+}
+
+// IsLeader returns true if this instance currently holds the leader lease
+func (e *Election) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return time.Now().Before(e.leaseUntil)
+}
+
+// Resign gives up the lease (if we hold it), so another instance can take over right away
+func (e *Election) Resign(ctx context.Context) error {
+	e.mu.Lock()
+	e.leaseUntil = time.Time{}
+	e.mu.Unlock()
+
+	released, err := releaseLeaseScript.Run(ctx, e.manager.rdb, []string{leaderKey()}, e.id).Int()
+	if err != nil {
+		return fmt.Errorf("problem releasing leader lease: %w", err)
+	}
+
+	if released > 0 {
+		log.Info().Str("instance", e.id).Msg("Resigned as leader")
+	}
+	return nil
+}
+
+// Status returns which instance is the leader right now
+func (e *Election) Status(ctx context.Context) (LeaderStatus, error) {
+	retval := LeaderStatus{
+		Instance: e.id,
+		IsLeader: e.IsLeader(),
+	}
+
+	leader, err := e.manager.rdb.Get(ctx, leaderKey()).Result()
+	if errors.Is(err, redis.Nil) {
+		return retval, nil
+	}
+	if err != nil {
+		return retval, fmt.Errorf("problem getting leader: %w", err)
+	}
+	retval.Leader = leader
+
+	ttl, err := e.manager.rdb.PTTL(ctx, leaderKey()).Result()
+	if err != nil {
+		return retval, fmt.Errorf("problem getting leader lease: %w", err)
+	}
+	if ttl > 0 {
+		expires := time.Now().Add(ttl)
+		retval.Expires = &expires
+	}
+
+	return retval, nil
+}
@@ -0,0 +1,44 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"time"
+)
+
+// ErrNotCached is returned when a value isn't in the cache (or has expired)
+var ErrNotCached = errors.New("not cached")
+
+// GetValue reads the JSON value stored at the key into v
+func (m *Manager) GetValue(ctx context.Context, key string, v interface{}) error {
+	data, err := m.rdb.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return ErrNotCached
+	}
+	if err != nil {
+		return fmt.Errorf("problem getting %s: %w", key, err)
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("problem reading %s: %w", key, err)
+	}
+
+	return nil
+}
+
+// SetValue stores v as JSON at the key for the ttl
+func (m *Manager) SetValue(ctx context.Context, key string, v interface{}, ttl time.Duration) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("problem serializing %s: %w", key, err)
+	}
+
+	if err := m.rdb.Set(ctx, key, data, ttl).Err(); err != nil {
+		return fmt.Errorf("problem saving %s: %w", key, err)
+	}
+
+	return nil
+}
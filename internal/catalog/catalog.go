@@ -0,0 +1,304 @@
+package catalog
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/danesparza/package-assistant/internal/debian"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// OrderAscending lists the versions of each package oldest first
+	OrderAscending = "asc"
+
+	// OrderDescending lists the versions of each package newest first
+	OrderDescending = "desc"
+)
+
+// ErrInvalidCursor is returned when a query has a cursor we didn't hand out (or that doesn't match its sort order)
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Package is a package file as it's published to a suite and component
+type Package struct {
+	Package      string     `json:"package"`
+	Version      string     `json:"version"`
+	Architecture string     `json:"architecture"`
+	Size         int64      `json:"size"`
+	SHA256       string     `json:"sha256"`
+	Filename     string     `json:"filename"`
+	Suite        string     `json:"suite,omitempty"`
+	Component    string     `json:"component,omitempty"`
+	Uploaded     *time.Time `json:"uploaded,omitempty"`
+	Pinned       bool       `json:"pinned"`
+
+	// Control has all of the fields from the package's control file
+	Control map[string]string `json:"control,omitempty"`
+}
+
+// Index is every package file published by the repo, as of a commit
+type Index struct {
+	Commit   string    `json:"commit"`
+	Built    time.Time `json:"built"`
+	Packages []Package `json:"packages"`
+}
+
+// FileTimesFunc finds when each of the files (relative to the repo folder) was last committed
+type FileTimesFunc func(files []string) (map[string]time.Time, error)
+
+// Build reads what's published to each of the archive's suites and components into an index.
+// The upload time of each package file is the time it was last committed.
+func Build(ctx context.Context, archive debian.Archive, commit string, fileTimes FileTimesFunc) (Index, error) {
+	retval := Index{
+		Commit:   commit,
+		Built:    time.Now(),
+		Packages: []Package{},
+	}
+
+	files := []string{}
+	for _, target := range archive.Targets() {
+		pkgs, err := archive.TargetPackages(ctx, target)
+		if err != nil {
+			return retval, fmt.Errorf("problem reading packages published to %s/%s: %w", target.Suite, target.Component, err)
+		}
+
+		for _, pkg := range pkgs {
+			filename := strings.TrimPrefix(pkg.Filename, "./")
+			retval.Packages = append(retval.Packages, Package{
+				Package:      pkg.Package,
+				Version:      pkg.Version,
+				Architecture: pkg.Architecture,
+				Size:         pkg.Size,
+				SHA256:       pkg.SHA256,
+				Filename:     filename,
+				Suite:        target.Suite,
+				Component:    target.Component,
+				Control:      controlFields(pkg.Control),
+			})
+			files = append(files, filename)
+		}
+	}
+
+	if fileTimes != nil && len(files) > 0 {
+		times, err := fileTimes(files)
+		if err != nil {
+			return retval, fmt.Errorf("problem finding upload times: %w", err)
+		}
+
+		for i := range retval.Packages {
+			if uploaded, ok := times[retval.Packages[i].Filename]; ok {
+				retval.Packages[i].Uploaded = &uploaded
+			}
+		}
+	}
+
+	return retval, nil
+}
+
+// controlFields turns the control stanza into a map of field names to values
+func controlFields(control debian.Control) map[string]string {
+	retval := make(map[string]string, len(control))
+	for _, field := range control {
+		retval[field.Name] = strings.TrimPrefix(field.Value, "\n")
+	}
+
+	return retval
+}
+
+// Versions returns every published version of the named package (for every architecture,
+// suite and component), newest first
+func (index Index) Versions(name string) []Package {
+	retval := []Package{}
+	for _, pkg := range index.Packages {
+		if pkg.Package == name {
+			retval = append(retval, pkg)
+		}
+	}
+
+	sort.SliceStable(retval, func(i, j int) bool {
+		return compare(retval[i], retval[j], OrderDescending) < 0
+	})
+
+	return retval
+}
+
+// Latest returns the newest version of the named package that installs on the architecture
+// (including 'Architecture: all' packages) and is published to the suite.  An empty
+// architecture or suite matches any.
+func (index Index) Latest(name, arch, suite string) (Package, bool) {
+	for _, pkg := range index.Versions(name) {
+		if arch != "" && pkg.Architecture != arch && pkg.Architecture != debian.ArchitectureAll {
+			continue
+		}
+		if suite != "" && pkg.Suite != suite {
+			continue
+		}
+		return pkg, true
+	}
+
+	return Package{}, false
+}
+
+// Query selects and pages through the packages in an index
+type Query struct {
+	// NamePrefix only includes packages with names that start with it
+	NamePrefix string
+
+	// Architecture only includes packages built for it
+	Architecture string
+
+	// Suite only includes packages published to it
+	Suite string
+
+	// Order is the order versions of each package are listed in (OrderAscending or OrderDescending)
+	Order string
+
+	// Cursor continues from where a previous page left off
+	Cursor string
+
+	// Limit is the most packages to return
+	Limit int
+}
+
+// Page is one page of the packages selected by a query
+type Page struct {
+	Packages []Package `json:"packages"`
+
+	// Total is how many packages the query selected (across all pages)
+	Total int `json:"total"`
+
+	// NextCursor gets the next page (it's empty on the last page)
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// cursor is the position of the last package on a page
+type cursor struct {
+	Order        string `json:"o"`
+	Package      string `json:"p"`
+	Version      string `json:"v"`
+	Architecture string `json:"a"`
+	Suite        string `json:"s,omitempty"`
+	Component    string `json:"c,omitempty"`
+	Filename     string `json:"f"`
+}
+
+// Query returns a page of the packages in the index that match the query.  Packages are
+// sorted by name, then version (debian ordering), architecture, suite and component.
+func (index Index) Query(q Query) (Page, error) {
+	retval := Page{Packages: []Package{}}
+
+	order := strings.ToLower(q.Order)
+	if order == "" {
+		order = OrderDescending
+	}
+	if order != OrderAscending && order != OrderDescending {
+		return retval, fmt.Errorf("order must be '%s' or '%s' (got '%s')", OrderAscending, OrderDescending, q.Order)
+	}
+
+	selected := []Package{}
+	for _, pkg := range index.Packages {
+		if q.NamePrefix != "" && !strings.HasPrefix(pkg.Package, q.NamePrefix) {
+			continue
+		}
+		if q.Architecture != "" && pkg.Architecture != q.Architecture {
+			continue
+		}
+		if q.Suite != "" && pkg.Suite != q.Suite {
+			continue
+		}
+		selected = append(selected, pkg)
+	}
+
+	sort.SliceStable(selected, func(i, j int) bool {
+		return compare(selected[i], selected[j], order) < 0
+	})
+	retval.Total = len(selected)
+
+	//	Start after the last package of the previous page
+	start := 0
+	if q.Cursor != "" {
+		after, err := decodeCursor(q.Cursor)
+		if err != nil || after.Order != order {
+			return retval, ErrInvalidCursor
+		}
+
+		last := Package{
+			Package:      after.Package,
+			Version:      after.Version,
+			Architecture: after.Architecture,
+			Suite:        after.Suite,
+			Component:    after.Component,
+			Filename:     after.Filename,
+		}
+		start = sort.Search(len(selected), func(i int) bool {
+			return compare(selected[i], last, order) > 0
+		})
+	}
+
+	end := len(selected)
+	if q.Limit > 0 && start+q.Limit < end {
+		end = start + q.Limit
+	}
+	retval.Packages = append(retval.Packages, selected[start:end]...)
+
+	if end < len(selected) {
+		last := selected[end-1]
+		retval.NextCursor = encodeCursor(cursor{
+			Order:        order,
+			Package:      last.Package,
+			Version:      last.Version,
+			Architecture: last.Architecture,
+			Suite:        last.Suite,
+			Component:    last.Component,
+			Filename:     last.Filename,
+		})
+	}
+
+	return retval, nil
+}
+
+// compare orders packages by name, version (in the given order), architecture, suite, component and filename
+func compare(a, b Package, order string) int {
+	if c := strings.Compare(a.Package, b.Package); c != 0 {
+		return c
+	}
+	if c := debian.CompareVersions(a.Version, b.Version); c != 0 {
+		if order == OrderDescending {
+			return -c
+		}
+		return c
+	}
+	if c := strings.Compare(a.Architecture, b.Architecture); c != 0 {
+		return c
+	}
+	if c := strings.Compare(a.Suite, b.Suite); c != 0 {
+		return c
+	}
+	if c := strings.Compare(a.Component, b.Component); c != 0 {
+		return c
+	}
+	return strings.Compare(a.Filename, b.Filename)
+}
+
+// encodeCursor turns the cursor into an opaque string for a client to hand back
+func encodeCursor(c cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor reads a cursor handed back by a client
+func decodeCursor(s string) (cursor, error) {
+	retval := cursor{}
+
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return retval, err
+	}
+
+	err = json.Unmarshal(data, &retval)
+	return retval, err
+}
@@ -0,0 +1,167 @@
+package catalog
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// testIndex has a few versions of a few packages, in no particular order
+var testIndex = Index{Packages: []Package{
+	{Package: "foo", Version: "1.10", Architecture: "amd64", Suite: "stable", Component: "main"},
+	{Package: "bar", Version: "2.0", Architecture: "all", Suite: "stable", Component: "main"},
+	{Package: "foo", Version: "1.9", Architecture: "arm64", Suite: "stable", Component: "main"},
+	{Package: "foo", Version: "1.9", Architecture: "amd64", Suite: "stable", Component: "main"},
+	{Package: "foo", Version: "1.9", Architecture: "amd64", Suite: "testing", Component: "main"},
+	{Package: "foobar", Version: "0.1", Architecture: "amd64", Suite: "testing", Component: "main"},
+	{Package: "foo", Version: "1.10~rc1", Architecture: "amd64", Suite: "testing", Component: "main"},
+}}
+
+// describe lists the packages as 'name version architecture suite'
+func describe(pkgs []Package) []string {
+	retval := []string{}
+	for _, pkg := range pkgs {
+		retval = append(retval, pkg.Package+" "+pkg.Version+" "+pkg.Architecture+" "+pkg.Suite)
+	}
+
+	return retval
+}
+
+func TestQueryPages(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     Query
+		wantTotal int
+		wantPages [][]string
+	}{
+		{
+			name:      "everything on one page, newest first",
+			query:     Query{},
+			wantTotal: 7,
+			wantPages: [][]string{{
+				"bar 2.0 all stable",
+				"foo 1.10 amd64 stable",
+				"foo 1.10~rc1 amd64 testing",
+				"foo 1.9 amd64 stable",
+				"foo 1.9 amd64 testing",
+				"foo 1.9 arm64 stable",
+				"foobar 0.1 amd64 testing",
+			}},
+		},
+		{
+			name:      "pages of 3, oldest first",
+			query:     Query{Order: OrderAscending, Limit: 3},
+			wantTotal: 7,
+			wantPages: [][]string{
+				{"bar 2.0 all stable", "foo 1.9 amd64 stable", "foo 1.9 amd64 testing"},
+				{"foo 1.9 arm64 stable", "foo 1.10~rc1 amd64 testing", "foo 1.10 amd64 stable"},
+				{"foobar 0.1 amd64 testing"},
+			},
+		},
+		{
+			name:      "filtered by name and architecture",
+			query:     Query{NamePrefix: "foo", Architecture: "amd64", Limit: 2},
+			wantTotal: 5,
+			wantPages: [][]string{
+				{"foo 1.10 amd64 stable", "foo 1.10~rc1 amd64 testing"},
+				{"foo 1.9 amd64 stable", "foo 1.9 amd64 testing"},
+				{"foobar 0.1 amd64 testing"},
+			},
+		},
+		{
+			name:      "filtered to a suite, ending on a full page",
+			query:     Query{Suite: "stable", Limit: 2},
+			wantTotal: 4,
+			wantPages: [][]string{
+				{"bar 2.0 all stable", "foo 1.10 amd64 stable"},
+				{"foo 1.9 amd64 stable", "foo 1.9 arm64 stable"},
+			},
+		},
+		{
+			name:      "nothing matches",
+			query:     Query{NamePrefix: "baz", Limit: 2},
+			wantTotal: 0,
+			wantPages: [][]string{{}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := tt.query
+			pages := [][]string{}
+			for {
+				page, err := testIndex.Query(q)
+				if err != nil {
+					t.Fatalf("Query() error = %v", err)
+				}
+				if page.Total != tt.wantTotal {
+					t.Errorf("Query() total = %d, want %d", page.Total, tt.wantTotal)
+				}
+				pages = append(pages, describe(page.Packages))
+
+				if page.NextCursor == "" || len(pages) > len(tt.wantPages) {
+					break
+				}
+				q.Cursor = page.NextCursor
+			}
+
+			if !reflect.DeepEqual(pages, tt.wantPages) {
+				t.Errorf("Query() pages = %q, want %q", pages, tt.wantPages)
+			}
+		})
+	}
+}
+
+func TestQueryCursorAfterChanges(t *testing.T) {
+	first, err := testIndex.Query(Query{Limit: 2})
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+
+	//	The last package on the page is removed before the next page is fetched
+	changed := Index{Packages: []Package{}}
+	for _, pkg := range testIndex.Packages {
+		if pkg.Package != "foo" || pkg.Version != "1.10" {
+			changed.Packages = append(changed.Packages, pkg)
+		}
+	}
+
+	next, err := changed.Query(Query{Limit: 2, Cursor: first.NextCursor})
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+
+	want := []string{"foo 1.10~rc1 amd64 testing", "foo 1.9 amd64 stable"}
+	if got := describe(next.Packages); !reflect.DeepEqual(got, want) {
+		t.Errorf("Query() after the change = %q, want %q", got, want)
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	descending, err := testIndex.Query(Query{Limit: 1})
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		query      Query
+		wantCursor bool
+	}{
+		{name: "unknown order", query: Query{Order: "sideways"}},
+		{name: "garbage cursor", query: Query{Cursor: "not a cursor!"}, wantCursor: true},
+		{name: "cursor for the other order", query: Query{Order: OrderAscending, Cursor: descending.NextCursor}, wantCursor: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := testIndex.Query(tt.query)
+			if err == nil {
+				t.Fatal("Query() error = nil, want an error")
+			}
+			if errors.Is(err, ErrInvalidCursor) != tt.wantCursor {
+				t.Errorf("Query() error = %v, want ErrInvalidCursor: %v", err, tt.wantCursor)
+			}
+		})
+	}
+}
@@ -0,0 +1,495 @@
+package debian
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// LayoutFlat keeps every .deb in the root of the repo, for 'deb https://repo ./'
+	// style sources.  The Packages index in the root lists the packages for every
+	// architecture, and there's a binary-<arch>/Packages index for each architecture too.
+	LayoutFlat = "flat"
+
+	// LayoutPool uses the standard debian archive layout: packages live under
+	// pool/<component>/ and indexes under dists/<suite>/, for
+	// 'deb https://repo stable main' style sources
+	LayoutPool = "pool"
+)
+
+// ArchitectureAll is the architecture of packages that install on any architecture
+const ArchitectureAll = "all"
+
+var (
+	// ErrInvalidTarget is returned when an upload asks for a suite or component the repo doesn't serve
+	ErrInvalidTarget = errors.New("invalid suite or component")
+
+	// ErrUnsupportedArchitecture is returned when a package is for an architecture the repo doesn't serve
+	ErrUnsupportedArchitecture = errors.New("unsupported architecture")
+)
+
+// Target is a suite / component combination that packages are published to
+type Target struct {
+	Suite     string `json:"suite,omitempty"`
+	Component string `json:"component,omitempty"`
+}
+
+// Archive describes the layout of the package repo
+type Archive struct {
+	Folder        string
+	Layout        string
+	Suites        []string
+	Components    []string
+	Architectures []string
+	Release       ReleaseOptions
+}
+
+// ArchiveFromConfig gets the archive layout from the 'repo.*' and 'release.*' config keys
+func ArchiveFromConfig() Archive {
+	return Archive{
+		Folder:        viper.GetString("github.projectfolder"),
+		Layout:        strings.ToLower(strings.TrimSpace(viper.GetString("repo.layout"))),
+		Suites:        viper.GetStringSlice("repo.suites"),
+		Components:    viper.GetStringSlice("repo.components"),
+		Architectures: viper.GetStringSlice("repo.architectures"),
+		Release:       ReleaseOptionsFromConfig(),
+	}
+}
+
+// IsPool returns true if the archive uses the dists/ and pool/ layout
+func (a Archive) IsPool() bool {
+	return a.Layout == LayoutPool
+}
+
+// DefaultTarget returns the suite and component used when an upload doesn't specify them
+func (a Archive) DefaultTarget() Target {
+	retval := Target{
+		Suite:     viper.GetString("repo.defaultsuite"),
+		Component: viper.GetString("repo.defaultcomponent"),
+	}
+
+	if retval.Suite == "" && len(a.Suites) > 0 {
+		retval.Suite = a.Suites[0]
+	}
+
+	if retval.Component == "" && len(a.Components) > 0 {
+		retval.Component = a.Components[0]
+	}
+
+	return retval
+}
+
+// ResolveTarget fills in defaults for an empty suite or component and makes
+// sure the result is one the archive serves
+func (a Archive) ResolveTarget(suite, component string) (Target, error) {
+	retval := a.DefaultTarget()
+
+	if !a.IsPool() {
+		//	A flat repo has no suites or components to choose from
+		return Target{}, nil
+	}
+
+	if strings.TrimSpace(suite) != "" {
+		retval.Suite = strings.TrimSpace(suite)
+	}
+	if strings.TrimSpace(component) != "" {
+		retval.Component = strings.TrimSpace(component)
+	}
+
+	if !contains(a.Suites, retval.Suite) {
+		return retval, fmt.Errorf("%w: suite '%s' is not one of %s", ErrInvalidTarget, retval.Suite, strings.Join(a.Suites, ", "))
+	}
+
+	if !contains(a.Components, retval.Component) {
+		return retval, fmt.Errorf("%w: component '%s' is not one of %s", ErrInvalidTarget, retval.Component, strings.Join(a.Components, ", "))
+	}
+
+	return retval, nil
+}
+
+// Targets returns every suite / component combination the archive serves
+func (a Archive) Targets() []Target {
+	if !a.IsPool() {
+		return []Target{{}}
+	}
+
+	retval := []Target{}
+	for _, suite := range a.Suites {
+		for _, component := range a.Components {
+			retval = append(retval, Target{Suite: suite, Component: component})
+		}
+	}
+
+	return retval
+}
+
+// PackageFolder returns the folder (relative to the repo folder) a package belongs in
+// for the component.  In the pool layout, this follows the debian convention of
+// pool/<component>/<first letter>/<package>/ (or 'libx' instead of the first letter for lib* packages).
+func (a Archive) PackageFolder(component string, info PackageInfo) string {
+	if !a.IsPool() {
+		return "."
+	}
+
+	name := info.Package
+	prefix := name[:1]
+	if strings.HasPrefix(name, "lib") && len(name) > 3 {
+		prefix = name[:4]
+	}
+
+	return path.Join("pool", component, prefix, name)
+}
+
+// DistFolder returns the folder that holds the Release file for the suite
+func (a Archive) DistFolder(suite string) string {
+	if !a.IsPool() {
+		return a.Folder
+	}
+
+	return filepath.Join(a.Folder, "dists", suite)
+}
+
+// IndexFolder returns the folder that holds the Packages index for the target and architecture
+func (a Archive) IndexFolder(target Target, arch string) string {
+	if !a.IsPool() {
+		return filepath.Join(a.Folder, "binary-"+arch)
+	}
+
+	return filepath.Join(a.DistFolder(target.Suite), target.Component, "binary-"+arch)
+}
+
+// indexArchitectures returns the architectures that already have an index for the target
+func (a Archive) indexArchitectures(target Target) ([]string, error) {
+	retval := []string{}
+
+	entries, err := os.ReadDir(filepath.Join(a.DistFolder(target.Suite), target.Component))
+	if os.IsNotExist(err) {
+		return retval, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("problem reading index folders: %w", err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), "binary-") {
+			retval = append(retval, strings.TrimPrefix(entry.Name(), "binary-"))
+		}
+	}
+
+	return retval, nil
+}
+
+// TargetPackages returns the packages currently published to the target.  In the
+// pool layout, the published indexes are the record of which pool files belong to
+// which suite and component.  In the flat layout, it's every .deb in the repo.
+func (a Archive) TargetPackages(ctx context.Context, target Target) ([]PackageFile, error) {
+	if !a.IsPool() {
+		return ScanPackages(ctx, a.Folder)
+	}
+
+	archs, err := a.indexArchitectures(target)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	retval := []PackageFile{}
+	for _, arch := range archs {
+		pkgs, err := ReadPackagesIndex(filepath.Join(a.IndexFolder(target, arch), "Packages"))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, pkg := range pkgs {
+			if seen[pkg.Filename] {
+				continue
+			}
+			seen[pkg.Filename] = true
+			retval = append(retval, pkg)
+		}
+	}
+
+	SortPackageFiles(retval)
+
+	return retval, nil
+}
+
+// Publish regenerates the indexes, Release files and signatures for the archive.
+// Any package files in 'added' (absolute paths inside the repo folder) are added to
+// the target.  Index entries for package files that no longer exist are dropped.
+func (a Archive) Publish(ctx context.Context, signer Signer, target Target, added ...string) error {
+	if !a.IsPool() {
+		return a.publishFlat(ctx, signer)
+	}
+
+	for _, suite := range a.Suites {
+		published := make(map[string][]PackageFile)
+		archs := append([]string{}, a.Architectures...)
+
+		//	Gather what's published to each component of the suite
+		for _, component := range a.Components {
+			t := Target{Suite: suite, Component: component}
+
+			pkgs, err := a.TargetPackages(ctx, t)
+			if err != nil {
+				return err
+			}
+
+			//	Drop anything that's been removed from the pool
+			kept := []PackageFile{}
+			for _, pkg := range pkgs {
+				if _, err := os.Stat(filepath.Join(a.Folder, filepath.FromSlash(pkg.Filename))); err != nil {
+					log.Info().Str("suite", t.Suite).Str("component", t.Component).Str("filename", pkg.Filename).Msg("Dropping missing package from index")
+					continue
+				}
+				kept = append(kept, pkg)
+			}
+
+			//	Add the new packages to the target
+			if t == target {
+				for _, file := range added {
+					pkg, err := ScanPackageFile(a.Folder, file)
+					if err != nil {
+						return fmt.Errorf("problem scanning %s: %w", file, err)
+					}
+					pkg.Filename = strings.TrimPrefix(pkg.Filename, "./")
+
+					kept = replacePackageFile(kept, pkg)
+				}
+			}
+
+			SortPackageFiles(kept)
+			published[component] = kept
+			archs = append(archs, PackageArchitectures(kept)...)
+
+			//	Keep serving architectures that already have an index (if they're still allowed)
+			existing, err := a.indexArchitectures(t)
+			if err != nil {
+				return err
+			}
+			for _, arch := range existing {
+				if arch != ArchitectureAll && a.AllowsArchitecture(arch) {
+					archs = append(archs, arch)
+				}
+			}
+		}
+
+		//	Every component gets an index for every architecture in the suite
+		archs = uniqueSorted(archs)
+		if len(archs) == 0 {
+			archs = []string{ArchitectureAll}
+		}
+
+		for _, component := range a.Components {
+			t := Target{Suite: suite, Component: component}
+			if err := a.writeTargetIndexes(t, published[component], archs); err != nil {
+				return err
+			}
+		}
+
+		release := a.Release
+		release.Suite = suite
+		if release.Codename == "" {
+			release.Codename = suite
+		}
+		if len(release.Architectures) == 0 {
+			release.Architectures = archs
+		}
+		release.Components = a.Components
+
+		if err := a.publishRelease(ctx, signer, a.DistFolder(suite), release); err != nil {
+			return fmt.Errorf("problem publishing suite %s: %w", suite, err)
+		}
+	}
+
+	return nil
+}
+
+// replacePackageFile adds the package to the list, replacing any entry with the same filename
+func replacePackageFile(pkgs []PackageFile, pkg PackageFile) []PackageFile {
+	for i := range pkgs {
+		if pkgs[i].Filename == pkg.Filename {
+			pkgs[i] = pkg
+			return pkgs
+		}
+	}
+
+	return append(pkgs, pkg)
+}
+
+// writeTargetIndexes writes a Packages index for each of the architectures in the target.
+// 'Architecture: all' packages are included in every architecture's index.  Index folders
+// for architectures that are no longer served are removed.
+func (a Archive) writeTargetIndexes(target Target, pkgs []PackageFile, archs []string) error {
+	existing, err := a.indexArchitectures(target)
+	if err != nil {
+		return err
+	}
+	for _, arch := range existing {
+		if contains(archs, arch) {
+			continue
+		}
+
+		log.Info().Str("suite", target.Suite).Str("component", target.Component).Str("architecture", arch).Msg("Removing index for architecture that is no longer served")
+		if err := os.RemoveAll(a.IndexFolder(target, arch)); err != nil {
+			return fmt.Errorf("problem removing index folder: %w", err)
+		}
+	}
+
+	for _, arch := range archs {
+		archPkgs := []PackageFile{}
+		for _, pkg := range pkgs {
+			if pkg.Architecture == arch || pkg.Architecture == ArchitectureAll {
+				archPkgs = append(archPkgs, pkg)
+			}
+		}
+
+		folder := a.IndexFolder(target, arch)
+		if err := os.MkdirAll(folder, os.ModePerm); err != nil {
+			return fmt.Errorf("problem creating index folder: %w", err)
+		}
+
+		if err := WritePackagesFiles(folder, archPkgs); err != nil {
+			return fmt.Errorf("problem writing %s/%s index for %s: %w", target.Suite, target.Component, arch, err)
+		}
+
+		log.Debug().
+			Str("suite", target.Suite).
+			Str("component", target.Component).
+			Str("architecture", arch).
+			Int("packages", len(archPkgs)).
+			Msg("Wrote package index")
+	}
+
+	return nil
+}
+
+// AllowsArchitecture returns true if packages for the architecture can be published.
+// 'all' packages are always allowed, as is everything if there is no allow-list.
+func (a Archive) AllowsArchitecture(arch string) bool {
+	return len(a.Architectures) == 0 || arch == ArchitectureAll || contains(a.Architectures, arch)
+}
+
+// ValidateArchitecture returns an error if packages for the architecture can't be published
+func (a Archive) ValidateArchitecture(arch string) error {
+	if !a.AllowsArchitecture(arch) {
+		return fmt.Errorf("%w: '%s' is not one of %s", ErrUnsupportedArchitecture, arch, strings.Join(a.Architectures, ", "))
+	}
+
+	return nil
+}
+
+// PackageArchitectures returns the sorted list of binary architectures (everything but 'all')
+// the packages are built for
+func PackageArchitectures(pkgs []PackageFile) []string {
+	archs := []string{}
+	for _, pkg := range pkgs {
+		if pkg.Architecture != ArchitectureAll {
+			archs = append(archs, pkg.Architecture)
+		}
+	}
+
+	return uniqueSorted(archs)
+}
+
+// publishFlat regenerates the indexes and Release file in the root of a flat repo.  The
+// Packages index in the root has every allowed package, and each architecture gets its
+// own binary-<arch>/Packages index (with the 'Architecture: all' packages in it too).
+func (a Archive) publishFlat(ctx context.Context, signer Signer) error {
+	//	Build the Packages / Packages.gz index (replaces dpkg-scanpackages --multiversion . and gzip -k -f Packages)
+	scanned, err := ScanPackages(ctx, a.Folder)
+	if err != nil {
+		return fmt.Errorf("problem scanning packages: %w", err)
+	}
+
+	//	Leave out anything for an architecture the repo doesn't serve
+	pkgs := []PackageFile{}
+	for _, pkg := range scanned {
+		if !a.AllowsArchitecture(pkg.Architecture) {
+			log.Info().Str("filename", pkg.Filename).Str("architecture", pkg.Architecture).Msg("Leaving package for unsupported architecture out of the index")
+			continue
+		}
+		pkgs = append(pkgs, pkg)
+	}
+
+	err = WritePackagesFiles(a.Folder, pkgs)
+	if err != nil {
+		return fmt.Errorf("problem writing package index: %w", err)
+	}
+	log.Debug().Int("packages", len(pkgs)).Msg("Wrote package index")
+
+	//	Every allowed architecture (and any the packages are built for) gets an index
+	archs := uniqueSorted(append(append([]string{}, a.Architectures...), PackageArchitectures(pkgs)...))
+	if len(archs) == 0 {
+		archs = []string{ArchitectureAll}
+	}
+
+	if err := a.writeTargetIndexes(Target{}, pkgs, archs); err != nil {
+		return err
+	}
+
+	release := a.Release
+	if len(release.Architectures) == 0 {
+		release.Architectures = archs
+	}
+
+	return a.publishRelease(ctx, signer, a.Folder, release)
+}
+
+// publishRelease writes and signs the Release file for the folder
+func (a Archive) publishRelease(ctx context.Context, signer Signer, folder string, release ReleaseOptions) error {
+	//	A suite with nothing published yet still gets a (signed) Release file
+	err := os.MkdirAll(folder, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("problem creating release folder: %w", err)
+	}
+
+	//	Build the Release file (replaces apt-ftparchive release . > Release)
+	err = WriteReleaseFile(folder, release)
+	if err != nil {
+		return fmt.Errorf("problem writing release file: %w", err)
+	}
+
+	//	Sign the Release file (replaces gpg -abs and gpg --clearsign)
+	err = SignRelease(ctx, folder, signer)
+	if err != nil {
+		return fmt.Errorf("problem signing release file: %w", err)
+	}
+
+	return nil
+}
+
+// contains returns true if the list contains the item
+func contains(list []string, item string) bool {
+	for _, entry := range list {
+		if entry == item {
+			return true
+		}
+	}
+
+	return false
+}
+
+// uniqueSorted returns the sorted list without duplicates or empty entries
+func uniqueSorted(list []string) []string {
+	seen := make(map[string]bool)
+	retval := []string{}
+	for _, item := range list {
+		if item == "" || seen[item] {
+			continue
+		}
+		seen[item] = true
+		retval = append(retval, item)
+	}
+	sort.Strings(retval)
+
+	return retval
+}
@@ -0,0 +1,220 @@
+package debian
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// testSigner makes placeholder signatures, so the published files don't change from run to run
+type testSigner struct{}
+
+func (testSigner) DetachSign(ctx context.Context, data []byte) ([]byte, error) {
+	return []byte("detached signature\n"), nil
+}
+
+func (testSigner) ClearSign(ctx context.Context, data []byte) ([]byte, error) {
+	return append([]byte("clearsigned\n"), data...), nil
+}
+
+// testArchive is an archive in a temp folder, with fixed Release options
+func testArchive(t *testing.T, layout string, archs ...string) Archive {
+	t.Helper()
+
+	return Archive{
+		Folder:        t.TempDir(),
+		Layout:        layout,
+		Suites:        []string{"stable"},
+		Components:    []string{"main", "contrib"},
+		Architectures: archs,
+		Release: ReleaseOptions{
+			Origin: "Example",
+			Date:   time.Date(2024, time.March, 5, 12, 0, 0, 0, time.UTC),
+		},
+	}
+}
+
+// copyTestPackage copies the .deb from testdata to the path (relative to the folder), returning where it went
+func copyTestPackage(t *testing.T, folder, name, dest string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := filepath.Join(folder, filepath.FromSlash(dest), name)
+	if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+// publishedIndexes returns every Packages and Release file under the folder, one after the other
+func publishedIndexes(t *testing.T, folder string) []byte {
+	t.Helper()
+
+	names := []string{}
+	err := filepath.WalkDir(folder, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && (d.Name() == "Packages" || d.Name() == "Release") {
+			rel, err := filepath.Rel(folder, p)
+			if err != nil {
+				return err
+			}
+			names = append(names, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(folder, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(&buf, "==> %s <==\n%s\n", name, data)
+	}
+
+	return buf.Bytes()
+}
+
+func TestPublishFlat(t *testing.T) {
+	tests := []struct {
+		name   string
+		archs  []string
+		golden string
+	}{
+		{name: "any architecture", golden: "Publish.flat.golden"},
+		{name: "allow-list", archs: []string{"amd64", "arm64", "armhf"}, golden: "Publish.flat-allowed.golden"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive := testArchive(t, LayoutFlat, tt.archs...)
+			for _, name := range []string{"foo_1.0_amd64.deb", "bar_1.2-1_all.deb", "baz_1.0_arm64.deb", "qux_0.1_i386.deb"} {
+				copyTestPackage(t, archive.Folder, name, ".")
+			}
+
+			//	An index for an architecture that's no longer served is removed
+			writeTestFiles(t, archive.Folder, map[string]string{"binary-mips/Packages": ""})
+
+			if err := archive.Publish(context.Background(), testSigner{}, Target{}); err != nil {
+				t.Fatalf("Publish() error = %v", err)
+			}
+
+			checkGolden(t, tt.golden, publishedIndexes(t, archive.Folder))
+
+			problems, err := archive.Check(context.Background())
+			if err != nil {
+				t.Fatalf("Check() error = %v", err)
+			}
+			if len(problems) != 0 {
+				t.Errorf("Check() found problems with what was published: %q", problems)
+			}
+		})
+	}
+}
+
+func TestPublishPool(t *testing.T) {
+	tests := []struct {
+		name     string
+		archs    []string
+		existing map[string]string
+		added    []string
+		removed  []string
+		golden   string
+	}{
+		{
+			name:   "architectures from the packages",
+			added:  []string{"foo_1.0_amd64.deb", "bar_1.2-1_all.deb", "baz_1.0_arm64.deb"},
+			golden: "Publish.pool.golden",
+		},
+		{
+			name:  "allow-list",
+			archs: []string{"amd64", "armhf"},
+			existing: map[string]string{
+				"dists/stable/main/binary-i386/Packages":     "",
+				"dists/stable/contrib/binary-armhf/Packages": "",
+			},
+			added:  []string{"foo_1.0_amd64.deb", "bar_1.2-1_all.deb"},
+			golden: "Publish.pool-allowed.golden",
+		},
+		{
+			name:    "files missing from the pool are dropped",
+			added:   []string{"bar_1.2-1_all.deb"},
+			removed: []string{"foo_1.0_amd64.deb", "baz_1.0_arm64.deb"},
+			golden:  "Publish.pool-removed.golden",
+		},
+		{
+			name:   "nothing published",
+			golden: "Publish.pool-empty.golden",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			archive := testArchive(t, LayoutPool, tt.archs...)
+			target := Target{Suite: "stable", Component: "main"}
+			writeTestFiles(t, archive.Folder, tt.existing)
+
+			//	Publish everything, then publish again once the removed files are gone from the pool
+			added := []string{}
+			removed := []string{}
+			for _, name := range append(append([]string{}, tt.added...), tt.removed...) {
+				info, err := ReadPackageFile(filepath.Join("testdata", name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				p := copyTestPackage(t, archive.Folder, name, archive.PackageFolder(target.Component, info))
+				if contains(tt.removed, name) {
+					removed = append(removed, p)
+				} else {
+					added = append(added, p)
+				}
+			}
+
+			if err := archive.Publish(ctx, testSigner{}, target, append(added, removed...)...); err != nil {
+				t.Fatalf("Publish() error = %v", err)
+			}
+
+			if len(removed) > 0 {
+				for _, p := range removed {
+					if err := os.Remove(p); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err := archive.Publish(ctx, testSigner{}, target); err != nil {
+					t.Fatalf("Publish() error = %v", err)
+				}
+			}
+
+			checkGolden(t, tt.golden, publishedIndexes(t, archive.Folder))
+
+			problems, err := archive.Check(ctx)
+			if err != nil {
+				t.Fatalf("Check() error = %v", err)
+			}
+			if len(problems) != 0 {
+				t.Errorf("Check() found problems with what was published: %q", problems)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package debian
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Check makes sure what's published is consistent with what's in the repo folder.  It
+// returns a description of each problem it finds: Release files that are missing, unsigned
+// or expired, index files that don't match their Release file, index entries for
+// package files that are missing or don't match their size or SHA256, and package
+// files that can't be read (which would stop the indexes being published).
+func (a Archive) Check(ctx context.Context) ([]string, error) {
+	problems := []string{}
+
+	//	Each Release file, and the Packages indexes it describes
+	folders := []string{}
+	releases := make(map[string][]string)
+	if !a.IsPool() {
+		//	A flat repo also has the index in its root
+		folders = append(folders, a.Folder)
+		releases[a.Folder] = []string{a.Folder}
+	}
+	for _, target := range a.Targets() {
+		archs, err := a.indexArchitectures(target)
+		if err != nil {
+			return nil, err
+		}
+
+		folder := a.DistFolder(target.Suite)
+		if _, ok := releases[folder]; !ok {
+			folders = append(folders, folder)
+			releases[folder] = []string{}
+		}
+		for _, arch := range archs {
+			releases[folder] = append(releases[folder], a.IndexFolder(target, arch))
+		}
+	}
+
+	checked := make(map[string]bool)
+	for _, folder := range folders {
+		indexFolders := releases[folder]
+		found, err := a.checkRelease(folder, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, found...)
+
+		for _, indexFolder := range indexFolders {
+			pkgs, err := ReadPackagesIndex(filepath.Join(indexFolder, "Packages"))
+			if err != nil {
+				return nil, err
+			}
+
+			for _, pkg := range pkgs {
+				if err := ctx.Err(); err != nil {
+					return nil, err
+				}
+
+				//	'all' packages show up in every architecture's index
+				filename := strings.TrimPrefix(pkg.Filename, "./")
+				if checked[filename] {
+					continue
+				}
+				checked[filename] = true
+
+				if problem := a.checkPackageFile(pkg); problem != "" {
+					problems = append(problems, problem)
+				}
+			}
+		}
+	}
+
+	//	A package file that can't be read stops the indexes being published, so report any of those too
+	found, err := a.checkUnindexedPackageFiles(ctx, checked)
+	if err != nil {
+		return nil, err
+	}
+	problems = append(problems, found...)
+
+	return problems, nil
+}
+
+// checkUnindexedPackageFiles makes sure the package files that aren't in an index (by their
+// filename without any leading './') can be read
+func (a Archive) checkUnindexedPackageFiles(ctx context.Context, indexed map[string]bool) ([]string, error) {
+	problems := []string{}
+
+	err := filepath.WalkDir(a.Folder, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if d.IsDir() {
+			if p != a.Folder && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !d.Type().IsRegular() || !strings.HasSuffix(d.Name(), ".deb") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(a.Folder, p)
+		if err != nil {
+			return err
+		}
+		if indexed[strings.TrimPrefix(filepath.ToSlash(rel), "./")] {
+			return nil
+		}
+
+		if _, err := ScanPackageFile(a.Folder, p); err != nil {
+			problems = append(problems, fmt.Sprintf("%s isn't in the index and can't be read: %v", filepath.ToSlash(rel), err))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("problem looking for package files: %w", err)
+	}
+
+	return problems, nil
+}
+
+// checkRelease checks the Release file in the folder against the signatures and the index files it lists
+func (a Archive) checkRelease(folder string, now time.Time) ([]string, error) {
+	problems := []string{}
+
+	//	Report files relative to the repo folder
+	display := func(name string) string {
+		rel, err := filepath.Rel(a.Folder, filepath.Join(folder, filepath.FromSlash(name)))
+		if err != nil {
+			return name
+		}
+		return filepath.ToSlash(rel)
+	}
+
+	release, err := os.ReadFile(filepath.Join(folder, "Release"))
+	if os.IsNotExist(err) {
+		return append(problems, fmt.Sprintf("%s is missing", display("Release"))), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("problem reading Release: %w", err)
+	}
+
+	for _, name := range []string{"Release.gpg", "InRelease"} {
+		if _, err := os.Stat(filepath.Join(folder, name)); err != nil {
+			problems = append(problems, fmt.Sprintf("%s is missing", display(name)))
+		}
+	}
+
+	if inRelease, err := os.ReadFile(filepath.Join(folder, "InRelease")); err == nil && !bytes.Contains(inRelease, release) {
+		problems = append(problems, fmt.Sprintf("%s doesn't match the Release file", display("InRelease")))
+	}
+
+	control, err := ParseControl(bytes.NewReader(release))
+	if err != nil {
+		return nil, fmt.Errorf("problem parsing %s: %w", display("Release"), err)
+	}
+
+	if validUntil := control.Get("Valid-Until"); validUntil != "" {
+		expires, err := time.Parse(releaseDateFormat, validUntil)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s has an invalid Valid-Until: %s", display("Release"), validUntil))
+		} else if expires.Before(now) {
+			problems = append(problems, fmt.Sprintf("%s expired at %s", display("Release"), validUntil))
+		}
+	}
+
+	//	Every index file listed (by SHA256) should still have the same size and hash
+	for _, line := range strings.Split(control.Get("SHA256"), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		sum, size, name := fields[0], fields[1], fields[2]
+
+		indexFile, err := hashReleaseIndexFile(filepath.Join(folder, filepath.FromSlash(name)))
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s lists %s, which can't be read", display("Release"), display(name)))
+			continue
+		}
+
+		if strconv.FormatInt(indexFile.Size, 10) != size || indexFile.Checksums[releaseChecksumIndex("SHA256")] != sum {
+			problems = append(problems, fmt.Sprintf("%s doesn't match the Release file", display(name)))
+		}
+	}
+
+	return problems, nil
+}
+
+// checkPackageFile makes sure the package file in an index entry exists and matches its size and SHA256
+func (a Archive) checkPackageFile(pkg PackageFile) string {
+	f, err := os.Open(filepath.Join(a.Folder, filepath.FromSlash(pkg.Filename)))
+	if err != nil {
+		return fmt.Sprintf("%s (%s %s) is in the index but can't be opened", pkg.Filename, pkg.Package, pkg.Version)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return fmt.Sprintf("%s (%s %s) can't be read", pkg.Filename, pkg.Package, pkg.Version)
+	}
+
+	if size != pkg.Size || hex.EncodeToString(h.Sum(nil)) != pkg.SHA256 {
+		return fmt.Sprintf("%s (%s %s) doesn't match its index entry", pkg.Filename, pkg.Package, pkg.Version)
+	}
+
+	return ""
+}
+
+// releaseChecksumIndex returns the position of the named checksum in releaseChecksums
+func releaseChecksumIndex(name string) int {
+	for i, checksum := range releaseChecksums {
+		if checksum.Name == name {
+			return i
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,95 @@
+package debian
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name   string
+		layout string
+		change func(t *testing.T, archive Archive)
+		want   []string
+	}{
+		{
+			name:   "pool layout as published",
+			layout: LayoutPool,
+			want:   []string{},
+		},
+		{
+			name:   "flat layout as published",
+			layout: LayoutFlat,
+			want:   []string{},
+		},
+		{
+			name:   "published package file changed",
+			layout: LayoutPool,
+			change: func(t *testing.T, archive Archive) {
+				writeTestFiles(t, archive.Folder, map[string]string{"pool/main/f/foo/foo_1.0_amd64.deb": "not a package"})
+			},
+			want: []string{"pool/main/f/foo/foo_1.0_amd64.deb (foo 1.0) doesn't match its index entry"},
+		},
+		{
+			name:   "unreadable package file that isn't published",
+			layout: LayoutPool,
+			change: func(t *testing.T, archive Archive) {
+				writeTestFiles(t, archive.Folder, map[string]string{"pool/main/b/baz/baz_1.0_arm64.deb": "not a package"})
+			},
+			want: []string{"pool/main/b/baz/baz_1.0_arm64.deb isn't in the index and can't be read: problem reading pool/main/b/baz/baz_1.0_arm64.deb: invalid debian package: not an ar archive"},
+		},
+		{
+			name:   "missing signature",
+			layout: LayoutFlat,
+			change: func(t *testing.T, archive Archive) {
+				if err := os.Remove(filepath.Join(archive.Folder, "InRelease")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: []string{"InRelease is missing"},
+		},
+		{
+			name:   "index changed",
+			layout: LayoutFlat,
+			change: func(t *testing.T, archive Archive) {
+				writeTestFiles(t, archive.Folder, map[string]string{"binary-amd64/Packages": ""})
+			},
+			want: []string{"binary-amd64/Packages doesn't match the Release file"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			archive := testArchive(t, tt.layout)
+			target := archive.Targets()[0]
+
+			added := []string{}
+			for _, name := range []string{"foo_1.0_amd64.deb", "bar_1.2-1_all.deb"} {
+				info, err := ReadPackageFile(filepath.Join("testdata", name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				added = append(added, copyTestPackage(t, archive.Folder, name, archive.PackageFolder(target.Component, info)))
+			}
+			if err := archive.Publish(ctx, testSigner{}, target, added...); err != nil {
+				t.Fatalf("Publish() error = %v", err)
+			}
+
+			if tt.change != nil {
+				tt.change(t, archive)
+			}
+
+			got, err := archive.Check(ctx)
+			if err != nil {
+				t.Fatalf("Check() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Check() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
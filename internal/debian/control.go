@@ -0,0 +1,125 @@
+package debian
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ControlField is a single field in a debian control stanza
+type ControlField struct {
+	Name  string
+	Value string
+}
+
+// Control is an ordered list of the fields in a debian control stanza
+type Control []ControlField
+
+// Get returns the value of the named field (case-insensitive), or an empty string
+// if the field doesn't exist
+func (c Control) Get(name string) string {
+	for _, field := range c {
+		if strings.EqualFold(field.Name, name) {
+			return field.Value
+		}
+	}
+
+	return ""
+}
+
+// Has returns true if the named field (case-insensitive) exists in the stanza
+func (c Control) Has(name string) bool {
+	for _, field := range c {
+		if strings.EqualFold(field.Name, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseControl parses a single control stanza (like the one found in the
+// control file of a .deb package).  Multi-line field values are kept with
+// their continuation lines joined by newlines.
+func ParseControl(r io.Reader) (Control, error) {
+	stanzas, err := parseStanzas(r, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(stanzas) == 0 {
+		return Control{}, nil
+	}
+
+	return stanzas[0], nil
+}
+
+// ParseControlStanzas parses all of the blank line separated stanzas in a
+// control formatted file (like a Packages index)
+func ParseControlStanzas(r io.Reader) ([]Control, error) {
+	return parseStanzas(r, -1)
+}
+
+// parseStanzas parses up to 'limit' stanzas (or all of them if limit is negative)
+func parseStanzas(r io.Reader, limit int) ([]Control, error) {
+	retval := []Control{}
+	current := Control{}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		//	A blank line ends the stanza
+		if strings.TrimSpace(line) == "" {
+			if len(current) > 0 {
+				retval = append(retval, current)
+				current = Control{}
+				if limit > 0 && len(retval) >= limit {
+					return retval, nil
+				}
+			}
+			continue
+		}
+
+		//	Skip comments
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		//	Continuation lines belong to the previous field
+		if line[0] == ' ' || line[0] == '\t' {
+			if len(current) == 0 {
+				return nil, fmt.Errorf("continuation line without a field: %q", line)
+			}
+			current[len(current)-1].Value += "\n" + line
+			continue
+		}
+
+		name, value, found := strings.Cut(line, ":")
+		if !found || strings.TrimSpace(name) == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("malformed control line: %q", line)
+		}
+
+		if current.Has(name) {
+			return nil, fmt.Errorf("duplicate control field: %s", name)
+		}
+
+		current = append(current, ControlField{
+			Name:  name,
+			Value: strings.TrimSpace(value),
+		})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("problem reading control data: %w", err)
+	}
+
+	if len(current) > 0 {
+		retval = append(retval, current)
+	}
+
+	return retval, nil
+}
@@ -0,0 +1,293 @@
+package debian
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidPackage is returned when a file is not a well-formed debian binary package
+var ErrInvalidPackage = errors.New("invalid debian package")
+
+// ErrPackageNotFound is returned when a package (or version of a package) isn't in the repo
+var ErrPackageNotFound = errors.New("package not found")
+
+const (
+	arMagic        = "!<arch>\n"
+	arHeaderSize   = 60
+	maxControlSize = 16 * 1024 * 1024
+)
+
+var (
+	packageNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9+.\-]+$`)
+	versionRe     = regexp.MustCompile(`^([0-9]+:)?[0-9][A-Za-z0-9.+~\-:]*$`)
+	archRe        = regexp.MustCompile(`^[a-z0-9][a-z0-9\-]*$`)
+	epochRe       = regexp.MustCompile(`^[0-9]+$`)
+)
+
+// requiredFields are the control fields every binary package must have
+var requiredFields = []string{"Package", "Version", "Architecture", "Maintainer", "Description"}
+
+// PackageInfo is the metadata read from the control file of a .deb package
+type PackageInfo struct {
+	Package       string  `json:"package"`
+	Source        string  `json:"source,omitempty"`
+	Version       string  `json:"version"`
+	Architecture  string  `json:"architecture"`
+	Maintainer    string  `json:"maintainer"`
+	InstalledSize string  `json:"installedSize,omitempty"`
+	Depends       string  `json:"depends,omitempty"`
+	PreDepends    string  `json:"preDepends,omitempty"`
+	Recommends    string  `json:"recommends,omitempty"`
+	Suggests      string  `json:"suggests,omitempty"`
+	Conflicts     string  `json:"conflicts,omitempty"`
+	Breaks        string  `json:"breaks,omitempty"`
+	Replaces      string  `json:"replaces,omitempty"`
+	Provides      string  `json:"provides,omitempty"`
+	Section       string  `json:"section,omitempty"`
+	Priority      string  `json:"priority,omitempty"`
+	Homepage      string  `json:"homepage,omitempty"`
+	Description   string  `json:"description"`
+	Control       Control `json:"-"`
+}
+
+// NewPackageInfo creates package metadata from a parsed control stanza
+func NewPackageInfo(control Control) PackageInfo {
+	return PackageInfo{
+		Package:       control.Get("Package"),
+		Source:        control.Get("Source"),
+		Version:       control.Get("Version"),
+		Architecture:  control.Get("Architecture"),
+		Maintainer:    control.Get("Maintainer"),
+		InstalledSize: control.Get("Installed-Size"),
+		Depends:       control.Get("Depends"),
+		PreDepends:    control.Get("Pre-Depends"),
+		Recommends:    control.Get("Recommends"),
+		Suggests:      control.Get("Suggests"),
+		Conflicts:     control.Get("Conflicts"),
+		Breaks:        control.Get("Breaks"),
+		Replaces:      control.Get("Replaces"),
+		Provides:      control.Get("Provides"),
+		Section:       control.Get("Section"),
+		Priority:      control.Get("Priority"),
+		Homepage:      control.Get("Homepage"),
+		Description:   control.Get("Description"),
+		Control:       control,
+	}
+}
+
+// Validate makes sure the package metadata describes a well-formed binary package
+func (p PackageInfo) Validate() error {
+	for _, field := range requiredFields {
+		if strings.TrimSpace(p.Control.Get(field)) == "" {
+			return fmt.Errorf("%w: missing required control field '%s'", ErrInvalidPackage, field)
+		}
+	}
+
+	if !packageNameRe.MatchString(p.Package) {
+		return fmt.Errorf("%w: invalid package name '%s'", ErrInvalidPackage, p.Package)
+	}
+
+	if !versionRe.MatchString(p.Version) {
+		return fmt.Errorf("%w: invalid version '%s'", ErrInvalidPackage, p.Version)
+	}
+
+	if !archRe.MatchString(p.Architecture) {
+		return fmt.Errorf("%w: invalid architecture '%s'", ErrInvalidPackage, p.Architecture)
+	}
+
+	return nil
+}
+
+// CanonicalFilename is the name the package file should have: <Package>_<Version>_<Architecture>.deb.
+// Like the debian archive, the version's epoch is left out (it's in the package index, and colons
+// cause trouble in file names and URLs) -- any other colons in the version are escaped as %3a.
+func (p PackageInfo) CanonicalFilename() string {
+	version := p.Version
+	if epoch, rest, found := strings.Cut(version, ":"); found && epochRe.MatchString(epoch) {
+		version = rest
+	}
+	version = strings.ReplaceAll(version, ":", "%3a")
+
+	return fmt.Sprintf("%s_%s_%s.deb", p.Package, version, p.Architecture)
+}
+
+// ReadPackageFile reads and validates the control metadata of the .deb package at the given path
+func ReadPackageFile(debFile string) (PackageInfo, error) {
+	f, err := os.Open(debFile)
+	if err != nil {
+		return PackageInfo{}, fmt.Errorf("problem opening package: %w", err)
+	}
+	defer f.Close()
+
+	return ReadPackage(f)
+}
+
+// ReadPackage reads and validates the control metadata of a .deb package
+func ReadPackage(r io.Reader) (PackageInfo, error) {
+	control, err := ReadControl(r)
+	if err != nil {
+		return PackageInfo{}, err
+	}
+
+	retval := NewPackageInfo(control)
+	if err := retval.Validate(); err != nil {
+		return PackageInfo{}, err
+	}
+
+	return retval, nil
+}
+
+// ReadControl reads the control stanza out of a .deb package.  The package is an
+// ar archive with a 'debian-binary' member followed by a 'control.tar' member that
+// may be uncompressed or compressed with gzip, xz or zstd.
+func ReadControl(r io.Reader) (Control, error) {
+	br := bufio.NewReader(r)
+
+	magic := make([]byte, len(arMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != arMagic {
+		return nil, fmt.Errorf("%w: not an ar archive", ErrInvalidPackage)
+	}
+
+	sawBinary := false
+	for {
+		name, size, err := readArHeader(br)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		member := &io.LimitedReader{R: br, N: size}
+
+		switch {
+		case name == "debian-binary":
+			format, err := io.ReadAll(io.LimitReader(member, 16))
+			if err != nil {
+				return nil, fmt.Errorf("%w: problem reading debian-binary: %v", ErrInvalidPackage, err)
+			}
+			if !strings.HasPrefix(string(format), "2.") {
+				return nil, fmt.Errorf("%w: unsupported package format '%s'", ErrInvalidPackage, strings.TrimSpace(string(format)))
+			}
+			sawBinary = true
+
+		case strings.HasPrefix(name, "control.tar"):
+			if !sawBinary {
+				return nil, fmt.Errorf("%w: control archive found before debian-binary", ErrInvalidPackage)
+			}
+			if size > maxControlSize {
+				return nil, fmt.Errorf("%w: control archive is too large", ErrInvalidPackage)
+			}
+			return readControlTar(name, member)
+		}
+
+		//	Skip whatever is left of this member (plus padding to an even boundary)
+		skip := member.N + size%2
+		if _, err := io.CopyN(io.Discard, br, skip); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("%w: truncated ar archive", ErrInvalidPackage)
+		}
+	}
+
+	if !sawBinary {
+		return nil, fmt.Errorf("%w: missing debian-binary member", ErrInvalidPackage)
+	}
+
+	return nil, fmt.Errorf("%w: missing control archive", ErrInvalidPackage)
+}
+
+// readArHeader reads the next ar member header and returns the member name and size
+func readArHeader(r io.Reader) (string, int64, error) {
+	header := make([]byte, arHeaderSize)
+	n, err := io.ReadFull(r, header)
+	if n == 0 && (err == io.EOF || err == io.ErrUnexpectedEOF) {
+		return "", 0, io.EOF
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("%w: truncated ar header", ErrInvalidPackage)
+	}
+
+	if string(header[58:60]) != "`\n" {
+		return "", 0, fmt.Errorf("%w: malformed ar header", ErrInvalidPackage)
+	}
+
+	name := strings.TrimRight(strings.TrimSpace(string(header[0:16])), "/")
+	size, err := strconv.ParseInt(strings.TrimSpace(string(header[48:58])), 10, 64)
+	if err != nil || size < 0 {
+		return "", 0, fmt.Errorf("%w: malformed ar member size for '%s'", ErrInvalidPackage, name)
+	}
+
+	return name, size, nil
+}
+
+// readControlTar decompresses the control archive and parses its 'control' file
+func readControlTar(name string, r io.Reader) (Control, error) {
+	var tr io.Reader
+
+	switch path.Ext(name) {
+	case ".tar":
+		tr = r
+	case ".gz":
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("%w: problem decompressing %s: %v", ErrInvalidPackage, name, err)
+		}
+		defer gz.Close()
+		tr = gz
+	case ".xz":
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("%w: problem decompressing %s: %v", ErrInvalidPackage, name, err)
+		}
+		tr = xr
+	case ".zst":
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("%w: problem decompressing %s: %v", ErrInvalidPackage, name, err)
+		}
+		defer zr.Close()
+		tr = zr
+	default:
+		return nil, fmt.Errorf("%w: unsupported control archive '%s'", ErrInvalidPackage, name)
+	}
+
+	archive := tar.NewReader(io.LimitReader(tr, maxControlSize))
+	for {
+		hdr, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: problem reading %s: %v", ErrInvalidPackage, name, err)
+		}
+
+		if path.Clean(strings.TrimPrefix(hdr.Name, "./")) != "control" || hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		data, err := io.ReadAll(archive)
+		if err != nil {
+			return nil, fmt.Errorf("%w: problem reading control file: %v", ErrInvalidPackage, err)
+		}
+
+		control, err := ParseControl(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPackage, err)
+		}
+
+		return control, nil
+	}
+
+	return nil, fmt.Errorf("%w: no control file in %s", ErrInvalidPackage, name)
+}
@@ -0,0 +1,115 @@
+package debian
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The .deb files in testdata are built with ar, tar and the matching compressor, and have a
+// control archive compressed with gzip (foo), xz (bar), zstd (baz), nothing (qux) or bzip2
+
+func TestReadPackageFile(t *testing.T) {
+	tests := []struct {
+		file             string
+		wantPackage      string
+		wantVersion      string
+		wantArchitecture string
+		wantErr          string
+	}{
+		{file: "foo_1.0_amd64.deb", wantPackage: "foo", wantVersion: "1.0", wantArchitecture: "amd64"},
+		{file: "bar_1.2-1_all.deb", wantPackage: "bar", wantVersion: "2:1.2-1", wantArchitecture: "all"},
+		{file: "baz_1.0_arm64.deb", wantPackage: "baz", wantVersion: "1.0", wantArchitecture: "arm64"},
+		{file: "qux_0.1_i386.deb", wantPackage: "qux", wantVersion: "0.1", wantArchitecture: "i386"},
+		{file: "missing-maintainer.deb", wantErr: "missing required control field 'Maintainer'"},
+		{file: "renamed-tarball.deb", wantErr: "not an ar archive"},
+		{file: "unsupported-compression.deb", wantErr: "unsupported control archive 'control.tar.bz2'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			got, err := ReadPackageFile(filepath.Join("testdata", tt.file))
+			if tt.wantErr != "" {
+				if !errors.Is(err, ErrInvalidPackage) || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ReadPackageFile() error = %v, want ErrInvalidPackage with '%s'", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadPackageFile() error = %v", err)
+			}
+
+			if got.Package != tt.wantPackage || got.Version != tt.wantVersion || got.Architecture != tt.wantArchitecture {
+				t.Errorf("ReadPackageFile() = %s %s %s, want %s %s %s", got.Package, got.Version, got.Architecture, tt.wantPackage, tt.wantVersion, tt.wantArchitecture)
+			}
+			if got.Maintainer != "Someone <someone@example.com>" {
+				t.Errorf("ReadPackageFile() maintainer = %s", got.Maintainer)
+			}
+			if got.CanonicalFilename() != tt.file {
+				t.Errorf("CanonicalFilename() = %s, want %s", got.CanonicalFilename(), tt.file)
+			}
+		})
+	}
+}
+
+func TestReadControlTruncated(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "foo_1.0_amd64.deb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//	The debian-binary member starts after the magic, and control.tar.gz after that member
+	control := len(arMagic) + arHeaderSize + 4
+	malformed := append([]byte{}, data...)
+	malformed[len(arMagic)+58] = 'x'
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "empty", data: []byte{}},
+		{name: "in the magic", data: data[:4]},
+		{name: "in the first header", data: data[:len(arMagic)+30]},
+		{name: "in debian-binary", data: data[:len(arMagic)+arHeaderSize+2]},
+		{name: "after debian-binary", data: data[:control]},
+		{name: "in the control header", data: data[:control+20]},
+		{name: "in the control archive", data: data[:control+arHeaderSize+40]},
+		{name: "malformed header", data: malformed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ReadControl(bytes.NewReader(tt.data)); !errors.Is(err, ErrInvalidPackage) {
+				t.Errorf("ReadControl() error = %v, want ErrInvalidPackage", err)
+			}
+		})
+	}
+}
+
+func TestCanonicalFilename(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		arch    string
+		want    string
+	}{
+		{name: "foo", version: "1.0-1", arch: "amd64", want: "foo_1.0-1_amd64.deb"},
+		{name: "foo", version: "2:1.0-1", arch: "amd64", want: "foo_1.0-1_amd64.deb"},
+		{name: "foo", version: "10:1.0", arch: "all", want: "foo_1.0_all.deb"},
+		{name: "foo", version: "1:2.0:1-1", arch: "arm64", want: "foo_2.0%3a1-1_arm64.deb"},
+		{name: "foo", version: "1.0:rc1", arch: "amd64", want: "foo_1.0%3arc1_amd64.deb"},
+		{name: "libfoo1", version: "1.0~rc1+git20240305", arch: "armhf", want: "libfoo1_1.0~rc1+git20240305_armhf.deb"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			info := PackageInfo{Package: tt.name, Version: tt.version, Architecture: tt.arch}
+			if got := info.CanonicalFilename(); got != tt.want {
+				t.Errorf("CanonicalFilename() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
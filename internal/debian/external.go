@@ -0,0 +1,145 @@
+package debian
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	externalModeDetach    = "detach"
+	externalModeClearsign = "clearsign"
+
+	// maxSignatureSize caps how much we'll read back from an external signer
+	maxSignatureSize = 16 * 1024 * 1024
+)
+
+// ExternalSigner hands the Release file to a signer that lives outside this process,
+// so the private key never has to be loaded by the web service.
+//
+// With a command, the command is run with the mode ('detach' or 'clearsign') appended
+// to its arguments, gets the Release file on stdin and writes the signature to stdout.
+//
+// With a unix socket, the Release file is POSTed to /detach or /clearsign on an HTTP
+// server listening on the socket, which responds with the signature as the body.
+type ExternalSigner struct {
+	command []string
+	socket  string
+	timeout time.Duration
+	client  *http.Client
+}
+
+// NewExternalSigner creates a signer that uses either the command or the unix socket
+func NewExternalSigner(command, socket string, timeout time.Duration) (*ExternalSigner, error) {
+	command = strings.TrimSpace(command)
+	socket = strings.TrimSpace(socket)
+
+	if (command == "") == (socket == "") {
+		return nil, fmt.Errorf("the external signer needs exactly one of signing.external.command or signing.external.socket")
+	}
+
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+
+	retval := &ExternalSigner{
+		command: strings.Fields(command),
+		socket:  socket,
+		timeout: timeout,
+	}
+
+	if socket != "" {
+		retval.client = &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socket)
+				},
+			},
+		}
+	}
+
+	return retval, nil
+}
+
+// DetachSign creates an armored detached signature for the data (the Release.gpg format)
+func (s *ExternalSigner) DetachSign(ctx context.Context, data []byte) ([]byte, error) {
+	return s.sign(ctx, externalModeDetach, data)
+}
+
+// ClearSign creates a cleartext signed copy of the data (the InRelease format)
+func (s *ExternalSigner) ClearSign(ctx context.Context, data []byte) ([]byte, error) {
+	return s.sign(ctx, externalModeClearsign, data)
+}
+
+// sign asks the external signer for a signature in the given mode
+func (s *ExternalSigner) sign(ctx context.Context, mode string, data []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	var out []byte
+	var err error
+	if s.socket != "" {
+		out, err = s.signWithSocket(ctx, mode, data)
+	} else {
+		out, err = s.signWithCommand(ctx, mode, data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("problem getting %s signature from external signer: %w", mode, err)
+	}
+
+	if !bytes.Contains(out, []byte("-----BEGIN PGP")) {
+		return nil, fmt.Errorf("external signer returned something that isn't an armored %s signature", mode)
+	}
+
+	return out, nil
+}
+
+// signWithCommand runs the signer command with the data on stdin
+func (s *ExternalSigner) signWithCommand(ctx context.Context, mode string, data []byte) ([]byte, error) {
+	args := append(append([]string{}, s.command[1:]...), mode)
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.command[0], args...)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// signWithSocket posts the data to the signer listening on the unix socket
+func (s *ExternalSigner) signWithSocket(ctx context.Context, mode string, data []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://signer/"+mode, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSignatureSize))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("signer responded with %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return body, nil
+}
@@ -1,88 +1,122 @@
 package debian
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
 	"fmt"
 	"github.com/rs/zerolog/log"
+	"os"
 	"os/exec"
 	"strings"
 )
 
-func InitGPGKey(ctx context.Context, gpgKey, gpgPassword string) error {
+// GPGSigner signs Release files by running the gpg binary against the
+// default keyring.  The passphrase is handed to gpg over a pipe, so it
+// never shows up on a command line.
+type GPGSigner struct {
+	passphrase string
+	keyID      string
+}
+
+// NewGPGSigner makes sure the key is in the gpg keyring (importing it if the keyring
+// has no secret keys) and returns a signer that signs with the key for keyID (usually an email)
+func NewGPGSigner(ctx context.Context, gpgKey, gpgPassword, keyID string) (*GPGSigner, error) {
 	log.Info().Msg("Initializing GPG key...")
+
 	//	Does the gpg key exist? This should return stuff
-	gpgCommand := exec.CommandContext(ctx, "gpg", "-K")
-	gpgResponse, err := gpgCommand.Output()
+	gpgResponse, err := exec.CommandContext(ctx, "gpg", "-K").Output()
 	if err != nil {
-		return fmt.Errorf("problem running gpg command: %w", err)
+		return nil, fmt.Errorf("problem running gpg command: %w", err)
 	}
 
 	if len(strings.TrimSpace(string(gpgResponse))) == 0 {
 		log.Info().Msg("GPG key does not seem to exist - adding it...")
-		//	If not, Import the GPG key from environment variables
-		//	Note: PACKASSIST_GITHUB_GPGKEY is base64 encoded and in a single line
-		cmd := fmt.Sprintf("echo -n \"%s\" | base64 --decode | gpg --batch --no-tty --passphrase %s --import", gpgKey, gpgPassword)
-		_, err := exec.Command("bash", "-c", cmd).Output()
-		if err != nil {
-			log.Err(err).Msg("problem running gpg command")
+
+		//	Note: PACKASSIST_GPG_KEY is base64 encoded and in a single line
+		key := []byte(strings.TrimSpace(gpgKey))
+		if !bytes.Contains(key, []byte("-----BEGIN PGP")) {
+			key, err = base64.StdEncoding.DecodeString(strings.Join(strings.Fields(gpgKey), ""))
+			if err != nil {
+				return nil, fmt.Errorf("problem decoding gpg key: %w", err)
+			}
 		}
 
-		gpgResponse, _ := gpgCommand.Output()
+		importCmd := exec.CommandContext(ctx, "gpg", "--batch", "--no-tty", "--import")
+		importCmd.Stdin = bytes.NewReader(key)
+		if out, err := importCmd.CombinedOutput(); err != nil {
+			return nil, fmt.Errorf("problem importing gpg key: %w: %s", err, strings.TrimSpace(string(out)))
+		}
+
+		gpgResponse, _ = exec.CommandContext(ctx, "gpg", "-K").Output()
 		log.Info().Str("response", string(gpgResponse)).Msg("GPG key list")
 	}
 
-	return nil
+	return &GPGSigner{
+		passphrase: gpgPassword,
+		keyID:      keyID,
+	}, nil
 }
 
-// RefreshPackages refreshes the debian package information in the repo and uses
-// the email and gpg password to sign with the gpg key.
-func RefreshPackages(ctx context.Context, gpgPassword, gpgEmail, repoFolder string) error {
+// DetachSign creates an armored detached signature for the data (the Release.gpg format)
+func (s *GPGSigner) DetachSign(ctx context.Context, data []byte) ([]byte, error) {
+	// gpg --pinentry-mode loopback --batch --no-tty --default-key "${EMAIL}" -abs -o - Release > Release.gpg
+	out, err := s.run(ctx, data, "--armor", "--detach-sign")
+	if err != nil {
+		return nil, fmt.Errorf("problem running gpg -abs command: %w", err)
+	}
 
-	log.Info().Str("folder", repoFolder).Str("gpgEmail", gpgEmail).Msg("Refreshing packages...")
+	return out, nil
+}
 
-	// dpkg-scanpackages --multiversion . > Packages
-	cmd := fmt.Sprintf("dpkg-scanpackages --multiversion . > Packages")
-	scanPkgCmd := exec.CommandContext(ctx, "bash", "-c", cmd)
-	scanPkgCmd.Dir = repoFolder
-	_, err := scanPkgCmd.Output()
+// ClearSign creates a cleartext signed copy of the data (the InRelease format)
+func (s *GPGSigner) ClearSign(ctx context.Context, data []byte) ([]byte, error) {
+	// gpg --pinentry-mode loopback --batch --no-tty --default-key "${EMAIL}" --clearsign -o - Release > InRelease
+	out, err := s.run(ctx, data, "--clearsign")
 	if err != nil {
-		log.Err(err).Msg("problem running dpkg-scanpackages command")
+		return nil, fmt.Errorf("problem running gpg --clearsign command: %w", err)
 	}
 
-	// gzip -k -f Packages
-	gzipCommand := exec.CommandContext(ctx, "gzip", "-k", "-f", "Packages")
-	gzipCommand.Dir = repoFolder
-	_, err = gzipCommand.Output()
+	return out, nil
+}
+
+// run signs the data with gpg using the given signing mode arguments
+func (s *GPGSigner) run(ctx context.Context, data []byte, mode ...string) ([]byte, error) {
+	//	Hand the passphrase over on file descriptor 3
+	passReader, passWriter, err := os.Pipe()
 	if err != nil {
-		return fmt.Errorf("problem running gzip command: %w", err)
+		return nil, fmt.Errorf("problem creating passphrase pipe: %w", err)
 	}
+	defer passReader.Close()
 
-	// apt-ftparchive release . > Release
-	cmd = fmt.Sprintf("apt-ftparchive release . > Release")
-	aptCmd := exec.CommandContext(ctx, "bash", "-c", cmd)
-	aptCmd.Dir = repoFolder
-	_, err = aptCmd.Output()
-	if err != nil {
-		log.Err(err).Msg("problem running apt-ftparchive command")
+	args := []string{"--pinentry-mode", "loopback", "--passphrase-fd", "3", "--batch", "--no-tty"}
+	if s.keyID != "" {
+		args = append(args, "--default-key", s.keyID)
 	}
+	args = append(args, mode...)
+	args = append(args, "--output", "-")
 
-	// gpg --pinentry-mode loopback --passphrase ${PASSPHRASE} --batch --no-tty --default-key "${EMAIL}" -abs -o - Release > Release.gpg
-	cmd = fmt.Sprintf("gpg --pinentry-mode loopback --passphrase %s --batch --no-tty --default-key \"%s\" -abs -o - Release > Release.gpg", gpgPassword, gpgEmail)
-	gpgCmd := exec.CommandContext(ctx, "bash", "-c", cmd)
-	gpgCmd.Dir = repoFolder
-	_, err = gpgCmd.Output()
-	if err != nil {
-		log.Err(err).Msg("problem running gpg -abs command")
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "gpg", args...)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.ExtraFiles = []*os.File{passReader}
+
+	if err := cmd.Start(); err != nil {
+		passWriter.Close()
+		return nil, err
 	}
 
-	// gpg --pinentry-mode loopback --passphrase ${PASSPHRASE} --batch --no-tty --default-key "${EMAIL}" --clearsign -o - Release > InRelease
-	cmd = fmt.Sprintf("gpg --pinentry-mode loopback --passphrase %s --batch --no-tty --default-key \"%s\" --clearsign -o - Release > InRelease", gpgPassword, gpgEmail)
-	gpg2Cmd := exec.CommandContext(ctx, "bash", "-c", cmd)
-	gpg2Cmd.Dir = repoFolder
-	_, err = gpg2Cmd.Output()
-	if err != nil {
-		log.Err(err).Msg("problem running gpg --clearsign command")
+	_, writeErr := passWriter.Write([]byte(s.passphrase + "\n"))
+	passWriter.Close()
+
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if writeErr != nil {
+		return nil, fmt.Errorf("problem sending passphrase: %w", writeErr)
 	}
 
-	return nil
+	return stdout.Bytes(), nil
 }
@@ -0,0 +1,166 @@
+package debian
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"encoding/base64"
+	"fmt"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/clearsign"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/rs/zerolog/log"
+	"strings"
+	"time"
+)
+
+// NativeSigner signs Release files with an OpenPGP key held in memory, without
+// needing the gpg binary or a keyring on disk
+type NativeSigner struct {
+	entity *openpgp.Entity
+	config *packet.Config
+}
+
+// NewNativeSigner loads the private key (armored, or base64 encoded armored / binary)
+// and decrypts it with the passphrase.  If the key data contains more than one key,
+// the first one with an identity matching 'identity' (usually an email) is used.
+func NewNativeSigner(key, passphrase, identity string) (*NativeSigner, error) {
+	log.Info().Msg("Initializing OpenPGP signing key...")
+
+	keyring, err := readKeyRing(key)
+	if err != nil {
+		return nil, err
+	}
+
+	entity := selectSigningEntity(keyring, identity)
+	if entity == nil {
+		return nil, fmt.Errorf("no private key found in the signing key data")
+	}
+
+	if err := entity.DecryptPrivateKeys([]byte(passphrase)); err != nil {
+		return nil, fmt.Errorf("problem decrypting signing key: %w", err)
+	}
+
+	retval := &NativeSigner{
+		entity: entity,
+		config: &packet.Config{
+			DefaultHash: crypto.SHA256,
+		},
+	}
+
+	signingKey, ok := entity.SigningKey(retval.config.Now())
+	if !ok || signingKey.PrivateKey == nil {
+		return nil, fmt.Errorf("signing key data has no valid signing key")
+	}
+
+	log.Info().
+		Str("keyid", signingKey.PublicKey.KeyIdString()).
+		Str("fingerprint", fmt.Sprintf("%X", entity.PrimaryKey.Fingerprint)).
+		Msg("Loaded OpenPGP signing key")
+
+	return retval, nil
+}
+
+// readKeyRing reads OpenPGP key data that is either armored, or base64 encoded
+// armored or binary data (the format used by the gpg.key setting)
+func readKeyRing(key string) (openpgp.EntityList, error) {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return nil, fmt.Errorf("no signing key configured")
+	}
+
+	data := []byte(key)
+	if !bytes.Contains(data, []byte("-----BEGIN PGP")) {
+		decoded, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(key), ""))
+		if err != nil {
+			return nil, fmt.Errorf("signing key is neither armored nor base64 encoded: %w", err)
+		}
+		data = decoded
+	}
+
+	var keyring openpgp.EntityList
+	var err error
+	if bytes.Contains(data, []byte("-----BEGIN PGP")) {
+		keyring, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	} else {
+		keyring, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("problem reading signing key: %w", err)
+	}
+
+	return keyring, nil
+}
+
+// selectSigningEntity returns the first entity with a private key matching the identity,
+// or the first entity with a private key if nothing matches
+func selectSigningEntity(keyring openpgp.EntityList, identity string) *openpgp.Entity {
+	var fallback *openpgp.Entity
+
+	for _, entity := range keyring {
+		if entity.PrivateKey == nil {
+			continue
+		}
+
+		if fallback == nil {
+			fallback = entity
+		}
+
+		if identity == "" {
+			return entity
+		}
+
+		for name := range entity.Identities {
+			if strings.Contains(strings.ToLower(name), strings.ToLower(identity)) {
+				return entity
+			}
+		}
+	}
+
+	return fallback
+}
+
+// DetachSign creates an armored detached signature for the data (the Release.gpg format)
+func (s *NativeSigner) DetachSign(ctx context.Context, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&buf, s.entity, bytes.NewReader(data), s.config); err != nil {
+		return nil, fmt.Errorf("problem creating detached signature: %w", err)
+	}
+
+	//	Armor output doesn't end with a newline
+	buf.WriteString("\n")
+
+	return buf.Bytes(), nil
+}
+
+// ClearSign creates a cleartext signed copy of the data (the InRelease format)
+func (s *NativeSigner) ClearSign(ctx context.Context, data []byte) ([]byte, error) {
+	signingKey, ok := s.entity.SigningKey(s.config.Now())
+	if !ok || signingKey.PrivateKey == nil {
+		return nil, fmt.Errorf("no valid signing key")
+	}
+
+	var buf bytes.Buffer
+	plaintext, err := clearsign.Encode(&buf, signingKey.PrivateKey, s.config)
+	if err != nil {
+		return nil, fmt.Errorf("problem creating clearsigned document: %w", err)
+	}
+	if _, err := plaintext.Write(data); err != nil {
+		return nil, fmt.Errorf("problem creating clearsigned document: %w", err)
+	}
+	if err := plaintext.Close(); err != nil {
+		return nil, fmt.Errorf("problem creating clearsigned document: %w", err)
+	}
+
+	buf.WriteString("\n")
+
+	return buf.Bytes(), nil
+}
+
+// SetTime fixes the signature creation time and leaves out the random salt notation, so
+// signing the same data with an EdDSA or RSA key always gives the same signature (for tests)
+func (s *NativeSigner) SetTime(now func() time.Time) {
+	salted := false
+	s.config.Time = now
+	s.config.NonDeterministicSignaturesViaNotation = &salted
+}
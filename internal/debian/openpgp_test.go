@@ -0,0 +1,183 @@
+package debian
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/clearsign"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSigningTime is when the test signatures are made
+var testSigningTime = time.Date(2024, time.March, 5, 12, 0, 0, 0, time.UTC)
+
+// testSigningKey creates a passphrase protected key, returning it (armored) along with its public keyring
+func testSigningKey(t *testing.T, passphrase string) (string, openpgp.EntityList) {
+	t.Helper()
+
+	config := &packet.Config{
+		Algorithm: packet.PubKeyAlgoEdDSA,
+		Time:      func() time.Time { return testSigningTime.Add(-time.Hour) },
+	}
+	entity, err := openpgp.NewEntity("Repo Signer", "", "repo@example.com", config)
+	if err != nil {
+		t.Fatalf("problem creating key: %v", err)
+	}
+
+	var public bytes.Buffer
+	if err := entity.Serialize(&public); err != nil {
+		t.Fatalf("problem serializing public key: %v", err)
+	}
+	keyring, err := openpgp.ReadKeyRing(&public)
+	if err != nil {
+		t.Fatalf("problem reading public key: %v", err)
+	}
+
+	if err := entity.EncryptPrivateKeys([]byte(passphrase), config); err != nil {
+		t.Fatalf("problem encrypting key: %v", err)
+	}
+
+	var private bytes.Buffer
+	w, err := armor.Encode(&private, openpgp.PrivateKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.SerializePrivateWithoutSigning(w, config); err != nil {
+		t.Fatalf("problem serializing private key: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return private.String(), keyring
+}
+
+func TestNewNativeSigner(t *testing.T) {
+	key, _ := testSigningKey(t, "secret")
+
+	tests := []struct {
+		name       string
+		key        string
+		passphrase string
+		wantErr    bool
+	}{
+		{name: "armored", key: key, passphrase: "secret"},
+		{name: "base64 encoded", key: base64.StdEncoding.EncodeToString([]byte(key)), passphrase: "secret"},
+		{name: "wrong passphrase", key: key, passphrase: "wrong", wantErr: true},
+		{name: "no key", key: "", passphrase: "secret", wantErr: true},
+		{name: "not a key", key: "not a key!", passphrase: "secret", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewNativeSigner(tt.key, tt.passphrase, "repo@example.com")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewNativeSigner() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNativeSigner(t *testing.T) {
+	key, keyring := testSigningKey(t, "secret")
+	signer, err := NewNativeSigner(key, "secret", "repo@example.com")
+	if err != nil {
+		t.Fatalf("NewNativeSigner() error = %v", err)
+	}
+	signer.SetTime(func() time.Time { return testSigningTime })
+	verifyConfig := &packet.Config{Time: func() time.Time { return testSigningTime }}
+
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "release file", data: "Origin: Example\nSuite: stable\nSHA256:\n e3b0c442               0 main/binary-all/Packages\n"},
+		{name: "dash escaped lines", data: "- starts with a dash\n-----BEGIN PGP SIGNATURE-----\n"},
+		{name: "no trailing newline", data: "Suite: stable"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			//	Release.gpg
+			detached, err := signer.DetachSign(ctx, []byte(tt.data))
+			if err != nil {
+				t.Fatalf("DetachSign() error = %v", err)
+			}
+			block, err := armor.Decode(bytes.NewReader(detached))
+			if err != nil {
+				t.Fatalf("detached signature isn't armored: %v", err)
+			}
+			sig, _, err := openpgp.VerifyDetachedSignature(keyring, strings.NewReader(tt.data), block.Body, verifyConfig)
+			if err != nil {
+				t.Fatalf("detached signature doesn't verify: %v", err)
+			}
+			if !sig.CreationTime.Equal(testSigningTime) {
+				t.Errorf("detached signature was made at %v, want %v", sig.CreationTime, testSigningTime)
+			}
+
+			//	InRelease
+			clearsigned, err := signer.ClearSign(ctx, []byte(tt.data))
+			if err != nil {
+				t.Fatalf("ClearSign() error = %v", err)
+			}
+			decoded, _ := clearsign.Decode(clearsigned)
+			if decoded == nil {
+				t.Fatalf("ClearSign() output isn't a clearsigned document:\n%s", clearsigned)
+			}
+			if string(decoded.Plaintext) != tt.data {
+				t.Errorf("clearsigned text is %q, want %q", decoded.Plaintext, tt.data)
+			}
+			sig, _, err = openpgp.VerifyDetachedSignature(keyring, bytes.NewReader(decoded.Bytes), decoded.ArmoredSignature.Body, verifyConfig)
+			if err != nil {
+				t.Fatalf("clearsigned signature doesn't verify: %v", err)
+			}
+			if !sig.CreationTime.Equal(testSigningTime) {
+				t.Errorf("clearsigned signature was made at %v, want %v", sig.CreationTime, testSigningTime)
+			}
+		})
+	}
+}
+
+func TestNativeSignerGolden(t *testing.T) {
+	key, err := os.ReadFile(filepath.Join("testdata", "test-signing-key.asc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	release, err := os.ReadFile(filepath.Join("testdata", "Release.options.golden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := NewNativeSigner(string(key), "secret", "repo@example.com")
+	if err != nil {
+		t.Fatalf("NewNativeSigner() error = %v", err)
+	}
+	signer.SetTime(func() time.Time { return testSigningTime })
+
+	//	Signing again gives the same signatures
+	for i := 0; i < 2; i++ {
+		folder := t.TempDir()
+		writeTestFiles(t, folder, map[string]string{"Release": string(release)})
+
+		if err := SignRelease(context.Background(), folder, signer); err != nil {
+			t.Fatalf("SignRelease() error = %v", err)
+		}
+
+		for _, name := range []string{"Release.gpg", "InRelease"} {
+			signed, err := os.ReadFile(filepath.Join(folder, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkGolden(t, name+".golden", signed)
+		}
+	}
+}
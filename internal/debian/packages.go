@@ -0,0 +1,402 @@
+package debian
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// packagesFieldOrder is the order dpkg-scanpackages emits fields in a Packages index.
+// Any fields not in this list are written afterwards in the order they appear
+// in the package control file.
+var packagesFieldOrder = []string{
+	"Package", "Package-Type", "Source", "Version", "Kernel-Version", "Built-For-Profiles",
+	"Auto-Built-Package", "Architecture", "Subarchitecture", "Installer-Menu-Item",
+	"Build-Essential", "Essential", "Protected", "Origin", "Bugs", "Maintainer", "Installed-Size",
+	"Pre-Depends", "Depends", "Recommends", "Suggests", "Enhances", "Conflicts", "Breaks",
+	"Replaces", "Provides", "Built-Using", "Static-Built-Using",
+	"Filename", "Size", "MD5sum", "SHA1", "SHA256", "SHA512",
+	"Section", "Priority", "Multi-Arch", "Homepage", "Description", "Tag", "Task",
+}
+
+// PackageFile is a .deb package in the repo, along with the file details
+// needed to describe it in a Packages index
+type PackageFile struct {
+	PackageInfo
+	Filename string    `json:"filename"`
+	Size     int64     `json:"size"`
+	MD5sum   string    `json:"md5sum"`
+	SHA1     string    `json:"sha1"`
+	SHA256   string    `json:"sha256"`
+	SHA512   string    `json:"sha512"`
+	ModTime  time.Time `json:"-"`
+}
+
+// Stanza returns the Packages index stanza for the package file
+func (p PackageFile) Stanza() Control {
+	fields := Control{}
+	for _, field := range p.Control {
+		//	These are computed, so never trust what's in the package
+		switch strings.ToLower(field.Name) {
+		case "filename", "size", "md5sum", "sha1", "sha256", "sha512":
+			continue
+		}
+		fields = append(fields, field)
+	}
+
+	fields = append(fields,
+		ControlField{Name: "Filename", Value: p.Filename},
+		ControlField{Name: "Size", Value: fmt.Sprintf("%d", p.Size)},
+		ControlField{Name: "MD5sum", Value: p.MD5sum},
+		ControlField{Name: "SHA1", Value: p.SHA1},
+		ControlField{Name: "SHA256", Value: p.SHA256},
+		ControlField{Name: "SHA512", Value: p.SHA512},
+	)
+
+	return fields.Ordered(packagesFieldOrder)
+}
+
+// NewPackageFileFromStanza creates a package file from a Packages index stanza
+func NewPackageFileFromStanza(stanza Control) (PackageFile, error) {
+	control := Control{}
+	for _, field := range stanza {
+		switch strings.ToLower(field.Name) {
+		case "filename", "size", "md5sum", "sha1", "sha256", "sha512":
+			continue
+		}
+		control = append(control, field)
+	}
+
+	retval := PackageFile{
+		PackageInfo: NewPackageInfo(control),
+		Filename:    stanza.Get("Filename"),
+		MD5sum:      stanza.Get("MD5sum"),
+		SHA1:        stanza.Get("SHA1"),
+		SHA256:      stanza.Get("SHA256"),
+		SHA512:      stanza.Get("SHA512"),
+	}
+
+	if retval.Filename == "" {
+		return retval, fmt.Errorf("index entry for %s %s has no Filename", retval.Package, retval.Version)
+	}
+
+	size, err := strconv.ParseInt(stanza.Get("Size"), 10, 64)
+	if err != nil {
+		return retval, fmt.Errorf("index entry for %s has an invalid Size: %w", retval.Filename, err)
+	}
+	retval.Size = size
+
+	return retval, nil
+}
+
+// ReadPackagesIndex reads all of the entries in a Packages index file.  A missing
+// index file isn't an error -- it just has no entries.
+func ReadPackagesIndex(filename string) ([]PackageFile, error) {
+	retval := []PackageFile{}
+
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return retval, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("problem opening index: %w", err)
+	}
+	defer f.Close()
+
+	stanzas, err := ParseControlStanzas(f)
+	if err != nil {
+		return nil, fmt.Errorf("problem reading index %s: %w", filename, err)
+	}
+
+	for _, stanza := range stanzas {
+		pkg, err := NewPackageFileFromStanza(stanza)
+		if err != nil {
+			return nil, fmt.Errorf("problem reading index %s: %w", filename, err)
+		}
+		retval = append(retval, pkg)
+	}
+
+	return retval, nil
+}
+
+// Ordered returns a copy of the stanza with fields sorted according to the given
+// order.  Fields that aren't in the order list keep their relative order and go last.
+func (c Control) Ordered(order []string) Control {
+	rank := make(map[string]int, len(order))
+	for i, name := range order {
+		rank[strings.ToLower(name)] = i
+	}
+
+	retval := make(Control, len(c))
+	copy(retval, c)
+
+	sort.SliceStable(retval, func(i, j int) bool {
+		ri, iok := rank[strings.ToLower(retval[i].Name)]
+		rj, jok := rank[strings.ToLower(retval[j].Name)]
+		switch {
+		case iok && jok:
+			return ri < rj
+		case iok:
+			return true
+		default:
+			return false
+		}
+	})
+
+	return retval
+}
+
+// WriteTo writes the stanza in control file format (without a trailing blank line)
+func (c Control) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	for _, field := range c {
+		if strings.HasPrefix(field.Value, "\n") {
+			fmt.Fprintf(&buf, "%s:%s\n", field.Name, field.Value)
+		} else {
+			fmt.Fprintf(&buf, "%s: %s\n", field.Name, field.Value)
+		}
+	}
+
+	n, err := w.Write(buf.Bytes())
+	return int64(n), err
+}
+
+// ScanPackageFile reads the control metadata and computes the size and checksums of
+// the .deb file at debFile.  The filename in the result is relative to the repoFolder.
+func ScanPackageFile(repoFolder, debFile string) (PackageFile, error) {
+	retval := PackageFile{}
+
+	rel, err := filepath.Rel(repoFolder, debFile)
+	if err != nil {
+		return retval, fmt.Errorf("problem finding relative path for %s: %w", debFile, err)
+	}
+
+	f, err := os.Open(debFile)
+	if err != nil {
+		return retval, fmt.Errorf("problem opening package: %w", err)
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return retval, fmt.Errorf("problem getting package info: %w", err)
+	}
+
+	//	Hash the file in the same pass we use to read the control data
+	md5Hash, sha1Hash, sha256Hash, sha512Hash := md5.New(), sha1.New(), sha256.New(), sha512.New()
+	hashes := io.MultiWriter(md5Hash, sha1Hash, sha256Hash, sha512Hash)
+	tee := io.TeeReader(f, hashes)
+
+	info, err := ReadPackage(tee)
+	if err != nil {
+		return retval, fmt.Errorf("problem reading %s: %w", rel, err)
+	}
+
+	//	Hash whatever the control reader didn't need
+	if _, err := io.Copy(hashes, f); err != nil {
+		return retval, fmt.Errorf("problem hashing %s: %w", rel, err)
+	}
+
+	retval.PackageInfo = info
+	retval.Filename = "./" + filepath.ToSlash(rel)
+	retval.Size = stat.Size()
+	retval.ModTime = stat.ModTime()
+	retval.MD5sum = hex.EncodeToString(md5Hash.Sum(nil))
+	retval.SHA1 = hex.EncodeToString(sha1Hash.Sum(nil))
+	retval.SHA256 = hex.EncodeToString(sha256Hash.Sum(nil))
+	retval.SHA512 = hex.EncodeToString(sha512Hash.Sum(nil))
+
+	return retval, nil
+}
+
+// scanCache keeps the results of previous package scans so that unchanged
+// files don't have to be read and hashed again on every refresh
+var scanCache = struct {
+	sync.Mutex
+	entries map[string]PackageFile
+}{entries: make(map[string]PackageFile)}
+
+// cachedScanPackageFile scans the package file, reusing a previous scan result if the
+// file's size and modification time haven't changed
+func cachedScanPackageFile(repoFolder, debFile string, stat fs.FileInfo) (PackageFile, error) {
+	scanCache.Lock()
+	cached, ok := scanCache.entries[debFile]
+	scanCache.Unlock()
+
+	if ok && cached.Size == stat.Size() && cached.ModTime.Equal(stat.ModTime()) {
+		return cached, nil
+	}
+
+	retval, err := ScanPackageFile(repoFolder, debFile)
+	if err != nil {
+		return retval, err
+	}
+
+	scanCache.Lock()
+	scanCache.entries[debFile] = retval
+	scanCache.Unlock()
+
+	return retval, nil
+}
+
+// ScanPackages finds all .deb files under the repo folder (skipping hidden
+// directories like .git) and returns them in a deterministic order.  It returns
+// an error if any of them can't be read.
+func ScanPackages(ctx context.Context, repoFolder string) ([]PackageFile, error) {
+	retval := []PackageFile{}
+
+	err := filepath.WalkDir(repoFolder, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if d.IsDir() {
+			if p != repoFolder && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !d.Type().IsRegular() || !strings.HasSuffix(d.Name(), ".deb") {
+			return nil
+		}
+
+		stat, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("problem getting file info for %s: %w", p, err)
+		}
+
+		//	A package we can't read would silently drop out of the index, so stop instead
+		pkg, err := cachedScanPackageFile(repoFolder, p, stat)
+		if err != nil {
+			return err
+		}
+
+		retval = append(retval, pkg)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("problem scanning packages: %w", err)
+	}
+
+	SortPackageFiles(retval)
+
+	return retval, nil
+}
+
+// SortPackageFiles sorts package files by package name, version (oldest first), architecture and filename
+func SortPackageFiles(pkgs []PackageFile) {
+	sort.SliceStable(pkgs, func(i, j int) bool {
+		a, b := pkgs[i], pkgs[j]
+		if a.Package != b.Package {
+			return a.Package < b.Package
+		}
+		if c := CompareVersions(a.Version, b.Version); c != 0 {
+			return c < 0
+		}
+		if a.Architecture != b.Architecture {
+			return a.Architecture < b.Architecture
+		}
+		return a.Filename < b.Filename
+	})
+}
+
+// WritePackagesIndex writes a Packages index for the given package files
+func WritePackagesIndex(w io.Writer, pkgs []PackageFile) error {
+	bw := bufio.NewWriter(w)
+
+	for i, pkg := range pkgs {
+		if i > 0 {
+			if _, err := bw.WriteString("\n"); err != nil {
+				return err
+			}
+		}
+		if _, err := pkg.Stanza().WriteTo(bw); err != nil {
+			return err
+		}
+	}
+
+	//	dpkg-scanpackages ends the file with a blank line
+	if len(pkgs) > 0 {
+		if _, err := bw.WriteString("\n"); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// WritePackagesFiles writes the Packages and Packages.gz index files for the
+// given package files into the folder
+func WritePackagesFiles(folder string, pkgs []PackageFile) error {
+	var index bytes.Buffer
+	if err := WritePackagesIndex(&index, pkgs); err != nil {
+		return fmt.Errorf("problem building Packages index: %w", err)
+	}
+
+	if err := writeFileAtomic(filepath.Join(folder, "Packages"), index.Bytes()); err != nil {
+		return fmt.Errorf("problem writing Packages: %w", err)
+	}
+
+	//	Leave the gzip header name and timestamp empty so the output is deterministic
+	var compressed bytes.Buffer
+	gz, err := gzip.NewWriterLevel(&compressed, gzip.BestCompression)
+	if err != nil {
+		return fmt.Errorf("problem creating gzip writer: %w", err)
+	}
+	if _, err := gz.Write(index.Bytes()); err != nil {
+		return fmt.Errorf("problem compressing Packages: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("problem compressing Packages: %w", err)
+	}
+
+	if err := writeFileAtomic(filepath.Join(folder, "Packages.gz"), compressed.Bytes()); err != nil {
+		return fmt.Errorf("problem writing Packages.gz: %w", err)
+	}
+
+	return nil
+}
+
+// writeFileAtomic writes the data to a temp file next to the target and renames
+// it into place, so readers never see a half-written file
+func writeFileAtomic(filename string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filename)
+}
@@ -0,0 +1,160 @@
+package debian
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// checkGolden compares the output with the golden file in testdata (or updates it, with -update)
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+
+	golden := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(golden, got, 0644); err != nil {
+			t.Fatalf("problem updating %s: %v", golden, err)
+		}
+	}
+
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("problem reading %s: %v", golden, err)
+	}
+
+	if !bytes.Equal(got, want) {
+		t.Errorf("output doesn't match %s\ngot:\n%s\nwant:\n%s", golden, got, want)
+	}
+}
+
+// testPackageFile builds a package file from control file text, with checksums made up from the filename
+func testPackageFile(t *testing.T, control, filename string, size int64) PackageFile {
+	t.Helper()
+
+	parsed, err := ParseControl(strings.NewReader(control))
+	if err != nil {
+		t.Fatalf("problem parsing control: %v", err)
+	}
+
+	return PackageFile{
+		PackageInfo: NewPackageInfo(parsed),
+		Filename:    filename,
+		Size:        size,
+		MD5sum:      "md5-" + filepath.Base(filename),
+		SHA1:        "sha1-" + filepath.Base(filename),
+		SHA256:      "sha256-" + filepath.Base(filename),
+		SHA512:      "sha512-" + filepath.Base(filename),
+	}
+}
+
+func TestWritePackagesIndex(t *testing.T) {
+	foo := `Description: a test package
+ It does nothing at all.
+ .
+ Really.
+Maintainer: Someone <someone@example.com>
+Architecture: amd64
+Version: 1.0-1
+Package: foo
+Depends: libc6 (>= 2.31)
+Section: utils
+Installed-Size: 12
+Priority: optional
+`
+	tampered := `Package: bar
+Version: 2:0.5
+Architecture: all
+Maintainer: Someone <someone@example.com>
+Filename: ../../etc/passwd
+Size: 1
+SHA256: bogus
+X-Custom: kept last
+Description: a package that lies about its file
+`
+
+	tests := []struct {
+		name   string
+		pkgs   []PackageFile
+		golden string
+	}{
+		{
+			name:   "no packages",
+			pkgs:   []PackageFile{},
+			golden: "Packages.empty.golden",
+		},
+		{
+			name:   "dpkg-scanpackages field order",
+			pkgs:   []PackageFile{testPackageFile(t, foo, "./foo_1.0-1_amd64.deb", 1234)},
+			golden: "Packages.single.golden",
+		},
+		{
+			name: "computed fields replace the package's own",
+			pkgs: []PackageFile{
+				testPackageFile(t, tampered, "./bar_0.5_all.deb", 99),
+				testPackageFile(t, foo, "./foo_1.0-1_amd64.deb", 1234),
+			},
+			golden: "Packages.multiple.golden",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WritePackagesIndex(&buf, tt.pkgs); err != nil {
+				t.Fatalf("WritePackagesIndex() error = %v", err)
+			}
+			checkGolden(t, tt.golden, buf.Bytes())
+
+			//	The index reads back as the same packages
+			index := filepath.Join(t.TempDir(), "Packages")
+			if err := os.WriteFile(index, buf.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+			read, err := ReadPackagesIndex(index)
+			if err != nil {
+				t.Fatalf("ReadPackagesIndex() error = %v", err)
+			}
+			if len(read) != len(tt.pkgs) {
+				t.Fatalf("ReadPackagesIndex() read %d packages, want %d", len(read), len(tt.pkgs))
+			}
+			for i, pkg := range read {
+				want := tt.pkgs[i]
+				if pkg.Package != want.Package || pkg.Version != want.Version || pkg.Filename != want.Filename || pkg.Size != want.Size || pkg.SHA256 != want.SHA256 {
+					t.Errorf("package %d read back as %s %s (%s, %d, %s), want %s %s (%s, %d, %s)", i,
+						pkg.Package, pkg.Version, pkg.Filename, pkg.Size, pkg.SHA256,
+						want.Package, want.Version, want.Filename, want.Size, want.SHA256)
+				}
+			}
+		})
+	}
+}
+
+func TestSortPackageFiles(t *testing.T) {
+	pkgs := []PackageFile{
+		{PackageInfo: PackageInfo{Package: "foo", Version: "1.10", Architecture: "amd64"}, Filename: "./foo_1.10_amd64.deb"},
+		{PackageInfo: PackageInfo{Package: "bar", Version: "1.0", Architecture: "amd64"}, Filename: "./bar_1.0_amd64.deb"},
+		{PackageInfo: PackageInfo{Package: "foo", Version: "1.9", Architecture: "arm64"}, Filename: "./foo_1.9_arm64.deb"},
+		{PackageInfo: PackageInfo{Package: "foo", Version: "1.9", Architecture: "amd64"}, Filename: "./foo_1.9_amd64.deb"},
+		{PackageInfo: PackageInfo{Package: "foo", Version: "1.10~rc1", Architecture: "amd64"}, Filename: "./foo_1.10~rc1_amd64.deb"},
+	}
+
+	SortPackageFiles(pkgs)
+
+	want := []string{
+		"./bar_1.0_amd64.deb",
+		"./foo_1.9_amd64.deb",
+		"./foo_1.9_arm64.deb",
+		"./foo_1.10~rc1_amd64.deb",
+		"./foo_1.10_amd64.deb",
+	}
+	for i, pkg := range pkgs {
+		if pkg.Filename != want[i] {
+			t.Errorf("package %d is %s, want %s", i, pkg.Filename, want[i])
+		}
+	}
+}
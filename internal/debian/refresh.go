@@ -0,0 +1,21 @@
+package debian
+
+import (
+	"context"
+	"github.com/rs/zerolog/log"
+)
+
+// RefreshPackages refreshes the debian package information in the archive and signs
+// the Release file(s) with the signer.  Any package files in 'added' are published
+// to the target suite and component (the target is ignored for a flat archive).
+func RefreshPackages(ctx context.Context, signer Signer, archive Archive, target Target, added ...string) error {
+
+	log.Info().
+		Str("folder", archive.Folder).
+		Str("layout", archive.Layout).
+		Str("suite", target.Suite).
+		Str("component", target.Component).
+		Msg("Refreshing packages...")
+
+	return archive.Publish(ctx, signer, target, added...)
+}
@@ -0,0 +1,223 @@
+package debian
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"github.com/spf13/viper"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// releaseDateFormat is the date format apt expects in Release files
+const releaseDateFormat = "Mon, 02 Jan 2006 15:04:05 UTC"
+
+// ReleaseOptions are the descriptive fields written to a Release file
+type ReleaseOptions struct {
+	Origin        string
+	Label         string
+	Suite         string
+	Version       string
+	Codename      string
+	Description   string
+	Architectures []string
+	Components    []string
+	ValidFor      time.Duration
+	Date          time.Time
+}
+
+// ReleaseOptionsFromConfig gets the Release file options from the 'release.*' config keys
+func ReleaseOptionsFromConfig() ReleaseOptions {
+	return ReleaseOptions{
+		Origin:        viper.GetString("release.origin"),
+		Label:         viper.GetString("release.label"),
+		Suite:         viper.GetString("release.suite"),
+		Version:       viper.GetString("release.version"),
+		Codename:      viper.GetString("release.codename"),
+		Description:   viper.GetString("release.description"),
+		Architectures: viper.GetStringSlice("release.architectures"),
+		Components:    viper.GetStringSlice("release.components"),
+		ValidFor:      viper.GetDuration("release.validfor"),
+	}
+}
+
+// releaseChecksums are the hash sections of a Release file, in the order they're written
+var releaseChecksums = []struct {
+	Name string
+	New  func() hash.Hash
+}{
+	{"MD5Sum", md5.New},
+	{"SHA1", sha1.New},
+	{"SHA256", sha256.New},
+	{"SHA512", sha512.New},
+}
+
+// releaseIndexFile is an index file listed in the hash sections of a Release file
+type releaseIndexFile struct {
+	Path      string
+	Size      int64
+	Checksums []string
+}
+
+// isReleaseIndexFile returns true if the file is one apt-ftparchive would list in a Release file
+func isReleaseIndexFile(name string) bool {
+	for _, prefix := range []string{"Packages", "Sources", "Contents-", "Translation-", "Components-", "Commands-", "icons-"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+
+	return name == "Release"
+}
+
+// findReleaseIndexFiles finds and hashes all index files under the folder
+func findReleaseIndexFiles(folder string) ([]releaseIndexFile, error) {
+	retval := []releaseIndexFile{}
+
+	err := filepath.WalkDir(folder, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			if p != folder && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		//	Skip the top level Release file itself
+		if filepath.Dir(p) == filepath.Clean(folder) && d.Name() == "Release" {
+			return nil
+		}
+
+		if !d.Type().IsRegular() || !isReleaseIndexFile(d.Name()) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(folder, p)
+		if err != nil {
+			return err
+		}
+
+		indexFile, err := hashReleaseIndexFile(p)
+		if err != nil {
+			return err
+		}
+		indexFile.Path = filepath.ToSlash(rel)
+
+		retval = append(retval, indexFile)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("problem finding index files: %w", err)
+	}
+
+	sort.Slice(retval, func(i, j int) bool {
+		return retval[i].Path < retval[j].Path
+	})
+
+	return retval, nil
+}
+
+// hashReleaseIndexFile computes the size and all Release checksums for a file
+func hashReleaseIndexFile(filename string) (releaseIndexFile, error) {
+	retval := releaseIndexFile{}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return retval, fmt.Errorf("problem opening %s: %w", filename, err)
+	}
+	defer f.Close()
+
+	hashes := make([]hash.Hash, len(releaseChecksums))
+	writers := make([]io.Writer, len(releaseChecksums))
+	for i, checksum := range releaseChecksums {
+		hashes[i] = checksum.New()
+		writers[i] = hashes[i]
+	}
+
+	retval.Size, err = io.Copy(io.MultiWriter(writers...), f)
+	if err != nil {
+		return retval, fmt.Errorf("problem hashing %s: %w", filename, err)
+	}
+
+	for _, h := range hashes {
+		retval.Checksums = append(retval.Checksums, hex.EncodeToString(h.Sum(nil)))
+	}
+
+	return retval, nil
+}
+
+// BuildRelease builds the contents of a Release file describing all of
+// the index files found under the folder
+func BuildRelease(folder string, opts ReleaseOptions) ([]byte, error) {
+	indexFiles, err := findReleaseIndexFiles(folder)
+	if err != nil {
+		return nil, err
+	}
+
+	date := opts.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+	date = date.UTC()
+
+	fields := Control{}
+	addField := func(name, value string) {
+		if strings.TrimSpace(value) != "" {
+			fields = append(fields, ControlField{Name: name, Value: value})
+		}
+	}
+
+	addField("Origin", opts.Origin)
+	addField("Label", opts.Label)
+	addField("Suite", opts.Suite)
+	addField("Version", opts.Version)
+	addField("Codename", opts.Codename)
+	addField("Date", date.Format(releaseDateFormat))
+	if opts.ValidFor > 0 {
+		addField("Valid-Until", date.Add(opts.ValidFor).Format(releaseDateFormat))
+	}
+	addField("Architectures", strings.Join(opts.Architectures, " "))
+	addField("Components", strings.Join(opts.Components, " "))
+	addField("Description", opts.Description)
+
+	var buf bytes.Buffer
+	if _, err := fields.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+
+	for i, checksum := range releaseChecksums {
+		fmt.Fprintf(&buf, "%s:\n", checksum.Name)
+		for _, indexFile := range indexFiles {
+			fmt.Fprintf(&buf, " %s %16d %s\n", indexFile.Checksums[i], indexFile.Size, indexFile.Path)
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// WriteReleaseFile builds the Release file for the folder and writes it into place
+func WriteReleaseFile(folder string, opts ReleaseOptions) error {
+	release, err := BuildRelease(folder, opts)
+	if err != nil {
+		return fmt.Errorf("problem building Release: %w", err)
+	}
+
+	if err := writeFileAtomic(filepath.Join(folder, "Release"), release); err != nil {
+		return fmt.Errorf("problem writing Release: %w", err)
+	}
+
+	return nil
+}
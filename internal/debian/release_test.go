@@ -0,0 +1,84 @@
+package debian
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestFiles creates the files (relative path to content) under the folder
+func writeTestFiles(t *testing.T, folder string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		p := filepath.Join(folder, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBuildRelease(t *testing.T) {
+	date := time.Date(2024, time.March, 5, 7, 8, 9, 0, time.FixedZone("EST", -5*60*60))
+
+	tests := []struct {
+		name   string
+		files  map[string]string
+		opts   ReleaseOptions
+		golden string
+	}{
+		{
+			name: "every option",
+			files: map[string]string{
+				"main/binary-amd64/Packages": "Package: foo\n",
+				"main/binary-all/Packages":   "",
+			},
+			opts: ReleaseOptions{
+				Origin:        "Example",
+				Label:         "Example packages",
+				Suite:         "stable",
+				Version:       "1.0",
+				Codename:      "bookworm",
+				Description:   "Packages for testing",
+				Architectures: []string{"amd64", "arm64"},
+				Components:    []string{"main", "contrib"},
+				ValidFor:      7 * 24 * time.Hour,
+				Date:          date,
+			},
+			golden: "Release.options.golden",
+		},
+		{
+			name: "only index files are listed",
+			files: map[string]string{
+				"Release":                    "the Release file being replaced",
+				"Release.gpg":                "signature",
+				"InRelease":                  "signed release",
+				"foo_1.0_amd64.deb":          "not an index",
+				".git/Packages":              "hidden",
+				"Packages":                   "Package: foo\n",
+				"main/binary-amd64/Release":  "Archive: stable\n",
+				"main/i18n/Translation-en":   "Package: foo\n",
+				"main/binary-amd64/Packages": "Package: foo\n",
+			},
+			opts:   ReleaseOptions{Date: date},
+			golden: "Release.indexes.golden",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			folder := t.TempDir()
+			writeTestFiles(t, folder, tt.files)
+
+			got, err := BuildRelease(folder, tt.opts)
+			if err != nil {
+				t.Fatalf("BuildRelease() error = %v", err)
+			}
+			checkGolden(t, tt.golden, got)
+		})
+	}
+}
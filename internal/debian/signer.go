@@ -0,0 +1,88 @@
+package debian
+
+import (
+	"context"
+	"fmt"
+	"github.com/spf13/viper"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// SignerNative signs with an in-process OpenPGP key
+	SignerNative = "native"
+
+	// SignerGPG signs by running the gpg binary
+	SignerGPG = "gpg"
+
+	// SignerExternal signs by handing the Release file to an external signer
+	// (a command or a service listening on a unix socket)
+	SignerExternal = "external"
+)
+
+// Signer creates the signatures for a Release file
+type Signer interface {
+	// DetachSign creates an armored detached signature for the data (the Release.gpg format)
+	DetachSign(ctx context.Context, data []byte) ([]byte, error)
+
+	// ClearSign creates a cleartext signed copy of the data (the InRelease format)
+	ClearSign(ctx context.Context, data []byte) ([]byte, error)
+}
+
+// NewSignerFromConfig creates the signer selected by the 'signing.backend' config key
+func NewSignerFromConfig(ctx context.Context) (Signer, error) {
+	backend := strings.ToLower(strings.TrimSpace(viper.GetString("signing.backend")))
+
+	switch backend {
+	case SignerNative, "":
+		return NewNativeSigner(
+			viper.GetString("gpg.key"),
+			viper.GetString("gpg.password"),
+			viper.GetString("git.email"),
+		)
+	case SignerGPG:
+		return NewGPGSigner(ctx,
+			viper.GetString("gpg.key"),
+			viper.GetString("gpg.password"),
+			viper.GetString("git.email"),
+		)
+	case SignerExternal:
+		return NewExternalSigner(
+			viper.GetString("signing.external.command"),
+			viper.GetString("signing.external.socket"),
+			viper.GetDuration("signing.external.timeout"),
+		)
+	}
+
+	return nil, fmt.Errorf("unknown signing backend '%s' (expected %s, %s or %s)", backend, SignerNative, SignerGPG, SignerExternal)
+}
+
+// SignRelease reads the Release file in the folder and writes the detached
+// signature to Release.gpg and the clearsigned copy to InRelease
+func SignRelease(ctx context.Context, folder string, signer Signer) error {
+	release, err := os.ReadFile(filepath.Join(folder, "Release"))
+	if err != nil {
+		return fmt.Errorf("problem reading Release: %w", err)
+	}
+
+	detached, err := signer.DetachSign(ctx, release)
+	if err != nil {
+		return err
+	}
+
+	clearsigned, err := signer.ClearSign(ctx, release)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(filepath.Join(folder, "Release.gpg"), detached); err != nil {
+		return fmt.Errorf("problem writing Release.gpg: %w", err)
+	}
+
+	if err := writeFileAtomic(filepath.Join(folder, "InRelease"), clearsigned); err != nil {
+		return fmt.Errorf("problem writing InRelease: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,175 @@
+package debian
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a parsed debian package version: [epoch:]upstream_version[-debian_revision]
+type Version struct {
+	Epoch    int
+	Upstream string
+	Revision string
+}
+
+// ParseVersion parses a debian package version string
+func ParseVersion(s string) (Version, error) {
+	retval := Version{}
+	s = strings.TrimSpace(s)
+
+	if s == "" {
+		return retval, fmt.Errorf("%w: empty version", ErrInvalidPackage)
+	}
+
+	//	The epoch is everything before the first colon
+	if epoch, rest, found := strings.Cut(s, ":"); found {
+		e, err := strconv.Atoi(epoch)
+		if err != nil || e < 0 {
+			return retval, fmt.Errorf("%w: bad epoch in version '%s'", ErrInvalidPackage, s)
+		}
+		retval.Epoch = e
+		s = rest
+	}
+
+	//	The revision is everything after the last hyphen
+	if i := strings.LastIndex(s, "-"); i >= 0 {
+		retval.Revision = s[i+1:]
+		s = s[:i]
+	}
+
+	if s == "" {
+		return retval, fmt.Errorf("%w: empty upstream version", ErrInvalidPackage)
+	}
+
+	retval.Upstream = s
+
+	return retval, nil
+}
+
+// String formats the version the way it appears in control files
+func (v Version) String() string {
+	retval := v.Upstream
+	if v.Epoch > 0 {
+		retval = strconv.Itoa(v.Epoch) + ":" + retval
+	}
+	if v.Revision != "" {
+		retval += "-" + v.Revision
+	}
+
+	return retval
+}
+
+// Compare returns -1, 0 or 1 if v is older than, the same as or newer than other
+func (v Version) Compare(other Version) int {
+	if v.Epoch != other.Epoch {
+		if v.Epoch < other.Epoch {
+			return -1
+		}
+		return 1
+	}
+
+	if c := compareVersionPart(v.Upstream, other.Upstream); c != 0 {
+		return c
+	}
+
+	return compareVersionPart(v.Revision, other.Revision)
+}
+
+// CompareVersions compares two debian version strings the way 'dpkg --compare-versions' does,
+// returning -1, 0 or 1 if a is older than, the same as or newer than b.  Versions that can't
+// be parsed sort before versions that can (and are compared as plain strings between themselves).
+func CompareVersions(a, b string) int {
+	va, errA := ParseVersion(a)
+	vb, errB := ParseVersion(b)
+
+	switch {
+	case errA != nil && errB != nil:
+		return strings.Compare(a, b)
+	case errA != nil:
+		return -1
+	case errB != nil:
+		return 1
+	}
+
+	return va.Compare(vb)
+}
+
+// compareVersionPart compares upstream versions or revisions using the debian policy
+// algorithm: alternating non-digit parts (compared with the special ordering from
+// versionCharOrder) and digit parts (compared numerically)
+func compareVersionPart(a, b string) int {
+	for a != "" || b != "" {
+		//	Compare the non-digit prefixes
+		var na, nb string
+		na, a = splitNonDigits(a)
+		nb, b = splitNonDigits(b)
+		for i := 0; i < len(na) || i < len(nb); i++ {
+			oa, ob := 0, 0
+			if i < len(na) {
+				oa = versionCharOrder(na[i])
+			}
+			if i < len(nb) {
+				ob = versionCharOrder(nb[i])
+			}
+			if oa != ob {
+				if oa < ob {
+					return -1
+				}
+				return 1
+			}
+		}
+
+		//	Then the digit prefixes, numerically
+		var da, db string
+		da, a = splitDigits(a)
+		db, b = splitDigits(b)
+		da = strings.TrimLeft(da, "0")
+		db = strings.TrimLeft(db, "0")
+		if len(da) != len(db) {
+			if len(da) < len(db) {
+				return -1
+			}
+			return 1
+		}
+		if c := strings.Compare(da, db); c != 0 {
+			return c
+		}
+	}
+
+	return 0
+}
+
+// versionCharOrder gives the sort weight of a character in the non-digit part of a
+// version: '~' sorts before everything (even the end of the string), then the end of
+// the string, then letters, then everything else
+func versionCharOrder(c byte) int {
+	switch {
+	case c == '~':
+		return -1
+	case c >= '0' && c <= '9':
+		return 0
+	case (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+		return int(c)
+	}
+
+	return int(c) + 256
+}
+
+// splitNonDigits splits s into its leading non-digit characters and the rest
+func splitNonDigits(s string) (string, string) {
+	i := 0
+	for i < len(s) && (s[i] < '0' || s[i] > '9') {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+// splitDigits splits s into its leading digits and the rest
+func splitDigits(s string) (string, string) {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return s[:i], s[i:]
+}
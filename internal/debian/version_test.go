@@ -0,0 +1,109 @@
+package debian
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "1.1", -1},
+		{"1.10", "1.9", 1},
+		{"1.01", "1.1", 0},
+		{"1.0-1", "1.0-2", -1},
+		{"1.0-10", "1.0-9", 1},
+		{"1.0", "1.0-0", 0},
+		{"1:1.0", "2.0", 1},
+		{"0:1.0", "1.0", 0},
+		{"1:0.1", "2:0.0", -1},
+		{"1.0~rc1", "1.0", -1},
+		{"1.0~rc1", "1.0~rc2", -1},
+		{"1.0~~", "1.0~", -1},
+		{"1.0~", "1.0", -1},
+		{"1.0", "1.0a", -1},
+		{"1.0a", "1.0+", -1},
+		{"1.0+", "1.0.", -1},
+		{"1.0.1", "1.0a", 1},
+		{"1.2.3-1ubuntu1", "1.2.3-1", 1},
+		{"2.30-0ubuntu2", "2.30-0ubuntu10", -1},
+		{"1.0-1+deb11u1", "1.0-1", 1},
+		{"1.0-1~bpo1", "1.0-1", -1},
+		{"7.6p2-4", "7.6-0", 1},
+		{"1.0.0", "1.0", 1},
+		{"a", "b", -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+" vs "+tt.b, func(t *testing.T) {
+			if got := CompareVersions(tt.a, tt.b); got != tt.want {
+				t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+			}
+
+			//	Swapping the versions swaps the result
+			if got := CompareVersions(tt.b, tt.a); got != -tt.want {
+				t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+			}
+		})
+	}
+}
+
+func TestCompareVersionsInvalid(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "1.0", -1},
+		{"x:1.0", "1.0", -1},
+		{"1.0", "-1", 1},
+		{"", "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+" vs "+tt.b, func(t *testing.T) {
+			if got := CompareVersions(tt.a, tt.b); got != tt.want {
+				t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Version
+		wantErr bool
+	}{
+		{in: "1.0", want: Version{Upstream: "1.0"}},
+		{in: "2:1.0-3", want: Version{Epoch: 2, Upstream: "1.0", Revision: "3"}},
+		{in: "1.0-2-3", want: Version{Upstream: "1.0-2", Revision: "3"}},
+		{in: "1:2.0:1", want: Version{Epoch: 1, Upstream: "2.0:1"}},
+		{in: " 1.0 ", want: Version{Upstream: "1.0"}},
+		{in: "", wantErr: true},
+		{in: "a:1.0", wantErr: true},
+		{in: "-1:1.0", wantErr: true},
+		{in: "1:", wantErr: true},
+		{in: "-1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseVersion(tt.in)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidPackage) {
+					t.Fatalf("ParseVersion(%q) error = %v, want ErrInvalidPackage", tt.in, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseVersion(%q) error = %v", tt.in, err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseVersion(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,381 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/robfig/cron/v3"
+	"github.com/rs/zerolog/log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// TriggerSchedule is the trigger for a run started by the task's schedule
+	TriggerSchedule = "schedule"
+
+	// TriggerManual is the trigger for a run that was asked for (through the API)
+	TriggerManual = "manual"
+)
+
+var (
+	// ErrTaskNotFound is returned when there's no task with the given name
+	ErrTaskNotFound = errors.New("task not found")
+
+	// ErrTaskRunning is returned when a task is asked to run while it's already running
+	ErrTaskRunning = errors.New("task is already running")
+
+	// ErrSchedulerStopped is returned when a task is asked to run after shutdown has started
+	ErrSchedulerStopped = errors.New("scheduler is shutting down")
+)
+
+// TaskFunc does the work of a maintenance task.  It returns a short description of what it did.
+type TaskFunc func(ctx context.Context) (string, error)
+
+// Task is a named maintenance task and the schedule it runs on
+type Task struct {
+	Name        string
+	Description string
+
+	// Schedule is a cron expression ("0 3 * * *"), a descriptor ("@daily", "@every 10m") or
+	// an interval ("10m").  An empty schedule (or "off") means the task only runs when asked.
+	Schedule string
+
+	// Local tasks look after this instance (like its disk or working copy) rather than the repo,
+	// so their scheduled runs aren't affected by RunScheduledWhen -- every instance runs them
+	Local bool
+
+	Run TaskFunc
+}
+
+// TaskRun is how a run of a task went
+type TaskRun struct {
+	Trigger   string    `json:"trigger"`
+	Started   time.Time `json:"started"`
+	Finished  time.Time `json:"finished"`
+	Duration  string    `json:"duration"`
+	Succeeded bool      `json:"succeeded"`
+	Result    string    `json:"result,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// TaskStatus describes a task, whether it's running now, when it runs next and how its last run went
+type TaskStatus struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Schedule    string     `json:"schedule"`
+	Local       bool       `json:"local"`
+	Running     bool       `json:"running"`
+	Started     *time.Time `json:"started,omitempty"`
+	NextRun     *time.Time `json:"nextRun,omitempty"`
+	LastRun     *TaskRun   `json:"lastRun,omitempty"`
+}
+
+// scheduledTask is a task along with its parsed schedule and run state
+type scheduledTask struct {
+	Task
+	schedule cron.Schedule
+	next     time.Time
+	running  bool
+	started  time.Time
+	last     *TaskRun
+}
+
+// Scheduler runs maintenance tasks on their schedules (or when asked).  A task never runs
+// more than once at a time: if it's still running when it's due again, that run is skipped.
+// Tasks don't run with the context passed to Start, so shutting down doesn't interrupt them
+// -- Shutdown waits for them to finish instead.
+type Scheduler struct {
+	mu      sync.Mutex
+	tasks   []*scheduledTask
+	stopped bool
+	when    func() bool
+
+	running    sync.WaitGroup
+	runCtx     context.Context
+	cancelRuns context.CancelFunc
+}
+
+// NewScheduler creates a scheduler for the tasks, making sure each of them has a valid schedule
+func NewScheduler(tasks ...Task) (*Scheduler, error) {
+	runCtx, cancelRuns := context.WithCancel(context.Background())
+	retval := &Scheduler{
+		runCtx:     runCtx,
+		cancelRuns: cancelRuns,
+	}
+
+	now := time.Now()
+	seen := make(map[string]bool)
+	for _, task := range tasks {
+		if task.Name == "" || seen[task.Name] {
+			cancelRuns()
+			return nil, fmt.Errorf("task names must be unique and not empty (got '%s')", task.Name)
+		}
+		seen[task.Name] = true
+
+		schedule, err := ParseSchedule(task.Schedule)
+		if err != nil {
+			cancelRuns()
+			return nil, fmt.Errorf("problem with the schedule for task %s: %w", task.Name, err)
+		}
+
+		st := &scheduledTask{Task: task, schedule: schedule}
+		if schedule != nil {
+			st.next = schedule.Next(now)
+		}
+		retval.tasks = append(retval.tasks, st)
+	}
+
+	return retval, nil
+}
+
+// ParseSchedule parses a task schedule: a standard 5 field cron expression, a descriptor like
+// "@daily" or "@every 1h", or an interval like "10m".  An empty schedule (or "off") returns nil,
+// which means the task only runs when asked.
+func ParseSchedule(spec string) (cron.Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	switch strings.ToLower(spec) {
+	case "", "off", "never":
+		return nil, nil
+	}
+
+	if interval, err := time.ParseDuration(spec); err == nil {
+		if interval <= 0 {
+			return nil, fmt.Errorf("interval must be positive (got %s)", spec)
+		}
+		return cron.Every(interval), nil
+	}
+
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule '%s': %w", spec, err)
+	}
+
+	return schedule, nil
+}
+
+// RunScheduledWhen makes scheduled runs depend on the condition (like this instance being the
+// leader), checked each time tasks come due.  Runs that are asked for (and scheduled runs of
+// local tasks) aren't affected.
+func (s *Scheduler) RunScheduledWhen(condition func() bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.when = condition
+}
+
+// Start runs tasks as they come due, until the context is done
+func (s *Scheduler) Start(ctx context.Context) {
+	log.Info().Msg("Starting maintenance task scheduler...")
+
+	for {
+		//	Sleep until the next task is due
+		var timer *time.Timer
+		var wait <-chan time.Time
+		if next, ok := s.nextDue(); ok {
+			timer = time.NewTimer(time.Until(next))
+			wait = timer.C
+		}
+
+		select {
+		case <-wait:
+			s.runDue(time.Now())
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			log.Info().Msg("Maintenance task scheduler stopping")
+			return
+		}
+	}
+}
+
+// nextDue returns the earliest time a task is due, if any task is scheduled
+func (s *Scheduler) nextDue() (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var retval time.Time
+	for _, task := range s.tasks {
+		if task.schedule != nil && (retval.IsZero() || task.next.Before(retval)) {
+			retval = task.next
+		}
+	}
+
+	return retval, !retval.IsZero()
+}
+
+// runDue starts every task that's due, and works out when each of them is due next
+func (s *Scheduler) runDue(now time.Time) {
+	due := []string{}
+	conditional := []string{}
+
+	s.mu.Lock()
+	for _, task := range s.tasks {
+		if task.schedule != nil && !task.next.After(now) {
+			task.next = task.schedule.Next(now)
+			if task.Local {
+				due = append(due, task.Name)
+			} else {
+				conditional = append(conditional, task.Name)
+			}
+		}
+	}
+	when := s.when
+	s.mu.Unlock()
+
+	if len(conditional) > 0 {
+		if when != nil && !when() {
+			log.Debug().Strs("tasks", conditional).Msg("Scheduled runs aren't enabled on this instance right now -- skipping")
+		} else {
+			due = append(due, conditional...)
+		}
+	}
+
+	for _, name := range due {
+		_, err := s.Run(name, TriggerSchedule)
+		if errors.Is(err, ErrTaskRunning) {
+			log.Warn().Str("task", name).Msg("Task is still running from last time -- skipping this run")
+			continue
+		}
+		if err != nil {
+			log.Err(err).Str("task", name).Msg("problem starting scheduled task")
+		}
+	}
+}
+
+// Run starts the named task in the background (if it isn't already running) and
+// returns its status
+func (s *Scheduler) Run(name, trigger string) (TaskStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task := s.find(name)
+	if task == nil {
+		return TaskStatus{}, fmt.Errorf("%w: %s", ErrTaskNotFound, name)
+	}
+
+	if s.stopped {
+		return task.status(), ErrSchedulerStopped
+	}
+
+	if task.running {
+		return task.status(), fmt.Errorf("%w: %s", ErrTaskRunning, name)
+	}
+
+	task.running = true
+	task.started = time.Now()
+
+	s.running.Add(1)
+	go s.execute(task, trigger, task.started)
+
+	return task.status(), nil
+}
+
+// execute runs the task and records how it went
+func (s *Scheduler) execute(task *scheduledTask, trigger string, started time.Time) {
+	defer s.running.Done()
+
+	log.Info().Str("task", task.Name).Str("trigger", trigger).Msg("Running task")
+	result, err := task.Run(s.runCtx)
+	finished := time.Now()
+
+	run := &TaskRun{
+		Trigger:   trigger,
+		Started:   started,
+		Finished:  finished,
+		Duration:  finished.Sub(started).Round(time.Millisecond).String(),
+		Succeeded: err == nil,
+		Result:    result,
+	}
+
+	if err != nil {
+		run.Error = err.Error()
+		log.Err(err).Str("task", task.Name).Str("duration", run.Duration).Msg("Task failed")
+	} else {
+		log.Info().Str("task", task.Name).Str("duration", run.Duration).Str("result", result).Msg("Task finished")
+	}
+
+	s.mu.Lock()
+	task.running = false
+	task.last = run
+	s.mu.Unlock()
+}
+
+// Status returns the status of every task
+func (s *Scheduler) Status() []TaskStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	retval := []TaskStatus{}
+	for _, task := range s.tasks {
+		retval = append(retval, task.status())
+	}
+
+	return retval
+}
+
+// Shutdown stops any more tasks from starting and waits for the ones that are running to
+// finish.  If they're still running after the timeout, they're canceled (and waited for).
+func (s *Scheduler) Shutdown(timeout time.Duration) {
+	s.mu.Lock()
+	s.stopped = true
+	s.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-time.After(timeout):
+		log.Warn().Dur("timeout", timeout).Msg("Tasks still running after the drain timeout -- canceling them")
+		s.cancelRuns()
+		<-drained
+	}
+
+	s.cancelRuns()
+	log.Info().Msg("Maintenance tasks drained")
+}
+
+// find returns the named task, or nil.  The caller must hold the lock.
+func (s *Scheduler) find(name string) *scheduledTask {
+	for _, task := range s.tasks {
+		if task.Name == name {
+			return task
+		}
+	}
+
+	return nil
+}
+
+// status describes the task.  The caller must hold the scheduler lock.
+func (task *scheduledTask) status() TaskStatus {
+	retval := TaskStatus{
+		Name:        task.Name,
+		Description: task.Description,
+		Schedule:    task.Schedule,
+		Local:       task.Local,
+		Running:     task.running,
+	}
+
+	if task.running {
+		started := task.started
+		retval.Started = &started
+	}
+
+	if task.schedule != nil {
+		next := task.next
+		retval.NextRun = &next
+	}
+
+	if task.last != nil {
+		last := *task.last
+		retval.LastRun = &last
+	}
+
+	return retval
+}
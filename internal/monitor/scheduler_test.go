@@ -0,0 +1,301 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingTask is a fake task that runs until it's released (or its context is canceled)
+type blockingTask struct {
+	started  chan struct{}
+	release  chan struct{}
+	canceled atomic.Bool
+}
+
+func newBlockingTask() *blockingTask {
+	return &blockingTask{started: make(chan struct{}, 10), release: make(chan struct{})}
+}
+
+func (b *blockingTask) Run(ctx context.Context) (string, error) {
+	b.started <- struct{}{}
+	select {
+	case <-b.release:
+		return "done", nil
+	case <-ctx.Done():
+		b.canceled.Store(true)
+		return "", ctx.Err()
+	}
+}
+
+// waitForStart waits for the task to start running
+func (b *blockingTask) waitForStart(t *testing.T) {
+	t.Helper()
+
+	select {
+	case <-b.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("task didn't start")
+	}
+}
+
+// waitForLastRun waits for the named task to finish a run, and returns how it went
+func waitForLastRun(t *testing.T, s *Scheduler, name string) TaskRun {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, status := range s.Status() {
+			if status.Name == name && !status.Running && status.LastRun != nil {
+				return *status.LastRun
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("task %s didn't finish", name)
+	return TaskRun{}
+}
+
+func TestParseSchedule(t *testing.T) {
+	from := time.Date(2024, time.March, 5, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		spec     string
+		wantNil  bool
+		wantNext time.Time
+		wantErr  bool
+	}{
+		{spec: "", wantNil: true},
+		{spec: "off", wantNil: true},
+		{spec: " OFF ", wantNil: true},
+		{spec: "never", wantNil: true},
+		{spec: "10m", wantNext: from.Add(10 * time.Minute)},
+		{spec: "1h30m", wantNext: from.Add(90 * time.Minute)},
+		{spec: "@every 1h", wantNext: from.Add(time.Hour)},
+		{spec: "@daily", wantNext: time.Date(2024, time.March, 6, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 3 * * *", wantNext: time.Date(2024, time.March, 6, 3, 0, 0, 0, time.UTC)},
+		{spec: "*/15 * * * *", wantNext: from.Add(15 * time.Minute)},
+		{spec: "0s", wantErr: true},
+		{spec: "-5m", wantErr: true},
+		{spec: "every day", wantErr: true},
+		{spec: "0 3 * *", wantErr: true},
+		{spec: "61 * * * *", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParseSchedule(tt.spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseSchedule(%q) = %v, want an error", tt.spec, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseSchedule(%q) error = %v", tt.spec, err)
+			}
+
+			if tt.wantNil {
+				if got != nil {
+					t.Errorf("ParseSchedule(%q) = %v, want no schedule", tt.spec, got)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatalf("ParseSchedule(%q) = nil, want a schedule", tt.spec)
+			}
+			if next := got.Next(from); !next.Equal(tt.wantNext) {
+				t.Errorf("ParseSchedule(%q) next run = %v, want %v", tt.spec, next, tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestNewSchedulerInvalidTasks(t *testing.T) {
+	noop := func(ctx context.Context) (string, error) { return "", nil }
+
+	tests := []struct {
+		name  string
+		tasks []Task
+	}{
+		{name: "no name", tasks: []Task{{Run: noop}}},
+		{name: "same name twice", tasks: []Task{{Name: "gc", Run: noop}, {Name: "gc", Run: noop}}},
+		{name: "bad schedule", tasks: []Task{{Name: "gc", Schedule: "sometimes", Run: noop}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewScheduler(tt.tasks...); err == nil {
+				t.Error("NewScheduler() error = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestSchedulerRun(t *testing.T) {
+	task := newBlockingTask()
+	s, err := NewScheduler(Task{Name: "check", Run: task.Run})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown(time.Second)
+
+	if _, err := s.Run("nothing", TriggerManual); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("Run() error = %v, want ErrTaskNotFound", err)
+	}
+
+	status, err := s.Run("check", TriggerManual)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if !status.Running || status.Started == nil {
+		t.Errorf("Run() status = %+v, want it running", status)
+	}
+	task.waitForStart(t)
+
+	//	It doesn't run again while it's still running
+	status, err = s.Run("check", TriggerSchedule)
+	if !errors.Is(err, ErrTaskRunning) {
+		t.Errorf("Run() error = %v, want ErrTaskRunning", err)
+	}
+	if !status.Running {
+		t.Errorf("Run() status = %+v, want it running", status)
+	}
+
+	close(task.release)
+	run := waitForLastRun(t, s, "check")
+	if !run.Succeeded || run.Result != "done" || run.Trigger != TriggerManual {
+		t.Errorf("last run = %+v, want the manual run to have succeeded", run)
+	}
+
+	//	Once it's finished, it can run again
+	if _, err := s.Run("check", TriggerManual); err != nil {
+		t.Errorf("Run() error = %v", err)
+	}
+}
+
+func TestSchedulerRunDue(t *testing.T) {
+	ran := make(chan string, 10)
+	fake := func(name string) TaskFunc {
+		return func(ctx context.Context) (string, error) {
+			ran <- name
+			return "", nil
+		}
+	}
+
+	s, err := NewScheduler(
+		Task{Name: "repo", Schedule: "1h", Run: fake("repo")},
+		Task{Name: "disk", Schedule: "1h", Local: true, Run: fake("disk")},
+		Task{Name: "manual", Run: fake("manual")},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown(time.Second)
+
+	//	Only local tasks run on an instance that isn't running scheduled tasks
+	s.RunScheduledWhen(func() bool { return false })
+	s.runDue(time.Now().Add(2 * time.Hour))
+
+	select {
+	case name := <-ran:
+		if name != "disk" {
+			t.Errorf("%s ran, want only the local task", name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the local task didn't run")
+	}
+	waitForLastRun(t, s, "disk")
+
+	select {
+	case name := <-ran:
+		t.Errorf("%s ran, want only the local task", name)
+	default:
+	}
+
+	//	The next run is worked out from when they came due
+	for _, status := range s.Status() {
+		if status.Name == "manual" {
+			if status.NextRun != nil {
+				t.Errorf("task without a schedule runs next at %v", status.NextRun)
+			}
+			continue
+		}
+		if status.NextRun == nil || !status.NextRun.After(time.Now().Add(2*time.Hour)) {
+			t.Errorf("task %s runs next at %v, want after it came due", status.Name, status.NextRun)
+		}
+	}
+}
+
+func TestSchedulerShutdown(t *testing.T) {
+	t.Run("drains running tasks", func(t *testing.T) {
+		task := newBlockingTask()
+		s, err := NewScheduler(Task{Name: "check", Run: task.Run})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := s.Run("check", TriggerManual); err != nil {
+			t.Fatal(err)
+		}
+		task.waitForStart(t)
+
+		done := make(chan struct{})
+		go func() {
+			s.Shutdown(5 * time.Second)
+			close(done)
+		}()
+
+		//	Shutdown waits for the task, and doesn't let anything else start meanwhile
+		select {
+		case <-done:
+			t.Fatal("Shutdown() returned while the task was still running")
+		case <-time.After(50 * time.Millisecond):
+		}
+		if _, err := s.Run("check", TriggerManual); !errors.Is(err, ErrSchedulerStopped) {
+			t.Errorf("Run() error = %v, want ErrSchedulerStopped", err)
+		}
+
+		close(task.release)
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Shutdown() didn't return once the task finished")
+		}
+		if task.canceled.Load() {
+			t.Error("the task was canceled, want it to finish")
+		}
+		if run := waitForLastRun(t, s, "check"); !run.Succeeded {
+			t.Errorf("last run = %+v, want it to have succeeded", run)
+		}
+
+		if _, err := s.Run("check", TriggerManual); !errors.Is(err, ErrSchedulerStopped) {
+			t.Errorf("Run() error = %v, want ErrSchedulerStopped", err)
+		}
+	})
+
+	t.Run("cancels tasks after the timeout", func(t *testing.T) {
+		task := newBlockingTask()
+		s, err := NewScheduler(Task{Name: "check", Run: task.Run})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := s.Run("check", TriggerManual); err != nil {
+			t.Fatal(err)
+		}
+		task.waitForStart(t)
+
+		s.Shutdown(50 * time.Millisecond)
+
+		if !task.canceled.Load() {
+			t.Error("the task wasn't canceled")
+		}
+		if run := waitForLastRun(t, s, "check"); run.Succeeded || run.Error == "" {
+			t.Errorf("last run = %+v, want it to have failed", run)
+		}
+	})
+}
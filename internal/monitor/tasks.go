@@ -0,0 +1,130 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"github.com/danesparza/package-assistant/internal/publish"
+	"github.com/danesparza/package-assistant/internal/upload"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"strings"
+	"time"
+)
+
+const (
+	TaskPrune   = "prune"
+	TaskResign  = "resign"
+	TaskCheck   = "check"
+	TaskGC      = "gc"
+	TaskUploads = "uploads"
+)
+
+// Service encapsulates the background maintenance service
+type Service struct {
+	StartTime  time.Time
+	PublishSvc publish.Service
+}
+
+// Tasks returns the maintenance tasks, with the schedules from the 'tasks.<name>.schedule' config keys
+func (service Service) Tasks() []Task {
+	return []Task{
+		{
+			Name:        TaskPrune,
+			Description: "Remove old package versions using the retention policy",
+			Schedule:    viper.GetString("tasks.prune.schedule"),
+			Run:         service.prune,
+		},
+		{
+			Name:        TaskResign,
+			Description: "Regenerate and sign the indexes and Release files, so Valid-Until doesn't expire",
+			Schedule:    viper.GetString("tasks.resign.schedule"),
+			Run:         service.resign,
+		},
+		{
+			Name:        TaskCheck,
+			Description: "Make sure the indexes, Release files and signatures match the package files",
+			Schedule:    viper.GetString("tasks.check.schedule"),
+			Run:         service.check,
+		},
+		{
+			Name:        TaskGC,
+			Description: "Pack the git objects of the working copy and remove unreachable ones",
+			Schedule:    viper.GetString("tasks.gc.schedule"),
+			Local:       true,
+			Run:         service.gc,
+		},
+		{
+			Name:        TaskUploads,
+			Description: "Remove resumable upload sessions that have expired",
+			Schedule:    viper.GetString("tasks.uploads.schedule"),
+			Local:       true,
+			Run:         service.uploads,
+		},
+	}
+}
+
+// NewScheduler creates a scheduler for the maintenance tasks
+func (service Service) NewScheduler() (*Scheduler, error) {
+	return NewScheduler(service.Tasks()...)
+}
+
+// prune removes old package versions in a repo transaction, so we don't race with uploads
+func (service Service) prune(ctx context.Context) (string, error) {
+	plan, sha, err := service.PublishSvc.Prune(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+
+	if sha == "" {
+		return "nothing to remove", nil
+	}
+
+	return fmt.Sprintf("removed %d package files in commit %s", len(plan.Remove), sha), nil
+}
+
+// resign regenerates and signs the indexes and Release files
+func (service Service) resign(ctx context.Context) (string, error) {
+	sha, err := service.PublishSvc.Resign(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("re-signed in commit %s", sha), nil
+}
+
+// check looks for problems with what's published.  Finding any counts as a failure.
+func (service Service) check(ctx context.Context) (string, error) {
+	problems, err := service.PublishSvc.Check(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			log.Warn().Str("problem", problem).Msg("Consistency check found a problem")
+		}
+		return "", fmt.Errorf("found %d problems: %s", len(problems), strings.Join(problems, "; "))
+	}
+
+	return "no problems found", nil
+}
+
+// gc tidies up the git object store
+func (service Service) gc(ctx context.Context) (string, error) {
+	removed, err := service.PublishSvc.GC(ctx, viper.GetDuration("tasks.gc.grace"))
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("packed objects and removed %d unreachable objects", removed), nil
+}
+
+// uploads removes expired upload sessions (and whatever was uploaded to them)
+func (service Service) uploads(ctx context.Context) (string, error) {
+	removed, err := upload.StoreFromConfig().RemoveExpired(time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("removed %d expired upload sessions", removed), nil
+}
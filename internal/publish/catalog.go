@@ -0,0 +1,80 @@
+package publish
+
+import (
+	"context"
+	"errors"
+	"github.com/danesparza/package-assistant/internal/cache"
+	"github.com/danesparza/package-assistant/internal/catalog"
+	"github.com/danesparza/package-assistant/internal/debian"
+	"github.com/danesparza/package-assistant/internal/retention"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"time"
+)
+
+// defaultPackageIndexTTL is used when redis.TTL isn't a positive duration (each commit has its
+// own cached index, so they have to expire)
+const defaultPackageIndexTTL = time.Hour
+
+// packageIndexKey is the cache key for the index of the packages published as of the commit.
+// The cache is shared between instances, and their working copies can be at different commits
+// for a while, so each commit has its own index.
+func packageIndexKey(commit string) string {
+	return cache.GetKey("packages", "index", commit)
+}
+
+// PackageIndex returns the index of everything that's published at the commit checked out.
+// It's cached by commit, and built from the working copy when there's no cached index for the
+// commit (or it's expired).  It's built while no transaction is running, so it only has what's
+// been committed.
+func (service Service) PackageIndex(ctx context.Context) (catalog.Index, error) {
+	index := catalog.Index{}
+
+	commit, err := service.RepoSvc.Head()
+	if err != nil {
+		return index, err
+	}
+
+	err = service.Cache.GetValue(ctx, packageIndexKey(commit), &index)
+	if err == nil && index.Commit == commit {
+		return index, nil
+	}
+	if err != nil && !errors.Is(err, cache.ErrNotCached) {
+		log.Err(err).Msg("problem getting cached package index -- rebuilding it")
+	}
+
+	archive := debian.ArchiveFromConfig()
+	unlock := ReadWorkingCopy()
+	defer unlock()
+
+	//	A transaction may have committed while we waited
+	commit, err = service.RepoSvc.Head()
+	if err != nil {
+		return index, err
+	}
+
+	index, err = catalog.Build(ctx, archive, commit, service.RepoSvc.FileTimes)
+	if err != nil {
+		return index, err
+	}
+
+	//	Mark the versions that are pinned, so they're easy to spot
+	pins, err := retention.ReadPins(retention.PinsFile(archive.Folder))
+	if err != nil {
+		return index, err
+	}
+	for i, pkg := range index.Packages {
+		index.Packages[i].Pinned = pins.IsPinned(pkg.Package, pkg.Version)
+	}
+	log.Debug().Str("commit", commit).Int("packages", len(index.Packages)).Msg("Built package index")
+
+	ttl := viper.GetDuration("redis.TTL")
+	if ttl <= 0 {
+		ttl = defaultPackageIndexTTL
+	}
+	if err := service.Cache.SetValue(ctx, packageIndexKey(commit), index, ttl); err != nil {
+		log.Err(err).Msg("problem caching package index")
+	}
+
+	return index, nil
+}
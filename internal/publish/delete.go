@@ -0,0 +1,119 @@
+package publish
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/danesparza/package-assistant/internal/debian"
+	"github.com/danesparza/package-assistant/internal/retention"
+	"github.com/rs/zerolog/log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	StageDelete = "delete"
+)
+
+// ErrVersionPinned is returned when deleting a pinned version without forcing it
+var ErrVersionPinned = errors.New("version is pinned")
+
+// DeleteResult describes the package files a delete removed
+type DeleteResult struct {
+	Package      string   `json:"package"`
+	Version      string   `json:"version"`
+	Architecture string   `json:"architecture,omitempty"`
+	Removed      []string `json:"removed"`
+	Unpinned     bool     `json:"unpinned,omitempty"`
+	CommitSHA    string   `json:"commitSha"`
+}
+
+// Delete removes a version of a package (for just one architecture, if arch isn't empty) in
+// a repo transaction: the package files are removed, the indexes are refreshed and signed, and
+// the change is committed and pushed.  Pinned versions are only deleted if 'force' is set, in
+// which case the pin goes too (once no architecture of the version is left).
+func (service Service) Delete(ctx context.Context, pkg, version, arch string, force bool) (DeleteResult, error) {
+	result := DeleteResult{
+		Package:      pkg,
+		Version:      version,
+		Architecture: arch,
+		Removed:      []string{},
+	}
+
+	sha, err := service.Transaction(ctx, nil, debian.Target{}, StageDelete, func(ctx context.Context, tx *Tx) error {
+		pkgs, err := debian.ScanPackages(ctx, tx.RepoPath)
+		if err != nil {
+			return err
+		}
+
+		//	Find the files to remove (and whether any architecture of the version will be left)
+		remove := []string{}
+		remaining := 0
+		for _, p := range pkgs {
+			if p.Package != pkg || debian.CompareVersions(p.Version, version) != 0 {
+				continue
+			}
+			if arch != "" && p.Architecture != arch {
+				remaining++
+				continue
+			}
+			remove = append(remove, strings.TrimPrefix(p.Filename, "./"))
+		}
+
+		if len(remove) == 0 {
+			if arch != "" {
+				return fmt.Errorf("%w: %s %s (%s)", debian.ErrPackageNotFound, pkg, version, arch)
+			}
+			return fmt.Errorf("%w: %s %s", debian.ErrPackageNotFound, pkg, version)
+		}
+
+		//	Pinned versions are protected unless we're told otherwise
+		pinsFile := retention.PinsFile(tx.RepoPath)
+		pins, err := retention.ReadPins(pinsFile)
+		if err != nil {
+			return err
+		}
+
+		if pins.IsPinned(pkg, version) {
+			if !force {
+				return fmt.Errorf("%w: %s %s (unpin it or force the delete)", ErrVersionPinned, pkg, version)
+			}
+
+			if remaining == 0 {
+				if err := pins.Remove(pkg, version); err != nil {
+					return err
+				}
+				if err := retention.WritePins(pinsFile, pins); err != nil {
+					return err
+				}
+				result.Unpinned = true
+			}
+		}
+
+		for _, file := range remove {
+			log.Info().Str("filename", file).Msg("Deleting package file")
+			if err := os.Remove(filepath.Join(tx.RepoPath, filepath.FromSlash(file))); err != nil {
+				return fmt.Errorf("problem removing %s: %w", file, err)
+			}
+		}
+		result.Removed = remove
+
+		tx.Message = fmt.Sprintf("Delete %s %s", pkg, version)
+		if arch != "" {
+			tx.Message += fmt.Sprintf(" (%s)", arch)
+		}
+		tx.Message += "\n\nRemoved:\n  " + strings.Join(remove, "\n  ")
+		if result.Unpinned {
+			tx.Message += "\n\nThe version was pinned -- the delete was forced and the pin removed."
+		}
+
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	result.CommitSHA = sha
+	return result, nil
+}
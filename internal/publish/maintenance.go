@@ -0,0 +1,52 @@
+package publish
+
+import (
+	"context"
+	"github.com/danesparza/package-assistant/internal/cache"
+	"github.com/danesparza/package-assistant/internal/debian"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+const (
+	StageResign = "resign"
+)
+
+// Resign regenerates and signs the indexes and Release files without changing the published
+// packages, in a repo transaction.  This keeps a Release file with a Valid-Until date from
+// expiring.  It returns the hash of the new commit.
+func (service Service) Resign(ctx context.Context, job *cache.Job) (string, error) {
+	return service.Transaction(ctx, job, debian.Target{}, StageResign, func(ctx context.Context, tx *Tx) error {
+		log.Info().Str("folder", tx.RepoPath).Msg("Re-signing the package repo")
+		return nil
+	})
+}
+
+// Check looks for problems with what's published in the working copy (see debian.Archive.Check).
+// It holds the repo lock while it looks, so it never sees a transaction half done.
+func (service Service) Check(ctx context.Context) ([]string, error) {
+	problems := []string{}
+
+	err := service.WithLock(ctx, nil, func(ctx context.Context) error {
+		var err error
+		problems, err = debian.ArchiveFromConfig().Check(ctx)
+		return err
+	})
+
+	return problems, err
+}
+
+// GC tidies up the git object store of the working copy (see repo.GitRepoService.GC).  Every
+// instance has its own working copy, so it holds this instance's working copy lock (rather than
+// the repo lock) to keep transactions here from committing while it runs.  It returns the number
+// of unreachable objects removed.
+func (service Service) GC(ctx context.Context, grace time.Duration) (int, error) {
+	workingCopy.Lock()
+	defer workingCopy.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return service.RepoSvc.GC(grace)
+}
@@ -0,0 +1,38 @@
+package publish
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestGCWaitsForWorkingCopy(t *testing.T) {
+	service, _, _ := testRepo(t)
+
+	//	It only needs this instance's working copy, not the repo lock
+	service.Cache = nil
+
+	workingCopy.Lock()
+	done := make(chan error, 1)
+	go func() {
+		_, err := service.GC(context.Background(), 0)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		workingCopy.Unlock()
+		t.Fatalf("GC() returned (error = %v) while a transaction held the working copy", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	workingCopy.Unlock()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("GC() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("GC() didn't finish once the working copy was released")
+	}
+}
@@ -0,0 +1,84 @@
+package publish
+
+import (
+	"context"
+	"fmt"
+	"github.com/danesparza/package-assistant/internal/debian"
+	"github.com/danesparza/package-assistant/internal/retention"
+	"time"
+)
+
+const (
+	StagePin = "pin"
+)
+
+// Pin protects a version of a package from being pruned.  The pin is committed to the pins
+// file in the repo, in a repo transaction.  It returns the pin and the hash of the new commit
+// (which is empty if the version was already pinned for the same reason).
+func (service Service) Pin(ctx context.Context, pkg, version, reason string) (retention.Pin, string, error) {
+	pin := retention.Pin{
+		Package: pkg,
+		Version: version,
+		Reason:  reason,
+		Pinned:  time.Now().UTC(),
+	}
+
+	sha, err := service.Transaction(ctx, nil, debian.Target{}, StagePin, func(ctx context.Context, tx *Tx) error {
+		tx.SkipRefresh = true
+
+		//	Make sure there's something to pin
+		pkgs, err := debian.ScanPackages(ctx, tx.RepoPath)
+		if err != nil {
+			return err
+		}
+
+		found := false
+		for _, p := range pkgs {
+			if p.Package == pkg && debian.CompareVersions(p.Version, version) == 0 {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%w: %s %s", debian.ErrPackageNotFound, pkg, version)
+		}
+
+		pinsFile := retention.PinsFile(tx.RepoPath)
+		pins, err := retention.ReadPins(pinsFile)
+		if err != nil {
+			return err
+		}
+
+		if !pins.Add(pin) {
+			pin, _ = pins.Find(pkg, version)
+			return ErrNoChanges
+		}
+
+		pin, _ = pins.Find(pkg, version)
+		return retention.WritePins(pinsFile, pins)
+	})
+	if err != nil {
+		return pin, "", err
+	}
+
+	return pin, sha, nil
+}
+
+// Unpin removes the pin for a version of a package, in a repo transaction.  It returns the hash of the new commit.
+func (service Service) Unpin(ctx context.Context, pkg, version string) (string, error) {
+	return service.Transaction(ctx, nil, debian.Target{}, StagePin, func(ctx context.Context, tx *Tx) error {
+		tx.SkipRefresh = true
+
+		pinsFile := retention.PinsFile(tx.RepoPath)
+		pins, err := retention.ReadPins(pinsFile)
+		if err != nil {
+			return err
+		}
+
+		if err := pins.Remove(pkg, version); err != nil {
+			return err
+		}
+
+		return retention.WritePins(pinsFile, pins)
+	})
+}
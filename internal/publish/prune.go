@@ -0,0 +1,73 @@
+package publish
+
+import (
+	"context"
+	"fmt"
+	"github.com/danesparza/package-assistant/internal/cache"
+	"github.com/danesparza/package-assistant/internal/debian"
+	"github.com/danesparza/package-assistant/internal/retention"
+	"github.com/rs/zerolog/log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	StagePrune = "prune"
+)
+
+// PreviewPrune works out what the retention policy would remove from the working copy, without changing anything
+func (service Service) PreviewPrune(ctx context.Context) (retention.Plan, error) {
+	return service.planPrune(ctx, debian.ArchiveFromConfig())
+}
+
+// Prune removes the package files the retention policy doesn't keep, in a repo transaction.
+// It returns the plan that was applied and the hash of the new commit (which is empty if
+// there was nothing to remove).
+func (service Service) Prune(ctx context.Context, job *cache.Job) (retention.Plan, string, error) {
+	plan := retention.Plan{}
+
+	sha, err := service.Transaction(ctx, job, debian.Target{}, StagePrune, func(ctx context.Context, tx *Tx) error {
+		var err error
+		plan, err = service.planPrune(ctx, tx.Archive)
+		if err != nil {
+			return err
+		}
+		retention.LogPlan(plan)
+
+		//	Remove the files if we have some to remove
+		if len(plan.Remove) == 0 {
+			return ErrNoChanges
+		}
+
+		for _, file := range plan.Remove {
+			if err := os.Remove(filepath.Join(tx.RepoPath, filepath.FromSlash(file))); err != nil {
+				return fmt.Errorf("problem removing %s: %w", file, err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return plan, "", err
+	}
+
+	return plan, sha, nil
+}
+
+// planPrune applies the configured retention policy to the archive
+func (service Service) planPrune(ctx context.Context, archive debian.Archive) (retention.Plan, error) {
+	policy, err := retention.PolicyFromConfig()
+	if err != nil {
+		return retention.Plan{}, err
+	}
+
+	plan, err := retention.NewPlan(ctx, archive, policy, service.RepoSvc.FileTimes, time.Now())
+	if err != nil {
+		return plan, fmt.Errorf("problem applying retention policy: %w", err)
+	}
+
+	log.Debug().Strs("remove", plan.Remove).Msg("Selected files to remove")
+
+	return plan, nil
+}
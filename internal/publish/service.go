@@ -0,0 +1,411 @@
+package publish
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/danesparza/package-assistant/internal/cache"
+	"github.com/danesparza/package-assistant/internal/debian"
+	"github.com/danesparza/package-assistant/internal/repo"
+	"github.com/rs/zerolog/log"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// JobTypeUpload publishes an uploaded package into the repo
+	JobTypeUpload = "upload"
+)
+
+// Job error codes, so callers can tell why a job failed
+const (
+	ErrorCodeLockTimeout     = "lock_timeout"
+	ErrorCodeLockUnavailable = "lock_unavailable"
+	ErrorCodeVersionExists   = "version_exists"
+)
+
+// ErrVersionExists is returned when a package file with different content is already
+// published as the version being uploaded (and overwriting wasn't asked for)
+var ErrVersionExists = errors.New("version already exists")
+
+const (
+	StagePull    = "pull"
+	StageMove    = "move"
+	StageRefresh = "refresh"
+	StageCommit  = "commit"
+)
+
+// UploadStages are the steps an upload job goes through, in order
+var UploadStages = []string{StageLock, StagePull, StageMove, StageRefresh, StageCommit}
+
+// Upload job parameters
+const (
+	ParamFile      = "file"
+	ParamFilename  = "filename"
+	ParamSuite     = "suite"
+	ParamComponent = "component"
+	ParamSize      = "size"
+	ParamMD5       = "md5"
+	ParamSHA1      = "sha1"
+	ParamSHA256    = "sha256"
+	ParamSHA512    = "sha512"
+	ParamOverwrite = "overwrite"
+
+	// ParamIdempotencyKey is the idempotency key of the request that started the job (if it had one)
+	ParamIdempotencyKey = "idempotencyKey"
+
+	// ParamCanonicalFilename is the name the package is published as (see debian.PackageInfo.CanonicalFilename)
+	ParamCanonicalFilename = "canonicalFilename"
+)
+
+// Service encapsulates the package publishing pipeline
+type Service struct {
+	RepoSvc repo.GitRepoService
+	Signer  debian.Signer
+	Cache   *cache.Manager
+}
+
+// UploadResult describes a package that was published
+type UploadResult struct {
+	debian.PackageInfo
+	debian.Target
+	Filename string `json:"filename"`
+
+	// OriginalFilename is the name the package file was uploaded as (it's published under its canonical name)
+	OriginalFilename string `json:"originalFilename,omitempty"`
+
+	// Unchanged is set when the exact same package file was already published (so nothing was committed)
+	Unchanged bool `json:"unchanged,omitempty"`
+
+	// Replaced are the package files with different content that were overwritten (or removed)
+	Replaced []string `json:"replaced,omitempty"`
+}
+
+// NewUploadJob creates a job to publish the uploaded file to the target
+func NewUploadJob(uploadFile, filename string, target debian.Target) *cache.Job {
+	return cache.NewJob(JobTypeUpload, map[string]string{
+		ParamFile:      uploadFile,
+		ParamFilename:  filename,
+		ParamSuite:     target.Suite,
+		ParamComponent: target.Component,
+	}, UploadStages...)
+}
+
+// ProcessJobs works through queued jobs until the context is cancelled.  A job that's already
+// running when the context is cancelled is still finished: run this in a goroutine tracked by a
+// WaitGroup, and wait for it when shutting down.  (If the process stops before the job finishes,
+// the job is requeued the next time it starts.)
+func (service Service) ProcessJobs(ctx context.Context) {
+	log.Info().Msg("Starting job processing...")
+
+	//	Pick up anything we were in the middle of when we last stopped
+	requeued, err := service.Cache.RequeueInterruptedJobs(ctx)
+	if err != nil {
+		log.Err(err).Msg("problem requeueing interrupted jobs")
+	} else if requeued > 0 {
+		log.Info().Int("count", requeued).Msg("Requeued interrupted jobs")
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info().Msg("Job processing stopping")
+			return
+		default:
+		}
+
+		job, err := service.Cache.DequeueJob(ctx, 5*time.Second)
+		if err != nil {
+			log.Err(err).Msg("problem getting next job")
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(5 * time.Second):
+			}
+			continue
+		}
+
+		if job == nil {
+			continue
+		}
+
+		service.runJob(context.WithoutCancel(ctx), job)
+	}
+}
+
+// runJob runs a single job and records the outcome
+func (service Service) runJob(ctx context.Context, job *cache.Job) {
+	if job.Done() {
+		//	It finished before we stopped last time -- all that's left is to tidy up
+		log.Info().Str("id", job.ID).Str("state", job.State).Msg("Completing finished job")
+		service.completeJob(ctx, job)
+		return
+	}
+
+	if job.State != cache.JobStateQueued {
+		log.Info().Str("id", job.ID).Str("state", job.State).Msg("Restarting interrupted job")
+		job.Restart()
+	}
+
+	log.Info().Str("id", job.ID).Str("type", job.Type).Msg("Running job")
+
+	var err error
+	switch job.Type {
+	case JobTypeUpload:
+		err = service.RunUpload(ctx, job)
+	default:
+		err = fmt.Errorf("unknown job type '%s'", job.Type)
+	}
+
+	job.Finish(err)
+	job.ErrorCode = ErrorCode(err)
+	if err != nil {
+		log.Err(err).Str("id", job.ID).Msg("Job failed")
+	} else {
+		log.Info().Str("id", job.ID).Str("commit", job.CommitSHA).Msg("Job complete")
+	}
+
+	service.saveJob(ctx, job)
+
+	//	If it might work next time, let a retry with the same idempotency key start a new job
+	if key := job.Params[ParamIdempotencyKey]; key != "" && Retryable(err) {
+		if err := service.Cache.ReleaseIdempotencyKey(ctx, key, job.ID); err != nil {
+			log.Err(err).Str("id", job.ID).Msg("problem releasing idempotency key")
+		}
+	}
+
+	service.completeJob(ctx, job)
+}
+
+// completeJob takes a finished job off this instance's processing list, then removes its
+// upload.  The upload is kept until then, so a job that's interrupted can be run again.
+func (service Service) completeJob(ctx context.Context, job *cache.Job) {
+	if err := service.Cache.CompleteJob(ctx, job); err != nil {
+		log.Err(err).Str("id", job.ID).Msg("problem completing job")
+		return
+	}
+
+	if uploadFile := job.Params[ParamFile]; uploadFile != "" {
+		if err := os.Remove(uploadFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Err(err).Str("id", job.ID).Str("file", uploadFile).Msg("problem removing upload")
+		}
+	}
+}
+
+// ErrorCode returns the job error code for the error, or an empty string if there isn't one
+func ErrorCode(err error) string {
+	switch {
+	case errors.Is(err, ErrLockTimeout):
+		return ErrorCodeLockTimeout
+	case errors.Is(err, ErrLockUnavailable):
+		return ErrorCodeLockUnavailable
+	case errors.Is(err, ErrVersionExists):
+		return ErrorCodeVersionExists
+	}
+
+	return ""
+}
+
+// Retryable returns true if the job error may go away if the job is tried again (like not
+// getting the repo lock in time), rather than being down to what the job was asked to do
+func Retryable(err error) bool {
+	return errors.Is(err, ErrLockTimeout) || errors.Is(err, ErrLockUnavailable)
+}
+
+// runStage runs one stage of the job, recording its progress as it goes.  The job may be nil
+// (for work that isn't tracked as a job), in which case the stage is just run.
+func (service Service) runStage(ctx context.Context, job *cache.Job, stage string, fn func() error) error {
+	if job != nil {
+		job.StartStage(stage)
+		service.saveJob(ctx, job)
+	}
+
+	err := fn()
+	if job != nil {
+		job.FinishStage(stage, err)
+		service.saveJob(ctx, job)
+	}
+
+	if err != nil {
+		return fmt.Errorf("%s failed: %w", stage, err)
+	}
+
+	return nil
+}
+
+// saveJob saves the job's progress.  Failing to save progress isn't fatal to the job.
+func (service Service) saveJob(ctx context.Context, job *cache.Job) {
+	if err := service.Cache.SaveJob(context.WithoutCancel(ctx), job); err != nil {
+		log.Err(err).Str("id", job.ID).Msg("problem saving job")
+	}
+}
+
+// RunUpload publishes the uploaded package for an upload job.  The package is moved
+// into place (under its canonical name, whatever it was uploaded as) in a repo
+// transaction, which then reindexes, signs, commits and pushes.
+// If the exact same package file is already published, nothing changes (and nothing is
+// committed).  If a different package file is published as the same version, the upload
+// fails unless the job asks to overwrite it.
+func (service Service) RunUpload(ctx context.Context, job *cache.Job) error {
+	uploadFile := job.Params[ParamFile]
+	overwrite, _ := strconv.ParseBool(job.Params[ParamOverwrite])
+	target := debian.Target{
+		Suite:     job.Params[ParamSuite],
+		Component: job.Params[ParamComponent],
+	}
+
+	packageInfo, err := debian.ReadPackageFile(uploadFile)
+	if err != nil {
+		return fmt.Errorf("error reading uploaded package: %w", err)
+	}
+
+	uploadSHA256, err := fileSHA256(uploadFile)
+	if err != nil {
+		return fmt.Errorf("error hashing uploaded package: %w", err)
+	}
+
+	//	The file is named after what's in it, not what the client called it
+	filename := packageInfo.CanonicalFilename()
+	result := UploadResult{
+		PackageInfo:      packageInfo,
+		Target:           target,
+		OriginalFilename: job.Params[ParamFilename],
+	}
+	sha, err := service.Transaction(ctx, job, target, StageMove, func(ctx context.Context, tx *Tx) error {
+		packageFolder := tx.Archive.PackageFolder(tx.Target.Component, packageInfo)
+		result.Filename = path.Join(packageFolder, filename)
+		repoFile := path.Join(tx.RepoPath, packageFolder, filename)
+
+		//	See if this version is already published
+		existing, err := publishedVersion(ctx, tx, packageInfo, result.Filename)
+		if err != nil {
+			return err
+		}
+
+		published := ""
+		replaced := []string{}
+		for _, pkg := range existing {
+			if pkg.sha256 != uploadSHA256 {
+				replaced = append(replaced, pkg.filename)
+			} else if pkg.published {
+				published = pkg.filename
+			}
+		}
+
+		if len(replaced) > 0 && !overwrite {
+			return fmt.Errorf("%w: %s %s (%s) is already published as %s with different content -- upload it with overwrite to replace it",
+				ErrVersionExists, packageInfo.Package, packageInfo.Version, packageInfo.Architecture, strings.Join(replaced, ", "))
+		}
+
+		if len(replaced) == 0 && published != "" {
+			log.Info().Str("filename", published).Msg("The same package file is already published -- nothing to do")
+			result.Filename = published
+			result.Unchanged = true
+			return ErrNoChanges
+		}
+
+		//	Anything we're replacing that the upload isn't about to be moved over goes
+		for _, file := range replaced {
+			log.Info().Str("filename", file).Msg("Overwriting package file with different content")
+			if file == result.Filename {
+				continue
+			}
+			if err := os.Remove(path.Join(tx.RepoPath, file)); err != nil {
+				return fmt.Errorf("error removing %s: %w", file, err)
+			}
+		}
+		result.Replaced = replaced
+
+		//	Move file to repo folder
+		if err := os.MkdirAll(path.Join(tx.RepoPath, packageFolder), os.ModePerm); err != nil {
+			return fmt.Errorf("error creating package folder in repo: %w", err)
+		}
+
+		log.Debug().Str("repoFile", repoFile).Msg("Moving file to the repo path")
+		if err := os.Rename(uploadFile, repoFile); err != nil {
+			return fmt.Errorf("error moving file to repo: %w", err)
+		}
+
+		tx.Added = append(tx.Added, repoFile)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	job.CommitSHA = sha
+	job.Result = result
+
+	return nil
+}
+
+// existingPackage is a package file that's already in the repo as the version being uploaded
+type existingPackage struct {
+	filename  string
+	sha256    string
+	published bool
+}
+
+// publishedVersion finds the package files already published to the transaction's target as the
+// same package, version and architecture.  The file at 'filename' (relative to the repo folder)
+// is included even if it's only published somewhere else (or is a version that differs only
+// by epoch, which shares the canonical file name), as the upload would replace it.
+func publishedVersion(ctx context.Context, tx *Tx, info debian.PackageInfo, filename string) ([]existingPackage, error) {
+	retval := []existingPackage{}
+	found := false
+
+	pkgs, err := tx.Archive.TargetPackages(ctx, tx.Target)
+	if err != nil {
+		return nil, fmt.Errorf("error reading published packages: %w", err)
+	}
+
+	for _, pkg := range pkgs {
+		if pkg.Package != info.Package || pkg.Architecture != info.Architecture || debian.CompareVersions(pkg.Version, info.Version) != 0 {
+			continue
+		}
+
+		pkgFilename := path.Clean(strings.TrimPrefix(pkg.Filename, "./"))
+		if _, err := os.Stat(path.Join(tx.RepoPath, pkgFilename)); err != nil {
+			continue
+		}
+
+		retval = append(retval, existingPackage{filename: pkgFilename, sha256: pkg.SHA256, published: true})
+		found = found || pkgFilename == filename
+	}
+
+	//	The file we'd be moving over might be published to another suite or component
+	if found {
+		return retval, nil
+	}
+	sha, err := fileSHA256(path.Join(tx.RepoPath, filename))
+	if errors.Is(err, os.ErrNotExist) {
+		return retval, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error hashing %s: %w", filename, err)
+	}
+
+	return append(retval, existingPackage{filename: filename, sha256: sha}), nil
+}
+
+// fileSHA256 returns the SHA256 of the file (hex encoded)
+func fileSHA256(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,321 @@
+package publish
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/danesparza/package-assistant/internal/cache"
+	"github.com/danesparza/package-assistant/internal/debian"
+	"github.com/go-redsync/redsync/v4"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"sync"
+	"time"
+)
+
+const (
+	StageLock = "lock"
+)
+
+var (
+	// ErrLockTimeout is returned when another operation held the repo lock for longer than we were willing to wait
+	ErrLockTimeout = errors.New("timed out waiting for the repo lock")
+
+	// ErrLockUnavailable is returned when the repo lock couldn't be reached (usually because redis is down)
+	ErrLockUnavailable = errors.New("the repo lock is unavailable")
+
+	// ErrNoChanges can be returned by a transaction's apply function to indicate there was
+	// nothing to do.  The transaction then finishes without reindexing or committing.
+	ErrNoChanges = errors.New("no changes")
+)
+
+// defaultCommitMessage is the commit message used when a transaction doesn't set one
+const defaultCommitMessage = "package repo bot commit"
+
+// lockRetryDelay is how long to wait between attempts to get the repo lock
+const lockRetryDelay = 500 * time.Millisecond
+
+// defaultLockExpiry is used when lock.expiry isn't a positive duration
+const defaultLockExpiry = time.Minute
+
+// minLockExtendInterval is the least time between extending the repo lock
+const minLockExtendInterval = 100 * time.Millisecond
+
+// workingCopy keeps readers of the working copy (like the package index) from seeing a
+// transaction part way through: transactions hold it for writing from the pull until they've
+// committed or rolled back.  Like the working copy, it's per instance.
+var workingCopy sync.RWMutex
+
+// ReadWorkingCopy holds off transactions on this instance until the returned function is
+// called, so the working copy matches its last commit while it's being read
+func ReadWorkingCopy() func() {
+	workingCopy.RLock()
+	return workingCopy.RUnlock
+}
+
+// Tx is a repo transaction in progress
+type Tx struct {
+	// RepoPath is the working copy of the package repo
+	RepoPath string
+
+	// Archive is the layout of the package repo
+	Archive debian.Archive
+
+	// Target is the suite and component any added packages are published to
+	Target debian.Target
+
+	// Added are the package files that were added by the transaction
+	Added []string
+
+	// SkipRefresh can be set when the changes don't affect the published packages,
+	// so the indexes don't need to be regenerated and signed
+	SkipRefresh bool
+
+	// Message is the commit message (if it isn't set, a generic one is used)
+	Message string
+}
+
+// Transaction runs 'apply' against the package repo while holding the distributed repo lock.
+// It acquires the lock, pulls, applies the changes, reindexes and signs, then commits and pushes.
+// The lock is extended for as long as the transaction runs, and readers of the working copy on
+// this instance wait until it's done (see ReadWorkingCopy).  If any step fails, the working
+// copy is reset to the commit we started from, so nothing half done is left behind.  If a job is passed, the progress of
+// each stage ('apply' is recorded under the applyStage name) is saved on the job as it goes.
+// The hash of the new commit is returned.
+func (service Service) Transaction(ctx context.Context, job *cache.Job, target debian.Target, applyStage string, apply func(ctx context.Context, tx *Tx) error) (string, error) {
+	//	Get configs
+	gitName := viper.GetString("git.name")
+	gitEmail := viper.GetString("git.email")
+	githubUser := viper.GetString("github.user")
+	githubPassword := viper.GetString("github.password")
+
+	tx := &Tx{
+		RepoPath: viper.GetString("github.projectfolder"),
+		Archive:  debian.ArchiveFromConfig(),
+		Target:   target,
+	}
+
+	//	Get the repo lock (and keep it until we're done)
+	txCtx, release, err := service.acquireLock(ctx, job)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	//	Keep readers out until we've committed or rolled back
+	workingCopy.Lock()
+	defer workingCopy.Unlock()
+
+	//	ci-pre.sh (switch to repo folder and git pull)
+	base := ""
+	err = service.runStage(txCtx, job, StagePull, func() error {
+		log.Debug().Msg("Performing a repo pull")
+		if err := service.RepoSvc.Pull(); err != nil {
+			return fmt.Errorf("error refreshing repo: %w", err)
+		}
+
+		//	Remember where we started, so we can get back there if anything goes wrong
+		var err error
+		base, err = service.RepoSvc.Head()
+		return err
+	})
+	if err != nil {
+		service.rollback("")
+		return "", err
+	}
+
+	//	Make the changes
+	noChanges := false
+	err = service.runStage(txCtx, job, applyStage, func() error {
+		err := apply(txCtx, tx)
+		if errors.Is(err, ErrNoChanges) {
+			noChanges = true
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		service.rollback(base)
+		return "", err
+	}
+
+	if noChanges {
+		log.Debug().Msg("Nothing changed -- skipping refresh and commit")
+		return "", nil
+	}
+
+	//  ci-refresh.sh / refresh-packages.sh (build the indexes and sign them)
+	err = service.runStage(txCtx, job, StageRefresh, func() error {
+		if tx.SkipRefresh {
+			log.Debug().Msg("Packages unchanged -- skipping refresh")
+			return nil
+		}
+
+		log.Debug().Msg("Refreshing packages")
+		if err := debian.RefreshPackages(txCtx, service.Signer, tx.Archive, tx.Target, tx.Added...); err != nil {
+			return fmt.Errorf("error refreshing packages: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		service.rollback(base)
+		return "", err
+	}
+
+	//	ci-post.sh (git add / git commit / git push)
+	sha := ""
+	err = service.runStage(txCtx, job, StageCommit, func() error {
+		if err := txCtx.Err(); err != nil {
+			return fmt.Errorf("not committing: %w", err)
+		}
+
+		log.Debug().Msg("Adding all changes and preparing to commit")
+		if err := service.RepoSvc.AddAll(); err != nil {
+			return fmt.Errorf("error adding changes in repo: %w", err)
+		}
+
+		log.Debug().Msg("Committing and pushing changes")
+		var err error
+		message := tx.Message
+		if message == "" {
+			message = defaultCommitMessage
+		}
+
+		sha, err = service.RepoSvc.CommitAndPush(message, githubUser, githubPassword, gitName, gitEmail)
+		if err != nil {
+			return fmt.Errorf("error committing and pushing: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		service.rollback(base)
+		return "", err
+	}
+
+	return sha, nil
+}
+
+// WithLock runs fn while holding the repo lock, without pulling, reindexing or committing.  It's
+// for work that has to stay out of the way of transactions, like checking or tidying the working copy.
+func (service Service) WithLock(ctx context.Context, job *cache.Job, fn func(ctx context.Context) error) error {
+	lockCtx, release, err := service.acquireLock(ctx, job)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return fn(lockCtx)
+}
+
+// acquireLock gets the repo lock (as the lock stage of the job, if there is one) and keeps
+// extending it until the returned release function is called.  If the lock is lost, the
+// returned context is canceled, so we stop what we're doing rather than racing whoever
+// picks it up next.
+func (service Service) acquireLock(ctx context.Context, job *cache.Job) (context.Context, func(), error) {
+	var mutex *redsync.Mutex
+	err := service.runStage(ctx, job, StageLock, func() error {
+		var err error
+		mutex, err = service.lock(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lockCtx, cancel := context.WithCancel(ctx)
+
+	extended := make(chan struct{})
+	go func() {
+		defer close(extended)
+		service.extendLock(lockCtx, mutex, cancel)
+	}()
+
+	release := func() {
+		cancel()
+		<-extended
+
+		// Release the lock so other processes or threads can obtain a lock.
+		if ok, err := mutex.UnlockContext(context.Background()); !ok || err != nil {
+			log.Err(err).Msg("problem releasing lock")
+		}
+	}
+
+	return lockCtx, release, nil
+}
+
+// rollback resets the working copy to the given commit (or HEAD), throwing away anything
+// a failed transaction left behind -- moved packages, regenerated indexes and signatures,
+// or a commit that never made it to the remote
+func (service Service) rollback(commit string) {
+	log.Info().Str("commit", commit).Msg("Rolling back repo changes")
+	if err := service.RepoSvc.Reset(commit); err != nil {
+		log.Err(err).Str("commit", commit).Msg("problem rolling back repo changes")
+	}
+}
+
+// lock waits (up to lock.timeout) to get the repo lock
+func (service Service) lock(ctx context.Context) (*redsync.Mutex, error) {
+	timeout := viper.GetDuration("lock.timeout")
+	tries := int(timeout/lockRetryDelay) + 1
+	if tries < 1 {
+		tries = 1
+	}
+
+	mutex := service.Cache.RS.NewMutex(cache.PACKAGE_ASSISTANT_LOCK,
+		redsync.WithExpiry(lockExpiry()),
+		redsync.WithTries(tries),
+		redsync.WithRetryDelay(lockRetryDelay),
+	)
+
+	log.Debug().Dur("timeout", timeout).Msg("Waiting for repo lock")
+	err := mutex.LockContext(ctx)
+	if err != nil {
+		var redisErr *redsync.RedisError
+		if errors.As(err, &redisErr) {
+			return nil, fmt.Errorf("%w: %v", ErrLockUnavailable, err)
+		}
+		return nil, fmt.Errorf("%w after %v: %v", ErrLockTimeout, timeout, err)
+	}
+
+	return mutex, nil
+}
+
+// lockExpiry returns how long the repo lock lasts before it has to be extended (lock.expiry)
+func lockExpiry() time.Duration {
+	expiry := viper.GetDuration("lock.expiry")
+	if expiry <= 0 {
+		log.Warn().Str("lock.expiry", viper.GetString("lock.expiry")).Dur("default", defaultLockExpiry).Msg("lock.expiry isn't a positive duration -- using the default")
+		return defaultLockExpiry
+	}
+
+	return expiry
+}
+
+// extendLock keeps extending the lock until the context is done.  If the lock
+// can't be extended, 'lost' is called.
+func (service Service) extendLock(ctx context.Context, mutex *redsync.Mutex, lost context.CancelFunc) {
+	interval := lockExpiry() / 3
+	if interval < minLockExtendInterval {
+		interval = minLockExtendInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if ok, err := mutex.ExtendContext(ctx); !ok || err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				log.Err(err).Msg("problem extending repo lock -- abandoning transaction")
+				lost()
+				return
+			}
+			log.Debug().Time("until", mutex.Until()).Msg("Extended repo lock")
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package publish
+
+import (
+	"context"
+	"errors"
+	"github.com/danesparza/package-assistant/internal/cache"
+	"github.com/danesparza/package-assistant/internal/debian"
+	"github.com/danesparza/package-assistant/internal/files"
+	"github.com/danesparza/package-assistant/internal/repo"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-redsync/redsync/v4"
+	redsyncredis "github.com/go-redsync/redsync/v4/redis"
+	"github.com/spf13/viper"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testLockPool hands out the repo lock to whoever asks for it, so transactions can run without redis
+type testLockPool struct{}
+
+func (testLockPool) Get(ctx context.Context) (redsyncredis.Conn, error) {
+	return testLockConn{}, nil
+}
+
+// testLockConn is a connection to testLockPool: every lock, extend and release succeeds
+type testLockConn struct{}
+
+func (testLockConn) Get(name string) (string, error)             { return "", nil }
+func (testLockConn) Set(name string, value string) (bool, error) { return true, nil }
+func (testLockConn) SetNX(name string, value string, expiry time.Duration) (bool, error) {
+	return true, nil
+}
+func (testLockConn) Eval(script *redsyncredis.Script, keysAndArgs ...interface{}) (interface{}, error) {
+	return int64(1), nil
+}
+func (testLockConn) PTTL(name string) (time.Duration, error) { return time.Minute, nil }
+func (testLockConn) Close() error                            { return nil }
+
+// testSigner makes placeholder signatures, or fails if it's been given an error
+type testSigner struct {
+	err error
+}
+
+func (s testSigner) DetachSign(ctx context.Context, data []byte) ([]byte, error) {
+	return []byte("detached signature\n"), s.err
+}
+
+func (s testSigner) ClearSign(ctx context.Context, data []byte) ([]byte, error) {
+	return append([]byte("clearsigned\n"), data...), s.err
+}
+
+// testRepo creates a bare remote with one commit (a README and a Release file), clones it,
+// and points the config at the clone.  It returns the service for the clone, and the folders
+// of the clone and the remote.
+func testRepo(t *testing.T) (Service, string, string) {
+	t.Helper()
+
+	seed := filepath.Join(t.TempDir(), "seed")
+	remote := filepath.Join(t.TempDir(), "remote.git")
+	folder := filepath.Join(t.TempDir(), "repo")
+
+	seedRepo, err := git.PlainInit(seed, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"README.md": "packages\n", "Release": "Origin: Example\n"} {
+		if err := os.WriteFile(filepath.Join(seed, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w, err := seedRepo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddWithOptions(&git.AddOptions{All: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Commit("first", &git.CommitOptions{Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := git.PlainClone(remote, true, &git.CloneOptions{URL: seed}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := git.PlainClone(folder, false, &git.CloneOptions{URL: remote})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	viper.Set("github.projectfolder", folder)
+	viper.Set("repo.layout", debian.LayoutFlat)
+	t.Cleanup(viper.Reset)
+
+	return Service{
+		RepoSvc: repo.NewGitRepoService(remote, folder, r),
+		Signer:  testSigner{},
+		Cache:   &cache.Manager{RS: redsync.New(testLockPool{})},
+	}, folder, remote
+}
+
+func TestTransactionRollback(t *testing.T) {
+	tests := []struct {
+		name      string
+		signErr   error
+		apply     func(remote string) error
+		wantStage string
+	}{
+		{
+			name: "apply fails",
+			apply: func(remote string) error {
+				return errors.New("bad package")
+			},
+			wantStage: "move failed",
+		},
+		{
+			name:      "refresh fails",
+			signErr:   errors.New("signer is down"),
+			apply:     func(remote string) error { return nil },
+			wantStage: "refresh failed",
+		},
+		{
+			name: "commit fails",
+			apply: func(remote string) error {
+				//	The commit is made, but it can't be pushed
+				return os.RemoveAll(remote)
+			},
+			wantStage: "commit failed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, folder, remote := testRepo(t)
+			service.Signer = testSigner{err: tt.signErr}
+
+			base, err := service.RepoSvc.Head()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = service.Transaction(context.Background(), nil, debian.Target{}, StageMove, func(ctx context.Context, tx *Tx) error {
+				//	Change a tracked file, and leave an untracked file and folder behind
+				if err := os.WriteFile(filepath.Join(folder, "README.md"), []byte("changed\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.MkdirAll(filepath.Join(folder, "pool", "main"), os.ModePerm); err != nil {
+					t.Fatal(err)
+				}
+				if err := files.Copy(filepath.Join("..", "debian", "testdata", "foo_1.0_amd64.deb"), filepath.Join(folder, "pool", "main", "foo_1.0_amd64.deb"), 0644); err != nil {
+					t.Fatal(err)
+				}
+
+				return tt.apply(remote)
+			})
+			if err == nil || !strings.Contains(err.Error(), tt.wantStage) {
+				t.Fatalf("Transaction() error = %v, want '%s'", err, tt.wantStage)
+			}
+
+			//	Back where it started, with nothing left over
+			head, err := service.RepoSvc.Head()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if head != base {
+				t.Errorf("HEAD is %s after the rollback, want %s", head, base)
+			}
+
+			readme, err := os.ReadFile(filepath.Join(folder, "README.md"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(readme) != "packages\n" {
+				t.Errorf("README.md is %q after the rollback, want the committed version", readme)
+			}
+
+			for _, name := range []string{"pool", "Packages", "Packages.gz", "InRelease", "Release.gpg"} {
+				if _, err := os.Stat(filepath.Join(folder, name)); !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("%s is still there after the rollback", name)
+				}
+			}
+
+			r, err := git.PlainOpen(folder)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w, err := r.Worktree()
+			if err != nil {
+				t.Fatal(err)
+			}
+			status, err := w.Status()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !status.IsClean() {
+				t.Errorf("working copy isn't clean after the rollback:\n%s", status)
+			}
+		})
+	}
+}
@@ -6,12 +6,15 @@ import (
 	"fmt"
 	"github.com/danesparza/package-assistant/internal/files"
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
 	"github.com/go-git/go-git/v5/plumbing/transport/http"
 	"github.com/rs/zerolog/log"
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -25,7 +28,11 @@ type GitRepoService interface {
 	Pull() error
 	AddFile(srcFile string) error
 	AddAll() error
-	CommitAndPush(username, password, gitName, gitEmail string) error
+	CommitAndPush(message, username, password, gitName, gitEmail string) (string, error)
+	Head() (string, error)
+	Reset(commit string) error
+	FileTimes(files []string) (map[string]time.Time, error)
+	GC(grace time.Duration) (int, error)
 }
 
 func NewGitRepoService(projectURL, projectFolder string, gitrepo *git.Repository) GitRepoService {
@@ -139,16 +146,16 @@ func (g gitRepoService) AddAll() error {
 	return nil
 }
 
-// CommitAndPush commits the changes and pushes to the remote
-func (g gitRepoService) CommitAndPush(username, password, gitName, gitEmail string) error {
+// CommitAndPush commits the changes with the message and pushes to the remote.  It returns the hash of the new commit.
+func (g gitRepoService) CommitAndPush(message, username, password, gitName, gitEmail string) (string, error) {
 	// Get the working directory for the repository
 	w, err := g.Repository.Worktree()
 	if err != nil {
-		return fmt.Errorf("problem getting working tree when committing: %w", err)
+		return "", fmt.Errorf("problem getting working tree when committing: %w", err)
 	}
 
 	//	Commit the file(s)
-	_, err = w.Commit("package repo bot commit", &git.CommitOptions{
+	hash, err := w.Commit(message, &git.CommitOptions{
 		Author: &object.Signature{
 			Name:  gitName,
 			Email: gitEmail,
@@ -156,7 +163,7 @@ func (g gitRepoService) CommitAndPush(username, password, gitName, gitEmail stri
 		},
 	})
 	if err != nil {
-		return fmt.Errorf("problem committing: %w", err)
+		return "", fmt.Errorf("problem committing: %w", err)
 	}
 
 	//	Push
@@ -168,8 +175,142 @@ func (g gitRepoService) CommitAndPush(username, password, gitName, gitEmail stri
 		Progress: os.Stdout,
 	})
 	if err != nil {
-		return fmt.Errorf("problem pushing: %w", err)
+		return "", fmt.Errorf("problem pushing: %w", err)
+	}
+
+	return hash.String(), nil
+}
+
+// Head returns the hash of the commit currently checked out
+func (g gitRepoService) Head() (string, error) {
+	ref, err := g.Repository.Head()
+	if err != nil {
+		return "", fmt.Errorf("problem getting HEAD: %w", err)
+	}
+
+	return ref.Hash().String(), nil
+}
+
+// Reset throws away all changes in the working tree (including untracked files) and
+// resets the current branch to the given commit.  If commit is empty, HEAD is used.
+func (g gitRepoService) Reset(commit string) error {
+	// Get the working directory for the repository
+	w, err := g.Repository.Worktree()
+	if err != nil {
+		return fmt.Errorf("problem getting working tree when resetting: %w", err)
+	}
+
+	opts := &git.ResetOptions{Mode: git.HardReset}
+	if commit != "" {
+		opts.Commit = plumbing.NewHash(commit)
+	}
+
+	//	Reset tracked files
+	err = w.Reset(opts)
+	if err != nil {
+		return fmt.Errorf("problem resetting: %w", err)
+	}
+
+	//	Remove untracked files
+	err = w.Clean(&git.CleanOptions{Dir: true})
+	if err != nil {
+		return fmt.Errorf("problem cleaning: %w", err)
 	}
 
 	return nil
 }
+
+// FileTimes finds the time of the most recent commit that changed each of the files (given
+// relative to the repo folder).  Files that aren't in the history are left out of the results.
+func (g gitRepoService) FileTimes(files []string) (map[string]time.Time, error) {
+	retval := make(map[string]time.Time)
+
+	//	The paths we're looking for, and the name they were asked for by
+	wanted := make(map[string]string)
+	for _, file := range files {
+		wanted[strings.TrimPrefix(path.Clean(filepath.ToSlash(file)), "/")] = file
+	}
+
+	head, err := g.Repository.Head()
+	if err != nil {
+		return nil, fmt.Errorf("problem getting HEAD: %w", err)
+	}
+
+	commits, err := g.Repository.Log(&git.LogOptions{From: head.Hash()})
+	if err != nil {
+		return nil, fmt.Errorf("problem getting the commit log: %w", err)
+	}
+	defer commits.Close()
+
+	//	Walk back through the history until we've found every file
+	err = commits.ForEach(func(commit *object.Commit) error {
+		if len(wanted) == 0 {
+			return storer.ErrStop
+		}
+
+		tree, err := commit.Tree()
+		if err != nil {
+			return fmt.Errorf("problem getting tree for commit %s: %w", commit.Hash, err)
+		}
+
+		var parentTree *object.Tree
+		if commit.NumParents() > 0 {
+			parent, err := commit.Parent(0)
+			if err != nil {
+				return fmt.Errorf("problem getting parent of commit %s: %w", commit.Hash, err)
+			}
+
+			parentTree, err = parent.Tree()
+			if err != nil {
+				return fmt.Errorf("problem getting tree for commit %s: %w", parent.Hash, err)
+			}
+		}
+
+		changes, err := object.DiffTree(parentTree, tree)
+		if err != nil {
+			return fmt.Errorf("problem getting changes in commit %s: %w", commit.Hash, err)
+		}
+
+		for _, change := range changes {
+			name := change.To.Name
+			if name == "" {
+				name = change.From.Name
+			}
+
+			if file, ok := wanted[name]; ok {
+				retval[file] = commit.Committer.When
+				delete(wanted, name)
+			}
+		}
+
+		return nil
+	})
+	if err != nil && !errors.Is(err, storer.ErrStop) {
+		return nil, err
+	}
+
+	return retval, nil
+}
+
+// GC tidies up the object store, like 'git gc': unreachable loose objects older than 'grace'
+// are removed, then everything reachable is packed into a single pack file (which also removes
+// the loose copies).  It returns the number of unreachable objects removed.
+func (g gitRepoService) GC(grace time.Duration) (int, error) {
+	removed := 0
+	err := g.Repository.Prune(git.PruneOptions{
+		OnlyObjectsOlderThan: time.Now().Add(-grace),
+		Handler: func(hash plumbing.Hash) error {
+			removed++
+			return g.Repository.DeleteObject(hash)
+		},
+	})
+	if err != nil {
+		return removed, fmt.Errorf("problem removing unreachable objects: %w", err)
+	}
+
+	if err := g.Repository.RepackObjects(&git.RepackConfig{}); err != nil {
+		return removed, fmt.Errorf("problem repacking objects: %w", err)
+	}
+
+	return removed, nil
+}
@@ -0,0 +1,126 @@
+package retention
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/danesparza/package-assistant/internal/debian"
+	"github.com/spf13/viper"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ErrPinNotFound is returned when removing a pin that doesn't exist
+var ErrPinNotFound = errors.New("pin not found")
+
+// Pin protects a version of a package (for every architecture) from being pruned
+type Pin struct {
+	Package string    `json:"package"`
+	Version string    `json:"version"`
+	Reason  string    `json:"reason,omitempty"`
+	Pinned  time.Time `json:"pinned"`
+}
+
+// Pins are the pinned package versions.  They're kept in a file in the package repo,
+// so they're versioned along with the packages they protect.
+type Pins struct {
+	Pins []Pin `json:"pins"`
+}
+
+// PinsFile returns the path of the pins file in the repo folder
+func PinsFile(repoFolder string) string {
+	return filepath.Join(repoFolder, filepath.FromSlash(viper.GetString("retention.pinsfile")))
+}
+
+// ReadPins reads the pins file.  A missing file means nothing is pinned.
+func ReadPins(file string) (Pins, error) {
+	retval := Pins{Pins: []Pin{}}
+
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return retval, nil
+	}
+	if err != nil {
+		return retval, fmt.Errorf("problem reading pins file: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &retval); err != nil {
+		return retval, fmt.Errorf("problem parsing pins file %s: %w", file, err)
+	}
+
+	return retval, nil
+}
+
+// WritePins writes the pins file (sorted, so changes make for tidy diffs)
+func WritePins(file string, pins Pins) error {
+	sort.SliceStable(pins.Pins, func(i, j int) bool {
+		if pins.Pins[i].Package != pins.Pins[j].Package {
+			return pins.Pins[i].Package < pins.Pins[j].Package
+		}
+		return debian.CompareVersions(pins.Pins[i].Version, pins.Pins[j].Version) < 0
+	})
+
+	data, err := json.MarshalIndent(pins, "", "  ")
+	if err != nil {
+		return fmt.Errorf("problem serializing pins: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), os.ModePerm); err != nil {
+		return fmt.Errorf("problem creating pins folder: %w", err)
+	}
+
+	if err := os.WriteFile(file, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("problem writing pins file: %w", err)
+	}
+
+	return nil
+}
+
+// Find returns the pin for the package version, if there is one
+func (p Pins) Find(pkg, version string) (Pin, bool) {
+	for _, pin := range p.Pins {
+		if pin.Package == pkg && debian.CompareVersions(pin.Version, version) == 0 {
+			return pin, true
+		}
+	}
+
+	return Pin{}, false
+}
+
+// IsPinned returns true if the package version is pinned
+func (p Pins) IsPinned(pkg, version string) bool {
+	_, found := p.Find(pkg, version)
+	return found
+}
+
+// Add pins the package version, replacing any existing pin for it.  It returns
+// false if the same pin already existed.
+func (p *Pins) Add(pin Pin) bool {
+	for i, existing := range p.Pins {
+		if existing.Package == pin.Package && debian.CompareVersions(existing.Version, pin.Version) == 0 {
+			if existing.Reason == pin.Reason {
+				return false
+			}
+			pin.Pinned = existing.Pinned
+			p.Pins[i] = pin
+			return true
+		}
+	}
+
+	p.Pins = append(p.Pins, pin)
+	return true
+}
+
+// Remove unpins the package version
+func (p *Pins) Remove(pkg, version string) error {
+	for i, existing := range p.Pins {
+		if existing.Package == pkg && debian.CompareVersions(existing.Version, version) == 0 {
+			p.Pins = append(p.Pins[:i], p.Pins[i+1:]...)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %s %s", ErrPinNotFound, pkg, version)
+}
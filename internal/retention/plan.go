@@ -0,0 +1,393 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"github.com/danesparza/package-assistant/internal/debian"
+	"github.com/rs/zerolog/log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Candidate is a published package file that the retention policy is applied to
+type Candidate struct {
+	debian.PackageFile
+	debian.Target
+
+	// Added is when the file was added to the repo
+	Added time.Time
+}
+
+// Decision is the outcome of applying the retention policy to a candidate
+type Decision struct {
+	Filename     string    `json:"filename"`
+	Package      string    `json:"package"`
+	Version      string    `json:"version"`
+	Architecture string    `json:"architecture"`
+	Suite        string    `json:"suite,omitempty"`
+	Component    string    `json:"component,omitempty"`
+	Added        time.Time `json:"added"`
+	Keep         bool      `json:"keep"`
+	Pinned       bool      `json:"pinned,omitempty"`
+	Rule         string    `json:"rule"`
+	Reason       string    `json:"reason"`
+}
+
+// Plan is the result of applying the retention policy to the whole repo
+type Plan struct {
+	// Decisions are what the policy decided for each package file in each suite and component
+	Decisions []Decision `json:"decisions"`
+
+	// Remove are the package files (relative to the repo folder) that no suite or component wants to keep
+	Remove []string `json:"remove"`
+}
+
+// FileTimesFunc finds when each of the files (relative to the repo folder) was added to the repo
+type FileTimesFunc func(files []string) (map[string]time.Time, error)
+
+// NewPlan works out which package files in the archive the policy keeps and which it removes.
+// Versions pinned in the archive's pins file are always kept.  If the policy uses package
+// ages, fileTimes is used to find when files were added (falling back to the file
+// modification time).
+func NewPlan(ctx context.Context, archive debian.Archive, policy Policy, fileTimes FileTimesFunc, now time.Time) (Plan, error) {
+	candidates, err := Candidates(ctx, archive)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	pins, err := ReadPins(PinsFile(archive.Folder))
+	if err != nil {
+		return Plan{}, err
+	}
+
+	if policy.UsesAge() {
+		if err := addTimes(archive, candidates, fileTimes); err != nil {
+			return Plan{}, err
+		}
+	}
+
+	return policy.Evaluate(candidates, pins, now), nil
+}
+
+// Candidates finds every package file in the archive.  In the pool layout, there's a candidate for each suite
+// and component a file is published to (plus one with no target for files in the pool that aren't published).
+func Candidates(ctx context.Context, archive debian.Archive) ([]Candidate, error) {
+	retval := []Candidate{}
+
+	//	Everything in the repo
+	all, err := debian.ScanPackages(ctx, archive.Folder)
+	if err != nil {
+		return nil, err
+	}
+
+	if !archive.IsPool() {
+		for _, pkg := range all {
+			retval = append(retval, Candidate{PackageFile: pkg})
+		}
+		return retval, nil
+	}
+
+	//	What's published to each suite and component
+	published := make(map[string]bool)
+	for _, target := range archive.Targets() {
+		pkgs, err := archive.TargetPackages(ctx, target)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, pkg := range pkgs {
+			filename := normalizeFilename(pkg.Filename)
+			if _, err := os.Stat(filepath.Join(archive.Folder, filename)); err != nil {
+				continue
+			}
+
+			published[filename] = true
+			retval = append(retval, Candidate{PackageFile: pkg, Target: target})
+		}
+	}
+
+	//	And anything left over in the pool
+	for _, pkg := range all {
+		if !published[normalizeFilename(pkg.Filename)] {
+			retval = append(retval, Candidate{PackageFile: pkg})
+		}
+	}
+
+	return retval, nil
+}
+
+// addTimes fills in when each candidate was added
+func addTimes(archive debian.Archive, candidates []Candidate, fileTimes FileTimesFunc) error {
+	files := []string{}
+	for _, candidate := range candidates {
+		files = append(files, normalizeFilename(candidate.Filename))
+	}
+
+	times := map[string]time.Time{}
+	if fileTimes != nil {
+		var err error
+		times, err = fileTimes(files)
+		if err != nil {
+			return fmt.Errorf("problem finding when packages were added: %w", err)
+		}
+	}
+
+	for i := range candidates {
+		filename := normalizeFilename(candidates[i].Filename)
+		if added, ok := times[filename]; ok {
+			candidates[i].Added = added
+			continue
+		}
+
+		//	Not committed yet -- go by the file itself
+		if stat, err := os.Stat(filepath.Join(archive.Folder, filename)); err == nil {
+			candidates[i].Added = stat.ModTime()
+		}
+	}
+
+	return nil
+}
+
+// Evaluate applies the policy to the candidates.  Each package and architecture is considered
+// separately in each suite and component.  Pinned versions are always kept, and don't count
+// towards the number of versions to keep.  A file is only removed if none of the suites or
+// components it's published to keeps it.
+func (p Policy) Evaluate(candidates []Candidate, pins Pins, now time.Time) Plan {
+	retval := Plan{Decisions: []Decision{}, Remove: []string{}}
+
+	//	Group the versions of each package
+	groups := make(map[string][]Candidate)
+	keys := []string{}
+	for _, candidate := range candidates {
+		key := strings.Join([]string{candidate.Suite, candidate.Component, candidate.Package, candidate.Architecture}, "\x00")
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], candidate)
+	}
+	sort.Strings(keys)
+
+	kept := make(map[string]bool)
+	files := []string{}
+	for _, key := range keys {
+		group := groups[key]
+
+		//	Newest first
+		sort.SliceStable(group, func(i, j int) bool {
+			return debian.CompareVersions(group[i].Version, group[j].Version) > 0
+		})
+
+		rule, ruleName := p.RuleFor(group[0].Suite, group[0].Component, group[0].Package)
+		seenSeries := make(map[string]bool)
+
+		rank := 0
+		for _, candidate := range group {
+			pinned := pins.IsPinned(candidate.Package, candidate.Version)
+			keep, reason := rule.decide(candidate, rank, pinned, seenSeries, now)
+			if !pinned {
+				rank++
+			}
+
+			decision := Decision{
+				Filename:     normalizeFilename(candidate.Filename),
+				Package:      candidate.Package,
+				Version:      candidate.Version,
+				Architecture: candidate.Architecture,
+				Suite:        candidate.Suite,
+				Component:    candidate.Component,
+				Added:        candidate.Added,
+				Keep:         keep,
+				Pinned:       pinned,
+				Rule:         ruleName,
+				Reason:       reason,
+			}
+			retval.Decisions = append(retval.Decisions, decision)
+
+			if _, ok := kept[decision.Filename]; !ok {
+				files = append(files, decision.Filename)
+			}
+			kept[decision.Filename] = kept[decision.Filename] || keep
+		}
+	}
+
+	for _, file := range files {
+		if !kept[file] {
+			retval.Remove = append(retval.Remove, file)
+		}
+	}
+	sort.Strings(retval.Remove)
+
+	//	Files are only removed if nothing keeps them
+	for i, decision := range retval.Decisions {
+		if !decision.Keep && kept[decision.Filename] {
+			retval.Decisions[i].Keep = true
+			retval.Decisions[i].Reason = "kept for another suite or component (" + decision.Reason + ")"
+		}
+	}
+
+	return retval
+}
+
+// PackageSummary is what the plan decided for the versions of a package in a suite and component
+type PackageSummary struct {
+	Package      string            `json:"package"`
+	Architecture string            `json:"architecture"`
+	Suite        string            `json:"suite,omitempty"`
+	Component    string            `json:"component,omitempty"`
+	Rule         string            `json:"rule"`
+	Keep         []VersionDecision `json:"keep"`
+	Remove       []VersionDecision `json:"remove"`
+}
+
+// VersionDecision is what the plan decided for one version of a package
+type VersionDecision struct {
+	Version  string    `json:"version"`
+	Filename string    `json:"filename"`
+	Added    time.Time `json:"added,omitempty"`
+	Pinned   bool      `json:"pinned,omitempty"`
+	Reason   string    `json:"reason"`
+}
+
+// Summary groups the plan's decisions by package, architecture, suite and component
+func (p Plan) Summary() []PackageSummary {
+	retval := []PackageSummary{}
+
+	index := make(map[string]int)
+	for _, decision := range p.Decisions {
+		key := strings.Join([]string{decision.Suite, decision.Component, decision.Package, decision.Architecture}, "\x00")
+		i, ok := index[key]
+		if !ok {
+			i = len(retval)
+			index[key] = i
+			retval = append(retval, PackageSummary{
+				Package:      decision.Package,
+				Architecture: decision.Architecture,
+				Suite:        decision.Suite,
+				Component:    decision.Component,
+				Rule:         decision.Rule,
+				Keep:         []VersionDecision{},
+				Remove:       []VersionDecision{},
+			})
+		}
+
+		version := VersionDecision{
+			Version:  decision.Version,
+			Filename: decision.Filename,
+			Added:    decision.Added,
+			Pinned:   decision.Pinned,
+			Reason:   decision.Reason,
+		}
+
+		if decision.Keep {
+			retval[i].Keep = append(retval[i].Keep, version)
+		} else {
+			retval[i].Remove = append(retval[i].Remove, version)
+		}
+	}
+
+	return retval
+}
+
+// decide works out whether the rule keeps the candidate, which is the i'th newest (unpinned) version
+// of its package.  seenSeries tracks the version series that already have a newer version.
+func (r Rule) decide(candidate Candidate, i int, pinned bool, seenSeries map[string]bool, now time.Time) (bool, string) {
+	keep := false
+	reason := ""
+
+	//	Note the series, even if something else keeps this version
+	series := ""
+	newestInSeries := false
+	if r.KeepLatest != "" {
+		series = versionSeries(candidate.Version, r.KeepLatest)
+		newestInSeries = !seenSeries[series]
+		seenSeries[series] = true
+	}
+
+	switch {
+	case pinned:
+		keep, reason = true, "pinned"
+	case r.IsZero():
+		keep, reason = true, "no retention rule applies"
+	case r.Keep > 0 && i < r.Keep:
+		keep, reason = true, fmt.Sprintf("one of the %d newest versions", r.Keep)
+	case r.NewerThan > 0 && !candidate.Added.IsZero() && now.Sub(candidate.Added) < r.NewerThan:
+		keep, reason = true, fmt.Sprintf("added less than %v ago", r.NewerThan)
+	case newestInSeries:
+		keep, reason = true, fmt.Sprintf("newest version in the %s series", series)
+	default:
+		reason = "not kept by any part of the rule: " + r.Describe()
+	}
+
+	return keep, reason
+}
+
+// versionSeries returns the major (epoch:major) or minor (epoch:major.minor) series of the version
+func versionSeries(version, level string) string {
+	v, err := debian.ParseVersion(version)
+	if err != nil {
+		return version
+	}
+
+	//	Pull out the leading numeric components of the upstream version
+	parts := []string{}
+	for _, field := range strings.FieldsFunc(v.Upstream, func(r rune) bool { return r < '0' || r > '9' }) {
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			break
+		}
+		parts = append(parts, strconv.Itoa(n))
+		if len(parts) == 2 {
+			break
+		}
+	}
+
+	count := 1
+	if level == SeriesMinor {
+		count = 2
+	}
+	for len(parts) < count {
+		parts = append(parts, "0")
+	}
+
+	retval := strings.Join(parts[:count], ".")
+	if v.Epoch > 0 {
+		retval = strconv.Itoa(v.Epoch) + ":" + retval
+	}
+
+	return retval
+}
+
+// normalizeFilename returns the filename relative to the repo folder, without a leading './'
+func normalizeFilename(filename string) string {
+	return strings.TrimPrefix(filepath.ToSlash(filepath.Clean(filename)), "/")
+}
+
+// LogPlan logs what the plan decided for each file
+func LogPlan(plan Plan) {
+	for _, decision := range plan.Decisions {
+		event := log.Debug()
+		if !decision.Keep {
+			event = log.Info()
+		}
+
+		action := "Keeping"
+		if !decision.Keep {
+			action = "Removing"
+		}
+
+		event.
+			Str("filename", decision.Filename).
+			Str("package", decision.Package).
+			Str("version", decision.Version).
+			Str("architecture", decision.Architecture).
+			Str("suite", decision.Suite).
+			Str("component", decision.Component).
+			Str("rule", decision.Rule).
+			Str("reason", decision.Reason).
+			Msg(action + " package file")
+	}
+}
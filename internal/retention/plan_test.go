@@ -0,0 +1,149 @@
+package retention
+
+import (
+	"fmt"
+	"github.com/danesparza/package-assistant/internal/debian"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testNow is when the test plans are made
+var testNow = time.Date(2024, time.March, 5, 12, 0, 0, 0, time.UTC)
+
+// candidate is a published package file for the tests, added 'age' before testNow
+func candidate(suite, pkg, version, arch string, age time.Duration) Candidate {
+	return Candidate{
+		PackageFile: debian.PackageFile{
+			PackageInfo: debian.PackageInfo{Package: pkg, Version: version, Architecture: arch},
+			Filename:    fmt.Sprintf("./pool/main/%s_%s_%s.deb", pkg, version, arch),
+		},
+		Target: debian.Target{Suite: suite, Component: "main"},
+		Added:  testNow.Add(-age),
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	day := 24 * time.Hour
+	versions := []Candidate{
+		candidate("stable", "foo", "1.9", "amd64", 4*day),
+		candidate("stable", "foo", "1.10~rc1", "amd64", 3*day),
+		candidate("stable", "foo", "1.2", "amd64", 5*day),
+		candidate("stable", "foo", "1.10", "amd64", 2*day),
+	}
+
+	tests := []struct {
+		name       string
+		policy     Policy
+		candidates []Candidate
+		pins       []Pin
+		wantRemove []string
+		wantPinned []string
+	}{
+		{
+			name:       "no rule keeps everything",
+			policy:     Policy{},
+			candidates: versions,
+			wantRemove: []string{},
+		},
+		{
+			name:       "keeps the newest in dpkg order",
+			policy:     Policy{Scope: Scope{Rule: Rule{Keep: 2}}},
+			candidates: versions,
+			wantRemove: []string{"pool/main/foo_1.2_amd64.deb", "pool/main/foo_1.9_amd64.deb"},
+		},
+		{
+			name:       "pinned versions are kept and don't count",
+			policy:     Policy{Scope: Scope{Rule: Rule{Keep: 1}}},
+			candidates: versions,
+			pins:       []Pin{{Package: "foo", Version: "1.2"}},
+			wantRemove: []string{"pool/main/foo_1.10~rc1_amd64.deb", "pool/main/foo_1.9_amd64.deb"},
+			wantPinned: []string{"pool/main/foo_1.2_amd64.deb"},
+		},
+		{
+			name:       "pins match equivalent versions",
+			policy:     Policy{Scope: Scope{Rule: Rule{Keep: 1}}},
+			candidates: versions,
+			pins:       []Pin{{Package: "foo", Version: "0:1.09"}},
+			wantRemove: []string{"pool/main/foo_1.10~rc1_amd64.deb", "pool/main/foo_1.2_amd64.deb"},
+			wantPinned: []string{"pool/main/foo_1.9_amd64.deb"},
+		},
+		{
+			name:       "recent versions are kept",
+			policy:     Policy{Scope: Scope{Rule: Rule{Keep: 1, NewerThan: 3*day + time.Hour}}},
+			candidates: versions,
+			wantRemove: []string{"pool/main/foo_1.2_amd64.deb", "pool/main/foo_1.9_amd64.deb"},
+		},
+		{
+			name:   "newest of each series is kept",
+			policy: Policy{Scope: Scope{Rule: Rule{Keep: 1, KeepLatest: SeriesMinor}}},
+			candidates: []Candidate{
+				candidate("stable", "foo", "1.1.1", "amd64", day),
+				candidate("stable", "foo", "1.1.2", "amd64", day),
+				candidate("stable", "foo", "1.2.0", "amd64", day),
+				candidate("stable", "foo", "2.0.0", "amd64", day),
+			},
+			wantRemove: []string{"pool/main/foo_1.1.1_amd64.deb"},
+		},
+		{
+			name:   "architectures are counted separately",
+			policy: Policy{Scope: Scope{Rule: Rule{Keep: 1}}},
+			candidates: []Candidate{
+				candidate("stable", "foo", "1.0", "amd64", day),
+				candidate("stable", "foo", "2.0", "amd64", day),
+				candidate("stable", "foo", "1.0", "arm64", day),
+			},
+			wantRemove: []string{"pool/main/foo_1.0_amd64.deb"},
+		},
+		{
+			name: "files another suite keeps aren't removed",
+			policy: Policy{
+				Scope:  Scope{Rule: Rule{Keep: 1}},
+				Suites: map[string]Scope{"stable": {Rule: Rule{Keep: 2}}},
+			},
+			candidates: []Candidate{
+				candidate("stable", "foo", "1.0", "amd64", day),
+				candidate("stable", "foo", "2.0", "amd64", day),
+				candidate("testing", "foo", "1.0", "amd64", day),
+				candidate("testing", "foo", "2.0", "amd64", day),
+				candidate("testing", "foo", "3.0", "amd64", day),
+			},
+			wantRemove: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := tt.policy.Evaluate(tt.candidates, Pins{Pins: tt.pins}, testNow)
+
+			if !reflect.DeepEqual(plan.Remove, tt.wantRemove) {
+				t.Errorf("Evaluate() removes %v, want %v", plan.Remove, tt.wantRemove)
+			}
+
+			removed := make(map[string]bool)
+			for _, file := range plan.Remove {
+				removed[file] = true
+			}
+
+			pinned := []string{}
+			for _, decision := range plan.Decisions {
+				if decision.Keep == removed[decision.Filename] {
+					t.Errorf("%s %s in %s: keep = %v, but it's removed = %v", decision.Filename, decision.Version, decision.Suite, decision.Keep, removed[decision.Filename])
+				}
+				if decision.Pinned {
+					pinned = append(pinned, decision.Filename)
+				}
+			}
+			if len(plan.Decisions) != len(tt.candidates) {
+				t.Errorf("Evaluate() made %d decisions, want %d", len(plan.Decisions), len(tt.candidates))
+			}
+
+			if tt.wantPinned == nil {
+				tt.wantPinned = []string{}
+			}
+			if !reflect.DeepEqual(pinned, tt.wantPinned) {
+				t.Errorf("Evaluate() pinned %v, want %v", pinned, tt.wantPinned)
+			}
+		})
+	}
+}
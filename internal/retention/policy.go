@@ -0,0 +1,267 @@
+package retention
+
+import (
+	"fmt"
+	"github.com/spf13/viper"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// SeriesMajor keeps the latest version of each major version series (1.x, 2.x)
+	SeriesMajor = "major"
+
+	// SeriesMinor keeps the latest version of each minor version series (1.1.x, 1.2.x)
+	SeriesMinor = "minor"
+)
+
+// Rule describes which versions of a package to keep.  A version is kept if any part of
+// the rule wants to keep it.  Zero values mean 'not set' (and are inherited from the
+// less specific rules).  A rule with nothing set keeps everything.
+type Rule struct {
+	// Keep is the number of newest versions to keep
+	Keep int `mapstructure:"keep" json:"keep,omitempty"`
+
+	// NewerThan keeps every version added more recently than this
+	NewerThan time.Duration `mapstructure:"newerthan" json:"newerThan,omitempty"`
+
+	// KeepLatest keeps the newest version in each 'major' or 'minor' version series
+	KeepLatest string `mapstructure:"keeplatest" json:"keepLatest,omitempty"`
+}
+
+// PackageRule is a rule for the packages whose names match the glob pattern
+type PackageRule struct {
+	Match string `mapstructure:"match"`
+	Rule  `mapstructure:",squash"`
+}
+
+// Scope is a rule, along with overrides for specific packages
+type Scope struct {
+	Rule     `mapstructure:",squash"`
+	Packages []PackageRule `mapstructure:"packages"`
+}
+
+// Policy is the retention policy for the repo: a default scope, with overrides for suites and components
+type Policy struct {
+	Scope      `mapstructure:",squash"`
+	Suites     map[string]Scope `mapstructure:"suites"`
+	Components map[string]Scope `mapstructure:"components"`
+}
+
+// PolicyFromConfig gets the retention policy from the 'retention' config key.  For example:
+//
+//	retention:
+//	  keep: 5
+//	  packages:
+//	    - match: "linux-*"
+//	      keep: 2
+//	  suites:
+//	    stable:
+//	      keep: 10
+//	      keeplatest: minor
+//	  components:
+//	    nightly:
+//	      keep: 1
+//	      newerthan: 168h
+func PolicyFromConfig() (Policy, error) {
+	retval := Policy{}
+
+	if err := viper.UnmarshalKey("retention", &retval); err != nil {
+		return retval, fmt.Errorf("problem reading retention policy: %w", err)
+	}
+
+	//	Nested keys don't pick up environment variables, so read the top level rule directly
+	retval.Keep = viper.GetInt("retention.keep")
+	retval.NewerThan = viper.GetDuration("retention.newerthan")
+	retval.KeepLatest = viper.GetString("retention.keeplatest")
+
+	if err := retval.Validate(); err != nil {
+		return retval, err
+	}
+
+	return retval, nil
+}
+
+// Validate makes sure the policy makes sense
+func (p Policy) Validate() error {
+	if err := p.Scope.validate("retention"); err != nil {
+		return err
+	}
+
+	for suite, scope := range p.Suites {
+		if err := scope.validate("retention.suites." + suite); err != nil {
+			return err
+		}
+	}
+
+	for component, scope := range p.Components {
+		if err := scope.validate("retention.components." + component); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validate makes sure the scope's rules make sense
+func (s Scope) validate(name string) error {
+	if err := s.Rule.validate(name); err != nil {
+		return err
+	}
+
+	for _, pkg := range s.Packages {
+		if _, err := path.Match(pkg.Match, ""); err != nil || pkg.Match == "" {
+			return fmt.Errorf("bad package pattern '%s' in %s.packages", pkg.Match, name)
+		}
+
+		if err := pkg.Rule.validate(fmt.Sprintf("%s.packages[%s]", name, pkg.Match)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validate makes sure the rule makes sense
+func (r Rule) validate(name string) error {
+	if r.Keep < 0 {
+		return fmt.Errorf("%s.keep can't be negative", name)
+	}
+
+	if r.NewerThan < 0 {
+		return fmt.Errorf("%s.newerthan can't be negative", name)
+	}
+
+	switch r.KeepLatest {
+	case "", SeriesMajor, SeriesMinor:
+	default:
+		return fmt.Errorf("%s.keeplatest must be '%s' or '%s' (not '%s')", name, SeriesMajor, SeriesMinor, r.KeepLatest)
+	}
+
+	return nil
+}
+
+// namedScope is a scope along with the config key it came from
+type namedScope struct {
+	name  string
+	scope Scope
+}
+
+// layers returns the scopes that apply to the suite and component, least specific first
+func (p Policy) layers(suite, component string) []namedScope {
+	retval := []namedScope{{name: "retention", scope: p.Scope}}
+
+	//	Viper lower cases map keys, so look them up that way
+	if scope, ok := p.Suites[strings.ToLower(suite)]; ok && suite != "" {
+		retval = append(retval, namedScope{name: "retention.suites." + suite, scope: scope})
+	}
+
+	if scope, ok := p.Components[strings.ToLower(component)]; ok && component != "" {
+		retval = append(retval, namedScope{name: "retention.components." + component, scope: scope})
+	}
+
+	return retval
+}
+
+// RuleFor works out the rule for the package in the suite and component.  Suite rules override
+// the default rule, component rules override suite rules, and package rules (the first matching
+// pattern in each scope) override all of those.  It also returns the name of the most specific
+// config key that contributed to the rule.
+func (p Policy) RuleFor(suite, component, pkg string) (Rule, string) {
+	retval := Rule{}
+	name := ""
+
+	layers := p.layers(suite, component)
+	for _, layer := range layers {
+		if retval.merge(layer.scope.Rule) || name == "" {
+			name = layer.name
+		}
+	}
+
+	for _, layer := range layers {
+		for _, pkgRule := range layer.scope.Packages {
+			if matched, _ := path.Match(pkgRule.Match, pkg); matched {
+				retval.merge(pkgRule.Rule)
+				name = fmt.Sprintf("%s.packages[%s]", layer.name, pkgRule.Match)
+				break
+			}
+		}
+	}
+
+	return retval, name
+}
+
+// UsesAge returns true if any of the policy's rules depend on when packages were added
+func (p Policy) UsesAge() bool {
+	scopes := []Scope{p.Scope}
+	for _, scope := range p.Suites {
+		scopes = append(scopes, scope)
+	}
+	for _, scope := range p.Components {
+		scopes = append(scopes, scope)
+	}
+
+	for _, scope := range scopes {
+		if scope.NewerThan > 0 {
+			return true
+		}
+		for _, pkg := range scope.Packages {
+			if pkg.NewerThan > 0 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// IsZero returns true if nothing is set in the rule
+func (r Rule) IsZero() bool {
+	return r == Rule{}
+}
+
+// merge overrides the rule with the fields that are set in 'other'.  It returns true if anything was set.
+func (r *Rule) merge(other Rule) bool {
+	changed := false
+
+	if other.Keep > 0 {
+		r.Keep = other.Keep
+		changed = true
+	}
+
+	if other.NewerThan > 0 {
+		r.NewerThan = other.NewerThan
+		changed = true
+	}
+
+	if other.KeepLatest != "" {
+		r.KeepLatest = other.KeepLatest
+		changed = true
+	}
+
+	return changed
+}
+
+// Describe describes the rule
+func (r Rule) Describe() string {
+	parts := []string{}
+
+	if r.Keep > 0 {
+		parts = append(parts, fmt.Sprintf("keep the %d newest", r.Keep))
+	}
+
+	if r.NewerThan > 0 {
+		parts = append(parts, fmt.Sprintf("keep anything added in the last %v", r.NewerThan))
+	}
+
+	if r.KeepLatest != "" {
+		parts = append(parts, fmt.Sprintf("keep the latest of each %s series", r.KeepLatest))
+	}
+
+	if len(parts) == 0 {
+		return "keep everything"
+	}
+
+	return strings.Join(parts, ", ")
+}
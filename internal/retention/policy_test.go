@@ -0,0 +1,136 @@
+package retention
+
+import (
+	"testing"
+	"time"
+)
+
+// testPolicy has a rule at every level: the default, a suite, a component and packages
+var testPolicy = Policy{
+	Scope: Scope{
+		Rule:     Rule{Keep: 5},
+		Packages: []PackageRule{{Match: "linux-*", Rule: Rule{Keep: 2}}},
+	},
+	Suites: map[string]Scope{
+		"stable": {Rule: Rule{Keep: 10, KeepLatest: SeriesMinor}},
+	},
+	Components: map[string]Scope{
+		"nightly": {
+			Rule:     Rule{Keep: 1, NewerThan: 168 * time.Hour},
+			Packages: []PackageRule{{Match: "foo*", Rule: Rule{KeepLatest: SeriesMajor}}},
+		},
+	},
+}
+
+func TestRuleFor(t *testing.T) {
+	tests := []struct {
+		name                  string
+		policy                Policy
+		suite, component, pkg string
+		want                  Rule
+		wantName              string
+	}{
+		{
+			name:     "no policy keeps everything",
+			policy:   Policy{},
+			suite:    "stable",
+			pkg:      "bar",
+			want:     Rule{},
+			wantName: "retention",
+		},
+		{
+			name:     "default rule",
+			policy:   testPolicy,
+			suite:    "testing",
+			pkg:      "bar",
+			want:     Rule{Keep: 5},
+			wantName: "retention",
+		},
+		{
+			name:     "default package rule",
+			policy:   testPolicy,
+			pkg:      "linux-image",
+			want:     Rule{Keep: 2},
+			wantName: "retention.packages[linux-*]",
+		},
+		{
+			name:      "suite overrides the default",
+			policy:    testPolicy,
+			suite:     "stable",
+			component: "main",
+			pkg:       "bar",
+			want:      Rule{Keep: 10, KeepLatest: SeriesMinor},
+			wantName:  "retention.suites.stable",
+		},
+		{
+			name:     "suites match whatever the case",
+			policy:   testPolicy,
+			suite:    "Stable",
+			pkg:      "bar",
+			want:     Rule{Keep: 10, KeepLatest: SeriesMinor},
+			wantName: "retention.suites.Stable",
+		},
+		{
+			name:     "package rule overrides the suite",
+			policy:   testPolicy,
+			suite:    "stable",
+			pkg:      "linux-image",
+			want:     Rule{Keep: 2, KeepLatest: SeriesMinor},
+			wantName: "retention.packages[linux-*]",
+		},
+		{
+			name:      "component overrides the suite",
+			policy:    testPolicy,
+			suite:     "stable",
+			component: "nightly",
+			pkg:       "bar",
+			want:      Rule{Keep: 1, NewerThan: 168 * time.Hour, KeepLatest: SeriesMinor},
+			wantName:  "retention.components.nightly",
+		},
+		{
+			name:      "component package rule",
+			policy:    testPolicy,
+			suite:     "stable",
+			component: "nightly",
+			pkg:       "foobar",
+			want:      Rule{Keep: 1, NewerThan: 168 * time.Hour, KeepLatest: SeriesMajor},
+			wantName:  "retention.components.nightly.packages[foo*]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, gotName := tt.policy.RuleFor(tt.suite, tt.component, tt.pkg)
+			if got != tt.want {
+				t.Errorf("RuleFor() rule = %+v, want %+v", got, tt.want)
+			}
+			if gotName != tt.wantName {
+				t.Errorf("RuleFor() name = %s, want %s", gotName, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  Policy
+		wantErr bool
+	}{
+		{name: "empty", policy: Policy{}},
+		{name: "every level", policy: testPolicy},
+		{name: "negative keep", policy: Policy{Scope: Scope{Rule: Rule{Keep: -1}}}, wantErr: true},
+		{name: "negative age", policy: Policy{Suites: map[string]Scope{"stable": {Rule: Rule{NewerThan: -time.Hour}}}}, wantErr: true},
+		{name: "unknown series", policy: Policy{Components: map[string]Scope{"main": {Rule: Rule{KeepLatest: "patch"}}}}, wantErr: true},
+		{name: "bad package pattern", policy: Policy{Scope: Scope{Packages: []PackageRule{{Match: "[", Rule: Rule{Keep: 1}}}}}, wantErr: true},
+		{name: "empty package pattern", policy: Policy{Scope: Scope{Packages: []PackageRule{{Rule: Rule{Keep: 1}}}}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.policy.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package upload
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+)
+
+// Checksum algorithms
+const (
+	AlgorithmMD5    = "md5"
+	AlgorithmSHA1   = "sha1"
+	AlgorithmSHA256 = "sha256"
+	AlgorithmSHA512 = "sha512"
+)
+
+// Checksum is a checksum a client expects an upload to have
+type Checksum struct {
+	// Algorithm is one of the Algorithm constants
+	Algorithm string
+
+	// Value is the hex encoded checksum
+	Value string
+}
+
+// Digests are the checksums of an uploaded file (hex encoded)
+type Digests struct {
+	MD5    string `json:"md5"`
+	SHA1   string `json:"sha1"`
+	SHA256 string `json:"sha256"`
+	SHA512 string `json:"sha512"`
+}
+
+// Hasher works out the digests of everything written to it
+type Hasher struct {
+	io.Writer
+	md5    hash.Hash
+	sha1   hash.Hash
+	sha256 hash.Hash
+	sha512 hash.Hash
+}
+
+// NewHasher creates a hasher.  Write the upload to it (alongside the file, with an
+// io.MultiWriter) so the file is only read once.
+func NewHasher() *Hasher {
+	h := &Hasher{
+		md5:    md5.New(),
+		sha1:   sha1.New(),
+		sha256: sha256.New(),
+		sha512: sha512.New(),
+	}
+	h.Writer = io.MultiWriter(h.md5, h.sha1, h.sha256, h.sha512)
+
+	return h
+}
+
+// Digests returns the digests of what's been written so far
+func (h *Hasher) Digests() Digests {
+	return Digests{
+		MD5:    hex.EncodeToString(h.md5.Sum(nil)),
+		SHA1:   hex.EncodeToString(h.sha1.Sum(nil)),
+		SHA256: hex.EncodeToString(h.sha256.Sum(nil)),
+		SHA512: hex.EncodeToString(h.sha512.Sum(nil)),
+	}
+}
+
+// Get returns the digest for the algorithm
+func (d Digests) Get(algorithm string) (string, bool) {
+	switch algorithm {
+	case AlgorithmMD5:
+		return d.MD5, true
+	case AlgorithmSHA1:
+		return d.SHA1, true
+	case AlgorithmSHA256:
+		return d.SHA256, true
+	case AlgorithmSHA512:
+		return d.SHA512, true
+	}
+
+	return "", false
+}
+
+// Verify makes sure the digests match every one of the expected checksums
+func (d Digests) Verify(expected ...Checksum) error {
+	for _, checksum := range expected {
+		actual, ok := d.Get(checksum.Algorithm)
+		if !ok {
+			return fmt.Errorf("unknown checksum algorithm '%s'", checksum.Algorithm)
+		}
+
+		if !strings.EqualFold(actual, checksum.Value) {
+			return fmt.Errorf("%w: expected %s %s, got %s", ErrChecksumMismatch, checksum.Algorithm, strings.ToLower(checksum.Value), actual)
+		}
+	}
+
+	return nil
+}
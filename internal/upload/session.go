@@ -0,0 +1,616 @@
+package upload
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/danesparza/package-assistant/internal/debian"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// sessionFile holds the details of a session, in the session's folder
+	sessionFile = "session.json"
+
+	// chunkPrefix starts the name of each chunk file (followed by the offset the chunk starts at)
+	chunkPrefix = "chunk-"
+
+	// partPrefix starts the name of a chunk that's still being received
+	partPrefix = ".part-"
+
+	// finalizingSuffix is added to the folder of a session while it's being finalized
+	finalizingSuffix = ".finalizing"
+
+	// lockFile is locked (in the session's folder) while the session is being changed
+	lockFile = ".lock"
+
+	// maxInstanceName is the longest an instance name gets in session ids
+	maxInstanceName = 63
+)
+
+var (
+	// ErrSessionNotFound is returned for a session that doesn't exist (or has expired)
+	ErrSessionNotFound = errors.New("upload session not found")
+
+	// ErrOffsetMismatch is returned when a chunk doesn't start where the upload left off
+	ErrOffsetMismatch = errors.New("chunk offset doesn't match the upload offset")
+
+	// ErrTooBig is returned when an upload is (or would become) bigger than the limit
+	ErrTooBig = errors.New("upload is too big")
+
+	// ErrIncomplete is returned when finalizing an upload that doesn't have all of its data yet
+	ErrIncomplete = errors.New("upload is incomplete")
+
+	// ErrChecksumMismatch is returned when the data uploaded doesn't have the expected checksum
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+
+	// ErrWrongInstance is returned for a session that was created by (and is kept on) another instance
+	ErrWrongInstance = errors.New("upload session is on another instance")
+)
+
+// Session is a resumable upload.  The package is sent in chunks (each one starting where the
+// upload left off), so a failed chunk can be resumed rather than starting over.  Once it's
+// all there, the chunks are put back together and checked against the expected checksum.
+//
+// Sessions are kept on the disk of the instance that created them, so every request for a
+// session has to go to that instance.  Its id starts with the instance's name (like
+// 'host-1.9f86d081...'), so a load balancer can route on it.
+type Session struct {
+	ID        string `json:"id"`
+	Instance  string `json:"instance,omitempty"`
+	Filename  string `json:"filename"`
+	Suite     string `json:"suite,omitempty"`
+	Component string `json:"component,omitempty"`
+
+	// Length is the size of the whole upload, if the client told us up front
+	Length int64 `json:"length,omitempty"`
+
+	// Offset is how much of the upload we have so far -- the next chunk starts here
+	Offset int64 `json:"offset"`
+
+	Created time.Time `json:"created"`
+
+	// Expires is when the session is removed, unless more data arrives before then
+	Expires time.Time `json:"expires"`
+}
+
+// Store keeps upload sessions on disk, one folder per session
+type Store struct {
+	// Folder holds the session folders
+	Folder string
+
+	// TTL is how long a session is kept after data last arrived
+	TTL time.Duration
+
+	// MaxSize is the biggest an upload can be
+	MaxSize int64
+
+	// Instance names this instance.  It starts the id of each session created here, so requests
+	// for a session that reach another instance can say where the session is.
+	Instance string
+}
+
+// chunk is a piece of an upload on disk
+type chunk struct {
+	path  string
+	start int64
+	size  int64
+}
+
+// StoreFromConfig returns the upload session store, with sessions kept in the 'sessions'
+// folder of upload.path
+func StoreFromConfig() Store {
+	return Store{
+		Folder:   filepath.Join(viper.GetString("upload.path"), "sessions"),
+		TTL:      viper.GetDuration("upload.sessionttl"),
+		MaxSize:  viper.GetInt64("upload.streambytelimit"),
+		Instance: InstanceName(),
+	}
+}
+
+// InstanceName names this instance in session ids.  It's the hostname (so sessions are still
+// found after a restart), lower cased and with anything other than letters, digits and dashes
+// replaced with dashes.  Long hostnames are cut short, to keep session folder names short.
+func InstanceName() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return ""
+	}
+	if len(hostname) > maxInstanceName {
+		hostname = hostname[:maxInstanceName]
+	}
+
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-':
+			return r
+		default:
+			return '-'
+		}
+	}, strings.ToLower(hostname))
+}
+
+// Create starts a session to upload the named file to the target.  The length of the upload
+// is optional (0 means it isn't known yet).
+func (s Store) Create(filename string, target debian.Target, length int64) (Session, error) {
+	if length < 0 {
+		return Session{}, fmt.Errorf("upload length can't be negative (got %d)", length)
+	}
+	if length > s.MaxSize {
+		return Session{}, fmt.Errorf("%w: %d bytes is more than the limit of %d", ErrTooBig, length, s.MaxSize)
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return Session{}, fmt.Errorf("problem creating session id: %w", err)
+	}
+
+	session := Session{
+		ID:        hex.EncodeToString(b),
+		Instance:  s.Instance,
+		Filename:  filename,
+		Suite:     target.Suite,
+		Component: target.Component,
+		Length:    length,
+		Created:   time.Now(),
+	}
+	if s.Instance != "" {
+		session.ID = s.Instance + "." + session.ID
+	}
+
+	folder := s.sessionFolder(session.ID)
+	if err := os.MkdirAll(folder, os.ModePerm); err != nil {
+		return Session{}, fmt.Errorf("problem creating session folder: %w", err)
+	}
+
+	data, err := json.Marshal(session)
+	if err != nil {
+		return Session{}, fmt.Errorf("problem serializing session: %w", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(folder, sessionFile), data, 0644); err != nil {
+		os.RemoveAll(folder)
+		return Session{}, fmt.Errorf("problem saving session: %w", err)
+	}
+
+	session.Expires = session.Created.Add(s.TTL)
+	log.Debug().Str("session", session.ID).Str("filename", filename).Msg("Created upload session")
+
+	return session, nil
+}
+
+// Get returns the session with the given id, including how much has been uploaded so far
+func (s Store) Get(id string) (Session, error) {
+	session, _, err := s.load(s.sessionFolder(id), id)
+	return session, err
+}
+
+// Append adds a chunk (read from r) to the session.  The chunk has to start at the offset the
+// upload is at.  If reading the chunk fails part way, what arrived is kept, so the client can
+// resume from the offset of the returned session.
+func (s Store) Append(id string, offset int64, r io.Reader) (Session, error) {
+	folder := s.sessionFolder(id)
+
+	//	Only one chunk at a time, so two requests can't both be appended at the same offset
+	unlock, err := s.lock(id)
+	if err != nil {
+		return Session{}, err
+	}
+	defer unlock()
+
+	session, _, err := s.load(folder, id)
+	if err != nil {
+		return session, err
+	}
+
+	if offset != session.Offset {
+		return session, fmt.Errorf("%w: the upload is at offset %d (got %d)", ErrOffsetMismatch, session.Offset, offset)
+	}
+
+	limit := s.MaxSize
+	if session.Length > 0 {
+		limit = session.Length
+	}
+
+	//	Receive the chunk into a part file, so it only counts once we have it.  Read one byte
+	//	more than we allow, so we can tell if it's too big.
+	part, err := os.CreateTemp(folder, partPrefix)
+	if err != nil {
+		return session, fmt.Errorf("problem creating chunk file: %w", err)
+	}
+	defer os.Remove(part.Name())
+
+	received, readErr := io.Copy(part, io.LimitReader(r, limit-offset+1))
+	if err := part.Close(); err != nil && readErr == nil {
+		readErr = err
+	}
+
+	if offset+received > limit {
+		return session, fmt.Errorf("%w: the upload would be more than %d bytes", ErrTooBig, limit)
+	}
+
+	if received > 0 {
+		if err := os.Rename(part.Name(), filepath.Join(folder, chunkName(offset))); err != nil {
+			return session, fmt.Errorf("problem saving chunk: %w", err)
+		}
+
+		//	Keep the session around while data is still arriving
+		now := time.Now()
+		if err := os.Chtimes(filepath.Join(folder, sessionFile), now, now); err != nil {
+			log.Err(err).Str("session", id).Msg("problem updating upload session time")
+		}
+	}
+
+	session, _, err = s.load(folder, id)
+	if err != nil {
+		return session, err
+	}
+
+	if readErr != nil {
+		return session, fmt.Errorf("problem reading chunk (the upload is at offset %d): %w", session.Offset, readErr)
+	}
+
+	log.Debug().Str("session", id).Int64("received", received).Int64("offset", session.Offset).Msg("Received upload chunk")
+	return session, nil
+}
+
+// Finalize puts the chunks of the session back together into the destination file and checks
+// it against the expected checksums, returning its digests.  If they match, the session is done
+// and is removed.  If they don't, the session is left as it was.
+func (s Store) Finalize(id, destination string, expected ...Checksum) (Session, Digests, error) {
+	folder := s.sessionFolder(id)
+
+	//	The lock file moves with the folder, so it stays locked until we're done
+	unlock, err := s.lock(id)
+	if err != nil {
+		return Session{}, Digests{}, err
+	}
+	defer unlock()
+
+	if _, _, err := s.load(folder, id); err != nil {
+		return Session{}, Digests{}, err
+	}
+
+	//	Move the session out of the way, so it can only be finalized once (and no more chunks arrive)
+	finalizing := folder + finalizingSuffix
+	if err := os.Rename(folder, finalizing); err != nil {
+		return Session{}, Digests{}, ErrSessionNotFound
+	}
+
+	digests := Digests{}
+	session, chunks, err := s.load(finalizing, id)
+	if err == nil {
+		digests, err = assemble(session, chunks, destination, expected)
+	}
+	if err != nil {
+		if renameErr := os.Rename(finalizing, folder); renameErr != nil {
+			log.Err(renameErr).Str("session", id).Msg("problem restoring upload session")
+		}
+		return session, digests, err
+	}
+
+	if err := os.RemoveAll(finalizing); err != nil {
+		log.Err(err).Str("session", id).Msg("problem removing finalized upload session")
+	}
+
+	log.Debug().Str("session", id).Str("destination", destination).Msg("Finalized upload session")
+	return session, digests, nil
+}
+
+// Delete removes a session and whatever was uploaded to it
+func (s Store) Delete(id string) error {
+	unlock, err := s.lock(id)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if _, err := s.Get(id); err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(s.sessionFolder(id)); err != nil {
+		return fmt.Errorf("problem removing upload session: %w", err)
+	}
+
+	return nil
+}
+
+// RemoveExpired removes the sessions that haven't had any data arrive for longer than the TTL.
+// It returns the number of sessions removed.
+func (s Store) RemoveExpired(now time.Time) (int, error) {
+	entries, err := os.ReadDir(s.Folder)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("problem reading upload sessions: %w", err)
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		//	If there's no session file, go by the folder itself
+		folder := filepath.Join(s.Folder, entry.Name())
+		stat, err := os.Stat(filepath.Join(folder, sessionFile))
+		if err != nil {
+			stat, err = os.Stat(folder)
+			if err != nil {
+				continue
+			}
+		}
+
+		if now.Before(stat.ModTime().Add(s.TTL)) {
+			continue
+		}
+
+		//	Leave a session that's being changed right now: it isn't expired after all
+		unlock, err := tryLockFolder(folder)
+		if err != nil {
+			continue
+		}
+
+		log.Info().Str("session", entry.Name()).Time("lastActivity", stat.ModTime()).Msg("Removing expired upload session")
+		err = os.RemoveAll(folder)
+		unlock()
+		if err != nil {
+			return removed, fmt.Errorf("problem removing upload session %s: %w", entry.Name(), err)
+		}
+		removed++
+	}
+
+	return removed, nil
+}
+
+// checkID makes sure the id is a session id, and the session is on this instance.  Session ids
+// are the instance name, a dot and some hex (or just the hex, for a store without an instance
+// name), so they can't point anywhere else.  A session another instance created is only found
+// if upload.path is shared between them.
+func (s Store) checkID(id string) error {
+	instance, key, found := strings.Cut(id, ".")
+	if !found {
+		instance, key = s.Instance, id
+	}
+
+	if _, err := hex.DecodeString(key); err != nil || key == "" || (found && instance == "") || !validInstanceName(instance) {
+		return ErrSessionNotFound
+	}
+	if instance == s.Instance {
+		return nil
+	}
+
+	if _, err := os.Stat(s.sessionFolder(id)); err != nil {
+		return fmt.Errorf("%w: upload session %s is kept on instance %s (this is %s) -- send its requests there", ErrWrongInstance, id, instance, s.Instance)
+	}
+
+	return nil
+}
+
+// validInstanceName returns true if the name could be made by InstanceName
+func validInstanceName(name string) bool {
+	for _, r := range name {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// sessionFolder returns the folder of the session with the given id
+func (s Store) sessionFolder(id string) string {
+	return filepath.Join(s.Folder, id)
+}
+
+// lock waits for any other change to the session to finish, then holds off others until the
+// returned function is called.  Sessions are on this instance's disk, so a file lock is enough.
+func (s Store) lock(id string) (func(), error) {
+	if err := s.checkID(id); err != nil {
+		return nil, err
+	}
+
+	folder := s.sessionFolder(id)
+	f, err := os.OpenFile(filepath.Join(folder, lockFile), os.O_RDWR|os.O_CREATE, 0644)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("problem opening upload session lock: %w", err)
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("problem locking upload session: %w", err)
+	}
+
+	//	The session may have been finalized or removed while we waited
+	locked, err := f.Stat()
+	if err == nil {
+		var current os.FileInfo
+		current, err = os.Stat(filepath.Join(folder, lockFile))
+		if err == nil && !os.SameFile(locked, current) {
+			err = os.ErrNotExist
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, ErrSessionNotFound
+	}
+
+	return func() { f.Close() }, nil
+}
+
+// tryLockFolder locks the session in the folder if nothing else has it locked.  It returns an
+// error if something does.
+func tryLockFolder(folder string) (func(), error) {
+	f, err := os.OpenFile(filepath.Join(folder, lockFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return func() { f.Close() }, nil
+}
+
+// load reads the session in the folder, along with the chunks that make up the upload so far
+func (s Store) load(folder, id string) (Session, []chunk, error) {
+	session := Session{}
+
+	if err := s.checkID(id); err != nil {
+		return session, nil, err
+	}
+
+	//	The session file is touched whenever data arrives, so it tells us when the session expires
+	sessionPath := filepath.Join(folder, sessionFile)
+	stat, err := os.Stat(sessionPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return session, nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return session, nil, fmt.Errorf("problem reading upload session: %w", err)
+	}
+
+	expires := stat.ModTime().Add(s.TTL)
+	if !time.Now().Before(expires) {
+		return session, nil, ErrSessionNotFound
+	}
+
+	data, err := os.ReadFile(sessionPath)
+	if err != nil {
+		return session, nil, fmt.Errorf("problem reading upload session: %w", err)
+	}
+	if err := json.Unmarshal(data, &session); err != nil {
+		return session, nil, fmt.Errorf("problem reading upload session: %w", err)
+	}
+	session.Expires = expires
+
+	chunks, err := readChunks(folder)
+	if err != nil {
+		return session, nil, err
+	}
+
+	for _, c := range chunks {
+		session.Offset += c.size
+	}
+
+	return session, chunks, nil
+}
+
+// readChunks returns the chunks in the folder that make up the upload, in order.  The upload
+// is the run of chunks from the start that follow on from each other.
+func readChunks(folder string) ([]chunk, error) {
+	entries, err := os.ReadDir(folder)
+	if err != nil {
+		return nil, fmt.Errorf("problem reading upload chunks: %w", err)
+	}
+
+	all := []chunk{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), chunkPrefix) {
+			continue
+		}
+
+		start, err := strconv.ParseInt(strings.TrimPrefix(entry.Name(), chunkPrefix), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("problem reading upload chunk: %w", err)
+		}
+
+		all = append(all, chunk{path: filepath.Join(folder, entry.Name()), start: start, size: info.Size()})
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].start < all[j].start
+	})
+
+	retval := []chunk{}
+	offset := int64(0)
+	for _, c := range all {
+		if c.start != offset {
+			break
+		}
+		retval = append(retval, c)
+		offset += c.size
+	}
+
+	return retval, nil
+}
+
+// assemble writes the chunks into the destination file, making sure the whole upload is
+// there and has the expected checksums.  The destination file is removed if anything's wrong.
+func assemble(session Session, chunks []chunk, destination string, expected []Checksum) (digests Digests, err error) {
+	if session.Length > 0 && session.Offset != session.Length {
+		return digests, fmt.Errorf("%w: have %d of %d bytes", ErrIncomplete, session.Offset, session.Length)
+	}
+	if session.Offset == 0 {
+		return digests, fmt.Errorf("%w: nothing has been uploaded", ErrIncomplete)
+	}
+
+	dst, err := os.Create(destination)
+	if err != nil {
+		return digests, fmt.Errorf("problem creating file: %w", err)
+	}
+	defer func() {
+		dst.Close()
+		if err != nil {
+			os.Remove(destination)
+		}
+	}()
+
+	hasher := NewHasher()
+	w := io.MultiWriter(dst, hasher)
+	for _, c := range chunks {
+		if err := appendChunk(w, c); err != nil {
+			return digests, err
+		}
+	}
+
+	if err := dst.Close(); err != nil {
+		return digests, fmt.Errorf("problem saving file: %w", err)
+	}
+
+	digests = hasher.Digests()
+	return digests, digests.Verify(expected...)
+}
+
+// appendChunk copies a chunk to the writer
+func appendChunk(w io.Writer, c chunk) error {
+	f, err := os.Open(c.path)
+	if err != nil {
+		return fmt.Errorf("problem opening upload chunk: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(w, f); err != nil {
+		return fmt.Errorf("problem copying upload chunk: %w", err)
+	}
+
+	return nil
+}
+
+// chunkName returns the file name of the chunk starting at the offset (zero padded, so they sort)
+func chunkName(offset int64) string {
+	return fmt.Sprintf("%s%020d", chunkPrefix, offset)
+}
@@ -0,0 +1,341 @@
+package upload
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/danesparza/package-assistant/internal/debian"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// errDisconnected is what a chunk's reader fails with when the client goes away
+var errDisconnected = errors.New("client disconnected")
+
+// testStore returns a session store in a temp folder
+func testStore(t *testing.T, maxSize int64) Store {
+	t.Helper()
+
+	return Store{
+		Folder:  filepath.Join(t.TempDir(), "sessions"),
+		TTL:     time.Hour,
+		MaxSize: maxSize,
+	}
+}
+
+// sha256Checksum is the expected SHA256 checksum of the data
+func sha256Checksum(data string) Checksum {
+	sum := sha256.Sum256([]byte(data))
+	return Checksum{Algorithm: AlgorithmSHA256, Value: hex.EncodeToString(sum[:])}
+}
+
+func TestAppendOffsets(t *testing.T) {
+	type chunk struct {
+		offset     int64
+		data       string
+		disconnect bool
+		wantOffset int64
+		wantErr    error
+	}
+
+	tests := []struct {
+		name    string
+		length  int64
+		maxSize int64
+		chunks  []chunk
+	}{
+		{
+			name: "chunks in order",
+			chunks: []chunk{
+				{offset: 0, data: "hello ", wantOffset: 6},
+				{offset: 6, data: "world", wantOffset: 11},
+			},
+		},
+		{
+			name: "a retried chunk is rejected",
+			chunks: []chunk{
+				{offset: 0, data: "hello", wantOffset: 5},
+				{offset: 0, data: "hello", wantOffset: 5, wantErr: ErrOffsetMismatch},
+				{offset: 5, data: " world", wantOffset: 11},
+			},
+		},
+		{
+			name: "a chunk can't skip ahead",
+			chunks: []chunk{
+				{offset: 3, data: "lo", wantOffset: 0, wantErr: ErrOffsetMismatch},
+			},
+		},
+		{
+			name: "an empty chunk changes nothing",
+			chunks: []chunk{
+				{offset: 0, data: "", wantOffset: 0},
+				{offset: 0, data: "hello", wantOffset: 5},
+			},
+		},
+		{
+			name: "an interrupted chunk keeps what arrived",
+			chunks: []chunk{
+				{offset: 0, data: "hel", disconnect: true, wantOffset: 3, wantErr: errDisconnected},
+				{offset: 3, data: "lo", wantOffset: 5},
+			},
+		},
+		{
+			name:   "no more than the length",
+			length: 5,
+			chunks: []chunk{
+				{offset: 0, data: "hel", wantOffset: 3},
+				{offset: 3, data: "lo!", wantOffset: 3, wantErr: ErrTooBig},
+				{offset: 3, data: "lo", wantOffset: 5},
+			},
+		},
+		{
+			name:    "no more than the limit",
+			maxSize: 4,
+			chunks: []chunk{
+				{offset: 0, data: "hello", wantOffset: 0, wantErr: ErrTooBig},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			maxSize := tt.maxSize
+			if maxSize == 0 {
+				maxSize = 1024
+			}
+			store := testStore(t, maxSize)
+
+			session, err := store.Create("foo.deb", debian.Target{}, tt.length)
+			if err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+
+			for i, c := range tt.chunks {
+				var r io.Reader = strings.NewReader(c.data)
+				if c.disconnect {
+					r = io.MultiReader(r, &failingReader{err: errDisconnected})
+				}
+
+				got, err := store.Append(session.ID, c.offset, r)
+				if c.wantErr == nil && err != nil {
+					t.Fatalf("chunk %d: Append() error = %v", i, err)
+				}
+				if c.wantErr != nil && !errors.Is(err, c.wantErr) {
+					t.Fatalf("chunk %d: Append() error = %v, want %v", i, err, c.wantErr)
+				}
+				if got.Offset != c.wantOffset {
+					t.Errorf("chunk %d: Append() offset = %d, want %d", i, got.Offset, c.wantOffset)
+				}
+
+				//	The session reports the same offset when it's looked up again
+				current, err := store.Get(session.ID)
+				if err != nil {
+					t.Fatalf("chunk %d: Get() error = %v", i, err)
+				}
+				if current.Offset != c.wantOffset {
+					t.Errorf("chunk %d: Get() offset = %d, want %d", i, current.Offset, c.wantOffset)
+				}
+			}
+		})
+	}
+}
+
+// failingReader fails every read with its error
+type failingReader struct {
+	err error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
+
+// slowReader waits before the first read, like a chunk arriving over a slow connection
+type slowReader struct {
+	r       io.Reader
+	delay   time.Duration
+	started bool
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	if !r.started {
+		time.Sleep(r.delay)
+		r.started = true
+	}
+	return r.r.Read(p)
+}
+
+func TestFinalize(t *testing.T) {
+	tests := []struct {
+		name        string
+		length      int64
+		data        string
+		expected    []Checksum
+		wantErr     error
+		wantSession bool
+	}{
+		{name: "matching checksum", data: "hello world", expected: []Checksum{sha256Checksum("hello world")}},
+		{name: "no checksum", data: "hello world"},
+		{name: "wrong checksum", data: "hello world", expected: []Checksum{sha256Checksum("hello")}, wantErr: ErrChecksumMismatch, wantSession: true},
+		{name: "short of the length", length: 20, data: "hello world", wantErr: ErrIncomplete, wantSession: true},
+		{name: "nothing uploaded", wantErr: ErrIncomplete, wantSession: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := testStore(t, 1024)
+			session, err := store.Create("foo.deb", debian.Target{}, tt.length)
+			if err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+			if _, err := store.Append(session.ID, 0, strings.NewReader(tt.data)); err != nil {
+				t.Fatalf("Append() error = %v", err)
+			}
+
+			destination := filepath.Join(t.TempDir(), "foo.deb")
+			_, digests, err := store.Finalize(session.ID, destination, tt.expected...)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Finalize() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr == nil {
+				data, err := os.ReadFile(destination)
+				if err != nil {
+					t.Fatalf("problem reading the finalized upload: %v", err)
+				}
+				if string(data) != tt.data {
+					t.Errorf("finalized upload is %q, want %q", data, tt.data)
+				}
+				if digests.SHA256 != sha256Checksum(tt.data).Value {
+					t.Errorf("Finalize() SHA256 = %s, want %s", digests.SHA256, sha256Checksum(tt.data).Value)
+				}
+			} else if _, err := os.Stat(destination); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("a failed upload left its destination file behind")
+			}
+
+			//	A failed upload can still be resumed, a finished one is gone
+			current, err := store.Get(session.ID)
+			if tt.wantSession {
+				if err != nil {
+					t.Fatalf("Get() error = %v", err)
+				}
+				if current.Offset != int64(len(tt.data)) {
+					t.Errorf("Get() offset = %d, want %d", current.Offset, len(tt.data))
+				}
+			} else if !errors.Is(err, ErrSessionNotFound) {
+				t.Errorf("Get() error = %v, want ErrSessionNotFound", err)
+			}
+		})
+	}
+}
+
+func TestSessionNotFound(t *testing.T) {
+	store := testStore(t, 1024)
+	session, err := store.Create("foo.deb", debian.Target{}, 0)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := store.Delete(session.ID); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	for _, id := range []string{session.ID, "0123abcd", "../sessions", ""} {
+		t.Run(id, func(t *testing.T) {
+			if _, err := store.Get(id); !errors.Is(err, ErrSessionNotFound) {
+				t.Errorf("Get() error = %v, want ErrSessionNotFound", err)
+			}
+			if _, err := store.Append(id, 0, strings.NewReader("hello")); !errors.Is(err, ErrSessionNotFound) {
+				t.Errorf("Append() error = %v, want ErrSessionNotFound", err)
+			}
+			if _, _, err := store.Finalize(id, filepath.Join(t.TempDir(), "foo.deb")); !errors.Is(err, ErrSessionNotFound) {
+				t.Errorf("Finalize() error = %v, want ErrSessionNotFound", err)
+			}
+			if err := store.Delete(id); !errors.Is(err, ErrSessionNotFound) {
+				t.Errorf("Delete() error = %v, want ErrSessionNotFound", err)
+			}
+		})
+	}
+}
+
+func TestAppendConcurrent(t *testing.T) {
+	store := testStore(t, 1024*1024)
+	session, err := store.Create("foo.deb", debian.Target{}, 0)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	//	Several clients send the first chunk at once (slowly, so they overlap): only one of them gets it in
+	chunk := strings.Repeat("x", 64*1024)
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			_, err := store.Append(session.ID, 0, &slowReader{r: strings.NewReader(chunk), delay: 20 * time.Millisecond})
+			errs <- err
+		}()
+	}
+
+	appended := 0
+	for i := 0; i < cap(errs); i++ {
+		err := <-errs
+		switch {
+		case err == nil:
+			appended++
+		case !errors.Is(err, ErrOffsetMismatch):
+			t.Errorf("Append() error = %v, want ErrOffsetMismatch", err)
+		}
+	}
+	if appended != 1 {
+		t.Errorf("%d chunks were appended at offset 0, want 1", appended)
+	}
+
+	current, err := store.Get(session.ID)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if current.Offset != int64(len(chunk)) {
+		t.Errorf("Get() offset = %d, want %d", current.Offset, len(chunk))
+	}
+}
+
+func TestSessionInstance(t *testing.T) {
+	store := testStore(t, 1024)
+	store.Instance = "host-1"
+	session, err := store.Create("foo.deb", debian.Target{}, 0)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if !strings.HasPrefix(session.ID, "host-1.") || session.Instance != "host-1" {
+		t.Fatalf("Create() = %+v, want the session id to start with the instance", session)
+	}
+	if _, err := store.Get(session.ID); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+
+	//	Another instance says where the session is, unless it shares the folder
+	other := testStore(t, 1024)
+	other.Instance = "host-2"
+	if _, err := other.Get(session.ID); !errors.Is(err, ErrWrongInstance) || !strings.Contains(err.Error(), "instance host-1") {
+		t.Errorf("Get() error = %v, want ErrWrongInstance naming host-1", err)
+	}
+	if _, err := other.Append(session.ID, 0, strings.NewReader("hello")); !errors.Is(err, ErrWrongInstance) {
+		t.Errorf("Append() error = %v, want ErrWrongInstance", err)
+	}
+
+	shared := store
+	shared.Instance = "host-2"
+	if _, err := shared.Append(session.ID, 0, strings.NewReader("hello")); err != nil {
+		t.Errorf("Append() error = %v, want the session found in the shared folder", err)
+	}
+
+	key := strings.TrimPrefix(session.ID, "host-1.")
+	for _, id := range []string{"." + key, "Host-1." + key, "host_1." + key, "host-1.", "host-1.." + key, "host-1/." + key} {
+		t.Run(id, func(t *testing.T) {
+			if _, err := other.Get(id); !errors.Is(err, ErrSessionNotFound) {
+				t.Errorf("Get() error = %v, want ErrSessionNotFound", err)
+			}
+		})
+	}
+}